@@ -0,0 +1,91 @@
+// Consensus layer (beacon chain) blocks and reorgs, as reported by a beacon node
+package analysis
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// BeaconBlock is a beacon chain block, with a reference to the execution layer block included in it
+type BeaconBlock struct {
+	Slot          uint64
+	Root          common.Hash
+	ParentRoot    common.Hash
+	ProposerIndex uint64
+
+	ExecutionBlockHash   common.Hash // zero for blocks without execution payload (pre-merge)
+	ExecutionBlockNumber uint64
+
+	NodeUri               string
+	ObservedUnixTimestamp int64
+}
+
+func (b *BeaconBlock) String() string {
+	return fmt.Sprintf("BeaconBlock slot %d %s / proposer: %d / execution block: %d %s", b.Slot, b.Root, b.ProposerIndex, b.ExecutionBlockNumber, b.ExecutionBlockHash)
+}
+
+// BeaconReorg is a chain_reorg event of a beacon node: the head moved from OldHeadBlock to NewHeadBlock, which
+// is not a descendant of the old head.
+type BeaconReorg struct {
+	Slot  uint64
+	Epoch uint64
+	Depth uint64
+
+	OldHeadBlock common.Hash
+	NewHeadBlock common.Hash
+	OldHeadState common.Hash
+	NewHeadState common.Hash
+
+	NodeUri               string
+	ObservedUnixTimestamp int64
+
+	Reorg *Reorg // matching execution layer reorg, if it was already finished when the beacon node reported this one
+}
+
+func (r *BeaconReorg) Id() string {
+	return fmt.Sprintf("slot%d_d%d_%s", r.Slot, r.Depth, r.NewHeadBlock.Hex()[2:10])
+}
+
+func (r *BeaconReorg) String() string {
+	return fmt.Sprintf("BeaconReorg %s: slot=%d, epoch=%d, depth=%d, old head: %s, new head: %s, node: %s", r.Id(), r.Slot, r.Epoch, r.Depth, r.OldHeadBlock, r.NewHeadBlock, r.NodeUri)
+}
+
+type DiscrepancyKind string
+
+const (
+	DiscrepancyBeaconReorgOnly    DiscrepancyKind = "BeaconReorgWithoutExecutionReorg"
+	DiscrepancyExecutionReorgOnly DiscrepancyKind = "ExecutionReorgWithoutBeaconReorg"
+)
+
+// ReorgDiscrepancy is raised when a reorg was seen on only one of the layers: a beacon chain_reorg event without a
+// matching execution layer reorg (Reorg is nil), or the reverse (BeaconReorg is nil).
+type ReorgDiscrepancy struct {
+	Kind        DiscrepancyKind
+	Reorg       *Reorg
+	BeaconReorg *BeaconReorg
+}
+
+func (d *ReorgDiscrepancy) String() string {
+	if d.Reorg != nil {
+		return fmt.Sprintf("ReorgDiscrepancy %s: %s", d.Kind, d.Reorg.Id())
+	}
+	return fmt.Sprintf("ReorgDiscrepancy %s: %s", d.Kind, d.BeaconReorg.Id())
+}
+
+// Matches returns true if the old or new head of the beacon reorg contains one of the execution blocks of the reorg
+func (r *BeaconReorg) Matches(reorg *Reorg, beaconBlockByRoot map[common.Hash]*BeaconBlock) bool {
+	for _, root := range []common.Hash{r.OldHeadBlock, r.NewHeadBlock} {
+		beaconBlock, found := beaconBlockByRoot[root]
+		if !found {
+			continue
+		}
+		if _, isInvolved := reorg.BlocksInvolved[beaconBlock.ExecutionBlockHash]; isInvolved {
+			return true
+		}
+		if reorg.FirstBlockAfterReorg != nil && reorg.FirstBlockAfterReorg.Hash == beaconBlock.ExecutionBlockHash {
+			return true
+		}
+	}
+	return false
+}
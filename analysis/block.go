@@ -15,8 +15,23 @@ const (
 	OriginSubscription BlockOrigin = "Subscription"
 	OriginGetParent    BlockOrigin = "GetParent"
 	OriginUncle        BlockOrigin = "Uncle"
+	OriginBeacon       BlockOrigin = "Beacon"
+	OriginPoll         BlockOrigin = "Poll"
+	OriginBackfill     BlockOrigin = "Backfill" // downloaded on startup, not seen as head by the monitor
+	OriginGapFill      BlockOrigin = "GapFill"  // downloaded by number to fill a missing height, not seen as head by the monitor
 )
 
+// IsLive returns true if the block was seen while it was a head, or downloaded as a canonical block of a node (and not
+// only referenced as uncle). Like parents, backfilled and gap filling blocks are canonical blocks fetched on demand.
+func (origin BlockOrigin) IsLive() bool {
+	switch origin {
+	case OriginSubscription, OriginGetParent, OriginBeacon, OriginPoll, OriginBackfill, OriginGapFill:
+		return true
+	default:
+		return false
+	}
+}
+
 // Block is an geth Block and information about where it came from
 type Block struct {
 	Block                 *types.Block
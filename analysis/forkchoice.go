@@ -0,0 +1,121 @@
+// Fork choice strategies decide which of several competing blocks is the head of the chain
+package analysis
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ForkChoice compares two tree nodes: a result > 0 means a is preferred over b, < 0 means b is preferred,
+// and 0 means the strategy cannot (yet) decide between them.
+type ForkChoice interface {
+	Name() string
+	Compare(a, b *TreeNode) int
+}
+
+// LongestChain prefers the block at the highest height
+type LongestChain struct{}
+
+func (fc LongestChain) Name() string {
+	return "longest"
+}
+
+func (fc LongestChain) Compare(a, b *TreeNode) int {
+	switch {
+	case a.Block.Number > b.Block.Number:
+		return 1
+	case a.Block.Number < b.Block.Number:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// TotalDifficulty prefers the block with the highest accumulated PoW difficulty. Since post-merge blocks have
+// a difficulty of 0, ties are resolved by height. The difficulty is only accumulated from the first known ancestor
+// (see TreeNode.TotalDifficulty), not from genesis, so it is only comparable between blocks with the same root.
+type TotalDifficulty struct{}
+
+func (fc TotalDifficulty) Name() string {
+	return "total-difficulty"
+}
+
+func (fc TotalDifficulty) Compare(a, b *TreeNode) int {
+	if cmp := a.TotalDifficulty.Cmp(b.TotalDifficulty); cmp != 0 {
+		return cmp
+	}
+	return LongestChain{}.Compare(a, b)
+}
+
+// CanonicalByNode prefers the block that a specific node reports as canonical at its height. The fork choice runs
+// while the tree is updated, so it doesn't ask the node: the canonical blocks are set from the node's new heads with
+// SetHead, after which the tree re-evaluates its head for the changed blocks (BlockTree.UpdateHeadUnder). If the node reports neither or both
+// blocks as canonical, it falls back to the longest chain.
+type CanonicalByNode struct {
+	NodeUri         string
+	CanonicalHashes map[uint64]common.Hash
+}
+
+func NewCanonicalByNode(nodeUri string) *CanonicalByNode {
+	return &CanonicalByNode{NodeUri: nodeUri, CanonicalHashes: make(map[uint64]common.Hash)}
+}
+
+func (fc *CanonicalByNode) Name() string {
+	return "canonical:" + fc.NodeUri
+}
+
+func (fc *CanonicalByNode) Compare(a, b *TreeNode) int {
+	aIsCanonical := fc.isCanonical(a)
+	bIsCanonical := fc.isCanonical(b)
+	switch {
+	case aIsCanonical && !bIsCanonical:
+		return 1
+	case !aIsCanonical && bIsCanonical:
+		return -1
+	default:
+		return LongestChain{}.Compare(a, b)
+	}
+}
+
+func (fc *CanonicalByNode) isCanonical(node *TreeNode) bool {
+	hash, found := fc.CanonicalHashes[node.Block.Number]
+	return found && hash == node.Block.Hash
+}
+
+// SetHead marks a new head of the node and its ancestors as canonical. Heights above the head are no longer canonical.
+// Ancestors of a canonical block are canonical, so only the blocks up to the first one that already was are marked.
+// Returns the hashes of the blocks whose canonical status changed.
+func (fc *CanonicalByNode) SetHead(head *TreeNode) (changed []common.Hash) {
+	for number, hash := range fc.CanonicalHashes {
+		if number > head.Block.Number {
+			delete(fc.CanonicalHashes, number)
+			changed = append(changed, hash)
+		}
+	}
+	for node := head; node != nil && !fc.isCanonical(node); node = node.Parent {
+		changed = append(changed, fc.SetCanonical(node.Block.Number, node.Block.Hash)...)
+	}
+	return changed
+}
+
+// SetCanonical marks a block the node served by number as canonical at its height. Returns the hashes of the blocks
+// whose canonical status changed.
+func (fc *CanonicalByNode) SetCanonical(number uint64, hash common.Hash) (changed []common.Hash) {
+	previous, found := fc.CanonicalHashes[number]
+	if found && previous == hash {
+		return nil
+	}
+	fc.CanonicalHashes[number] = hash
+	if found {
+		changed = append(changed, previous)
+	}
+	return append(changed, hash)
+}
+
+// Forget removes the canonical hashes below a height
+func (fc *CanonicalByNode) Forget(below uint64) {
+	for number := range fc.CanonicalHashes {
+		if number < below {
+			delete(fc.CanonicalHashes, number)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package analysis
+
+import (
+	"math/big"
+	"testing"
+)
+
+func newTestNode(number, totalDifficulty int64) *TreeNode {
+	return &TreeNode{Block: &Block{Number: uint64(number)}, TotalDifficulty: big.NewInt(totalDifficulty)}
+}
+
+func TestLongestChain(t *testing.T) {
+	fc := LongestChain{}
+	if fc.Compare(newTestNode(2, 0), newTestNode(1, 0)) != 1 || fc.Compare(newTestNode(1, 0), newTestNode(2, 0)) != -1 || fc.Compare(newTestNode(1, 0), newTestNode(1, 0)) != 0 {
+		t.Error("expected the higher block to be preferred, and a tie at the same height")
+	}
+}
+
+func TestTotalDifficulty(t *testing.T) {
+	fc := TotalDifficulty{}
+	if fc.Compare(newTestNode(1, 10), newTestNode(2, 5)) != 1 {
+		t.Error("expected the heavier, shorter chain to be preferred")
+	}
+	if fc.Compare(newTestNode(2, 0), newTestNode(1, 0)) != 1 || fc.Compare(newTestNode(1, 0), newTestNode(1, 0)) != 0 {
+		t.Error("expected equal difficulties to be compared by height")
+	}
+}
+
+func TestCanonicalByNode(t *testing.T) {
+	blocks := newTestChain(t, "A2 B3")
+	fc := NewCanonicalByNode("ws://node")
+	tree := NewBlockTreeWithForkChoice(fc)
+	addTestBlocks(t, tree, blocks, "P", "A1", "A2", "B1", "B2", "B3")
+
+	// Without canonical blocks, the longest chain wins
+	if len(tree.HeadNodes) != 1 || tree.HeadNodes[0].Block != blocks["B3"] {
+		t.Fatalf("expected head B3, got %v", tree.HeadNodes)
+	}
+
+	// The node's head is preferred over a longer chain
+	tree.UpdateHeadUnder(fc.SetHead(tree.NodeByHash[blocks["A2"].Hash]))
+	if len(tree.HeadNodes) != 1 || tree.HeadNodes[0].Block != blocks["A2"] || tree.NodeByHash[blocks["B1"].Hash].IsMainChain {
+		t.Fatalf("expected head A2 after the node's head, got %v", tree.HeadNodes)
+	}
+	if len(fc.CanonicalHashes) != 3 || fc.CanonicalHashes[101] != blocks["A1"].Hash {
+		t.Errorf("expected P, A1 and A2 to be canonical, got %v", fc.CanonicalHashes)
+	}
+
+	// A lower head of the node makes the heights above it non-canonical
+	changed := fc.SetHead(tree.NodeByHash[blocks["B1"].Hash])
+	if len(changed) != 3 {
+		t.Errorf("expected A1, A2 and B1 to change, got %v", changed)
+	}
+	tree.UpdateHeadUnder(changed)
+	if len(fc.CanonicalHashes) != 2 || tree.HeadNodes[0].Block != blocks["B3"] || !tree.NodeByHash[blocks["B1"].Hash].IsMainChain {
+		t.Errorf("expected head B3 after the node switched to B1, got %v (canonical: %v)", tree.HeadNodes, fc.CanonicalHashes)
+	}
+
+	// A new head on top of the canonical chain only changes itself
+	changed = fc.SetHead(tree.NodeByHash[blocks["B2"].Hash])
+	if len(changed) != 1 || changed[0] != blocks["B2"].Hash {
+		t.Errorf("expected only B2 to change, got %v", changed)
+	}
+	tree.UpdateHeadUnder(changed)
+	if len(tree.HeadNodes) != 1 || tree.HeadNodes[0].Block != blocks["B3"] {
+		t.Errorf("expected head B3, got %v", tree.HeadNodes)
+	}
+	if changed = fc.SetCanonical(102, blocks["B2"].Hash); len(changed) != 0 {
+		t.Errorf("expected no change for a known canonical block, got %v", changed)
+	}
+	fc.SetHead(tree.NodeByHash[blocks["B1"].Hash])
+
+	fc.Forget(101)
+	if len(fc.CanonicalHashes) != 1 {
+		t.Errorf("expected only B1 to be canonical, got %v", fc.CanonicalHashes)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -16,22 +17,112 @@ type Reorg struct {
 	StartBlockHeight uint64 // first block in a reorg (block number after common parent)
 	EndBlockHeight   uint64 // last block in a reorg
 
-	Chains map[common.Hash][]*Block
+	Chains   map[common.Hash][]*Block // blocks of each branch, by the hash of its first block
+	Branches []*Branch                // branches starting at the common parent, with the branches forking off them
 
 	Depth int
 
 	BlocksInvolved    map[common.Hash]*Block
-	MainChainHash     common.Hash
+	MainChainHash     common.Hash // first block of the main chain
 	MainChainBlocks   map[common.Hash]*Block
-	NumReplacedBlocks int
+	NumReplacedBlocks int // without the blocks replaced in finished nested reorgs (see TreeAnalysis)
 	EthNodesInvolved  map[string]bool
 
 	CommonParent         *Block
 	FirstBlockAfterReorg *Block
+
+	Parent   *Reorg   // reorg this one is nested in, if its common parent is part of another reorg (set by TreeAnalysis)
+	Children []*Reorg // reorgs nested in this one, ordered by height
+
+	BeaconBlocks map[common.Hash]*BeaconBlock // slots of the involved blocks (key: execution block hash)
+	BeaconReorgs []*BeaconReorg               // matching chain_reorg events of beacon nodes
+
+	TxDiff *TxDiff // dropped, re-included and new transactions (set by the monitor when it sends the finished reorg)
 }
 
-func NewReorg(parentNode *TreeNode) (*Reorg, error) {
-	if len(parentNode.Children) < 2 {
+// Branch is a linear part of a reorg. It starts at a fork, and ends at the next fork or at the tip.
+type Branch struct {
+	Blocks      []*Block  // ordered by height
+	Parent      *Branch   // nil if the branch starts at the common parent
+	Children    []*Branch // branches forking off the last block
+	IsMainChain bool
+
+	tipNumber    uint64 // highest block number in the branch and its children
+	isForkChoice bool   // the first block is on the main chain of the tree
+}
+
+// newBranch follows the chain starting at node until the next fork, and adds a child branch for each fork
+func newBranch(node *TreeNode, parent *Branch, endBlockHeight uint64) *Branch {
+	branch := &Branch{Parent: parent, Children: []*Branch{}, isForkChoice: node.IsMainChain}
+	for {
+		branch.Blocks = append(branch.Blocks, node.Block)
+		branch.tipNumber = node.Block.Number
+
+		children := []*TreeNode{}
+		for _, child := range node.Children {
+			if child.Block.Number <= endBlockHeight {
+				children = append(children, child)
+			}
+		}
+		if len(children) != 1 {
+			for _, child := range children {
+				childBranch := newBranch(child, branch, endBlockHeight)
+				branch.Children = append(branch.Children, childBranch)
+				if childBranch.tipNumber > branch.tipNumber {
+					branch.tipNumber = childBranch.tipNumber
+				}
+			}
+			sortBranches(branch.Children)
+			return branch
+		}
+		node = children[0]
+	}
+}
+
+func (b *Branch) Hash() common.Hash {
+	return b.Blocks[0].Hash
+}
+
+// walk calls fn for the branch and all branches forking off it
+func (b *Branch) walk(fn func(branch *Branch)) {
+	fn(b)
+	for _, child := range b.Children {
+		child.walk(fn)
+	}
+}
+
+func sortBranches(branches []*Branch) {
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Hash().Hex() < branches[j].Hash().Hex() })
+}
+
+// selectMainBranch returns the branch selected by the tree's fork choice. If it can't decide yet, the branch reaching
+// the highest block is used, and nil is returned if that's a tie.
+func selectMainBranch(branches []*Branch) (main *Branch) {
+	for _, branch := range branches {
+		if branch.isForkChoice {
+			return branch
+		}
+	}
+
+	isTie := false
+	for _, branch := range branches {
+		if main == nil || branch.tipNumber > main.tipNumber {
+			main, isTie = branch, false
+		} else if branch.tipNumber == main.tipNumber {
+			isTie = true
+		}
+	}
+	if isTie {
+		return nil
+	}
+	return main
+}
+
+// NewReorg builds the reorg starting at parentNode, taking into account only blocks up to endBlockHeight. Forks in the
+// side chains are part of the reorg, because all their blocks were replaced. Forks off the main chain are not, they
+// are reorgs nested in this one (see TreeAnalysis).
+func NewReorg(parentNode *TreeNode, endBlockHeight uint64) (*Reorg, error) {
+	if parentNode.NumChildrenUntil(endBlockHeight) < 2 {
 		return nil, fmt.Errorf("cannot create reorg because parent node with < 2 children")
 	}
 
@@ -40,70 +131,83 @@ func NewReorg(parentNode *TreeNode) (*Reorg, error) {
 		StartBlockHeight: parentNode.Block.Number + 1,
 
 		Chains:           make(map[common.Hash][]*Block),
+		Branches:         []*Branch{},
 		BlocksInvolved:   make(map[common.Hash]*Block),
 		EthNodesInvolved: make(map[string]bool),
 		MainChainBlocks:  make(map[common.Hash]*Block),
+		Children:         []*Reorg{},
+		BeaconBlocks:     make(map[common.Hash]*BeaconBlock),
+		BeaconReorgs:     []*BeaconReorg{},
 
 		SeenLive: true, // will be set to false if any of the added blocks was received via uncle-info
 	}
 
-	// Build the individual chains until the enc, by iterating over children recursively
-	for _, chainRootNode := range parentNode.Children {
-		chain := make([]*Block, 0)
-
-		var addChildToChainRecursive func(node *TreeNode)
-		addChildToChainRecursive = func(node *TreeNode) {
-			chain = append(chain, node.Block)
-
-			for _, childNode := range node.Children {
-				addChildToChainRecursive(childNode)
-			}
+	// Build the branches until the end height
+	for _, childNode := range parentNode.Children {
+		if childNode.Block.Number <= endBlockHeight {
+			reorg.Branches = append(reorg.Branches, newBranch(childNode, nil, endBlockHeight))
 		}
-		addChildToChainRecursive(chainRootNode)
-		reorg.Chains[chainRootNode.Block.Hash] = chain
 	}
+	sortBranches(reorg.Branches)
 
-	// Find depth of chains
-	chainLengths := []int{}
-	for _, chain := range reorg.Chains {
-		chainLengths = append(chainLengths, len(chain))
+	// The main chain follows the fork choice (or the longest branch) at every fork
+	mainBranch := selectMainBranch(reorg.Branches)
+	if mainBranch != nil {
+		reorg.MainChainHash = mainBranch.Hash()
 	}
-	sort.Sort(sort.Reverse(sort.IntSlice(chainLengths)))
-
-	// Depth is number of blocks in second chain
-	reorg.Depth = chainLengths[1]
 
-	// Truncate the longest chain to the second, which is when the reorg actually stopped
-	for key, chain := range reorg.Chains {
-		if len(chain) > reorg.Depth {
-			reorg.FirstBlockAfterReorg = chain[reorg.Depth] // first block that will be truncated
-			reorg.Chains[key] = chain[:reorg.Depth]
-			reorg.MainChainHash = key
+	// Depth is the number of blocks of the longest side chain
+	for _, branch := range reorg.Branches {
+		if branch != mainBranch && int(branch.tipNumber-parentNode.Block.Number) > reorg.Depth {
+			reorg.Depth = int(branch.tipNumber - parentNode.Block.Number)
 		}
 	}
 
-	// If two chains with same height, then the reorg isn't yet finalized
-	if chainLengths[0] == chainLengths[1] {
-		reorg.IsFinished = false
-	} else {
-		reorg.IsFinished = true
-	}
+	// Truncate the main chain to the depth, which is when the reorg actually stopped, and drop the branches forking
+	// off it. If the main chain isn't longer than the side chains yet, the reorg isn't yet finalized.
+	lastHeight := parentNode.Block.Number + uint64(reorg.Depth)
+	for branch := mainBranch; branch != nil; {
+		branch.IsMainChain = true
+		next := selectMainBranch(branch.Children)
+		for i, block := range branch.Blocks {
+			if block.Number > lastHeight {
+				reorg.FirstBlockAfterReorg = block
+				branch.Blocks = branch.Blocks[:i]
+				next = nil
+				break
+			}
+			reorg.MainChainBlocks[block.Hash] = block
+			reorg.EndBlockHeight = block.Number
+		}
+		if next != nil && next.Blocks[0].Number > lastHeight {
+			reorg.FirstBlockAfterReorg = next.Blocks[0]
+			next = nil
+		}
 
-	// Build final list of involved blocks, and get end blockheight
-	for chainHash, chain := range reorg.Chains {
-		for _, block := range chain {
-			reorg.BlocksInvolved[block.Hash] = block
-			reorg.EthNodesInvolved[block.NodeUri] = true
+		branch.Children = []*Branch{}
+		if next != nil {
+			branch.Children = append(branch.Children, next)
+		}
+		branch = next
+	}
+	reorg.IsFinished = reorg.FirstBlockAfterReorg != nil
 
-			if block.Origin != OriginSubscription && block.Origin != OriginGetParent {
-				reorg.SeenLive = false
+	// Build final list of involved blocks
+	for _, topBranch := range reorg.Branches {
+		topBranch.walk(func(branch *Branch) {
+			if len(branch.Blocks) == 0 {
+				return
 			}
+			reorg.Chains[branch.Hash()] = branch.Blocks
+			for _, block := range branch.Blocks {
+				reorg.BlocksInvolved[block.Hash] = block
+				reorg.EthNodesInvolved[block.NodeUri] = true
 
-			if chainHash == reorg.MainChainHash {
-				reorg.MainChainBlocks[block.Hash] = block
-				reorg.EndBlockHeight = block.Number
+				if !block.Origin.IsLive() {
+					reorg.SeenLive = false
+				}
 			}
-		}
+		})
 	}
 
 	reorg.NumReplacedBlocks = len(reorg.BlocksInvolved) - reorg.Depth
@@ -111,6 +215,29 @@ func NewReorg(parentNode *TreeNode) (*Reorg, error) {
 	return &reorg, nil
 }
 
+// IsNested returns true if the reorg happened inside the blocks of another reorg
+func (r *Reorg) IsNested() bool {
+	return r.Parent != nil
+}
+
+// FinishedChildren returns the finished reorgs nested in this one. The id of an unfinished reorg changes while it
+// grows, so only finished ones can be referenced.
+func (r *Reorg) FinishedChildren() []*Reorg {
+	children := []*Reorg{}
+	for _, child := range r.Children {
+		if child.IsFinished {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// IsMainChainBranch returns true if the chain with the given key (see Chains) is part of the main chain
+func (r *Reorg) IsMainChainBranch(key common.Hash) bool {
+	_, isMainChain := r.MainChainBlocks[key]
+	return isMainChain
+}
+
 func (r *Reorg) Id() string {
 	id := fmt.Sprintf("%d_%d_d%d_b%d", r.StartBlockHeight, r.EndBlockHeight, r.Depth, len(r.BlocksInvolved))
 	if r.SeenLive {
@@ -121,17 +248,74 @@ func (r *Reorg) Id() string {
 
 func (r *Reorg) String() string {
 	nodeUris := reflect.ValueOf(r.EthNodesInvolved).MapKeys()
-	return fmt.Sprintf("Reorg %s: live=%-5v chains=%d, depth=%d, replaced=%d, nodes: %v", r.Id(), r.SeenLive, len(r.Chains), r.Depth, r.NumReplacedBlocks, nodeUris)
+	ret := fmt.Sprintf("Reorg %s: live=%-5v chains=%d, depth=%d, replaced=%d, nodes: %v, beacon reorgs: %d", r.Id(), r.SeenLive, len(r.Chains), r.Depth, r.NumReplacedBlocks, nodeUris, len(r.BeaconReorgs))
+	if r.Parent != nil {
+		ret += fmt.Sprintf(", nested in %s", r.Parent.Id())
+	}
+	if len(r.Children) > 0 {
+		ret += fmt.Sprintf(", nested reorgs: %d", len(r.Children))
+	}
+	return ret
+}
+
+// PrintChains prints a line for each chain, indented by the fork depth of its branch
+func (r *Reorg) PrintChains() {
+	for _, topBranch := range r.Branches {
+		topBranch.walk(func(branch *Branch) {
+			indent := ""
+			for parent := branch.Parent; parent != nil; parent = parent.Parent {
+				indent += "  "
+			}
+			if branch.IsMainChain {
+				fmt.Printf("%s- mainchain l=%d: ", indent, len(branch.Blocks))
+			} else {
+				fmt.Printf("%s- sidechain l=%d: ", indent, len(branch.Blocks))
+			}
+			for _, block := range branch.Blocks {
+				fmt.Printf("%s ", block.Hash)
+			}
+			fmt.Print("\n")
+		})
+	}
 }
 
+// MermaidSyntax returns a state diagram of the blocks of the reorg and the reorgs nested in it. The common parents of
+// finished nested reorgs are annotated with their id.
 func (r *Reorg) MermaidSyntax() string {
-	ret := "stateDiagram-v2\n"
+	blocks := make(map[common.Hash]*Block)
+	notes := []string{}
+	var addReorg func(reorg *Reorg)
+	addReorg = func(reorg *Reorg) {
+		for hash, block := range reorg.BlocksInvolved {
+			blocks[hash] = block
+		}
+		if reorg.FirstBlockAfterReorg != nil {
+			blocks[reorg.FirstBlockAfterReorg.Hash] = reorg.FirstBlockAfterReorg
+		}
+		for _, child := range reorg.Children {
+			if child.IsFinished {
+				notes = append(notes, fmt.Sprintf("    note right of %s: nested reorg %s", child.CommonParent.Hash, child.Id()))
+			}
+			addReorg(child)
+		}
+	}
+	addReorg(r)
 
-	for _, block := range r.BlocksInvolved {
-		ret += fmt.Sprintf("    %s --> %s\n", block.ParentHash, block.Hash)
+	sortedBlocks := make([]*Block, 0, len(blocks))
+	for _, block := range blocks {
+		sortedBlocks = append(sortedBlocks, block)
 	}
+	sort.Slice(sortedBlocks, func(i, j int) bool {
+		if sortedBlocks[i].Number != sortedBlocks[j].Number {
+			return sortedBlocks[i].Number < sortedBlocks[j].Number
+		}
+		return sortedBlocks[i].Hash.Hex() < sortedBlocks[j].Hash.Hex()
+	})
 
-	// Add first block after reorg
-	ret += fmt.Sprintf("    %s --> %s", r.FirstBlockAfterReorg.ParentHash, r.FirstBlockAfterReorg.Hash)
-	return ret
+	lines := []string{"stateDiagram-v2"}
+	for _, block := range sortedBlocks {
+		lines = append(lines, fmt.Sprintf("    %s --> %s", block.ParentHash, block.Hash))
+	}
+	lines = append(lines, notes...)
+	return strings.Join(lines, "\n")
 }
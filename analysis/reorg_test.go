@@ -0,0 +1,149 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// newTestReorg adds the blocks to a tree with the fork choice, and returns the reorg at P up to height 100+numBlocks
+func newTestReorg(t *testing.T, forkChoice ForkChoice, blocks map[string]*Block, numBlocks uint64, labels ...string) *Reorg {
+	t.Helper()
+	tree := NewBlockTreeWithForkChoice(forkChoice)
+	addTestBlocks(t, tree, blocks, labels...)
+	reorg, err := NewReorg(tree.NodeByHash[blocks["P"].Hash], 100+numBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reorg
+}
+
+func TestSelectMainBranch(t *testing.T) {
+	a := &Branch{Blocks: []*Block{{Hash: common.Hash{1}}}, tipNumber: 103}
+	b := &Branch{Blocks: []*Block{{Hash: common.Hash{2}}}, tipNumber: 102}
+	c := &Branch{Blocks: []*Block{{Hash: common.Hash{3}}}, tipNumber: 103}
+
+	if main := selectMainBranch([]*Branch{a, b}); main != a {
+		t.Errorf("expected the longest branch, got %v", main)
+	}
+	if main := selectMainBranch([]*Branch{a, b, c}); main != nil {
+		t.Errorf("expected no main branch on a tie, got %v", main)
+	}
+
+	b.isForkChoice = true
+	if main := selectMainBranch([]*Branch{a, b, c}); main != b {
+		t.Errorf("expected the branch of the fork choice, got %v", main)
+	}
+}
+
+func TestNewReorgTie(t *testing.T) {
+	blocks := newTestChain(t, "A2 B2")
+	reorg := newTestReorg(t, LongestChain{}, blocks, 2, "P", "A1", "B1", "A2", "B2")
+
+	if reorg.MainChainHash != (common.Hash{}) || len(reorg.MainChainBlocks) != 0 || reorg.IsFinished {
+		t.Errorf("expected an unfinished reorg without main chain, got %s", reorg)
+	}
+	if reorg.Depth != 2 || len(reorg.Chains) != 2 || len(reorg.BlocksInvolved) != 4 {
+		t.Errorf("expected 2 side chains of depth 2, got %s", reorg)
+	}
+}
+
+func TestNewReorgFinished(t *testing.T) {
+	blocks := newTestChain(t, "A4 B2 C1@B1")
+	reorg := newTestReorg(t, LongestChain{}, blocks, 4, "P", "A1", "B1", "A2", "B2", "C1", "A3", "A4")
+
+	if !reorg.IsFinished || reorg.FirstBlockAfterReorg != blocks["A3"] || reorg.MainChainHash != blocks["A1"].Hash {
+		t.Fatalf("expected a finished reorg on A, got %s", reorg)
+	}
+	if reorg.StartBlockHeight != 101 || reorg.EndBlockHeight != 102 || reorg.Depth != 2 || reorg.NumReplacedBlocks != 3 {
+		t.Errorf("expected 3 blocks replaced by A1 and A2, got %s", reorg)
+	}
+	// The fork of the side chain is part of the reorg
+	if len(reorg.Chains) != 4 || len(reorg.Branches) != 2 {
+		t.Errorf("expected chains A, B1, B2 and C1 in 2 branches, got %d in %d", len(reorg.Chains), len(reorg.Branches))
+	}
+}
+
+func TestNewReorgForkChoicePrefersShorterBranch(t *testing.T) {
+	// A has more difficulty, B more blocks
+	blocks := newTestChain(t, "A2:d5 B3")
+	labels := []string{"P", "A1", "B1", "A2", "B2", "B3"}
+
+	reorg := newTestReorg(t, TotalDifficulty{}, blocks, 3, labels...)
+	if reorg.MainChainHash != blocks["A1"].Hash || reorg.IsFinished {
+		t.Errorf("expected an unfinished reorg with main chain A, got %s", reorg)
+	}
+	if reorg.Depth != 3 || !reorg.IsMainChainBranch(blocks["A1"].Hash) || reorg.IsMainChainBranch(blocks["B1"].Hash) {
+		t.Errorf("expected side chain B of depth 3, got %s", reorg)
+	}
+
+	if reorg := newTestReorg(t, LongestChain{}, blocks, 3, labels...); reorg.MainChainHash != blocks["B1"].Hash || reorg.Depth != 2 {
+		t.Errorf("expected main chain B with the longest chain fork choice, got %s", reorg)
+	}
+}
+
+func TestFinishedChildren(t *testing.T) {
+	blocks := newTestChain(t, "A2")
+	finished := &Reorg{IsFinished: true, CommonParent: blocks["A1"], StartBlockHeight: 102, EndBlockHeight: 102, Depth: 1}
+	unfinished := &Reorg{CommonParent: blocks["A2"], StartBlockHeight: 103, EndBlockHeight: 103, Depth: 1}
+	reorg := &Reorg{IsFinished: true, CommonParent: blocks["P"], Children: []*Reorg{finished, unfinished}}
+
+	if children := reorg.FinishedChildren(); len(children) != 1 || children[0] != finished {
+		t.Errorf("expected only the finished child, got %v", children)
+	}
+	mermaid := reorg.MermaidSyntax()
+	if !strings.Contains(mermaid, "nested reorg "+finished.Id()) || strings.Count(mermaid, "nested reorg") != 1 {
+		t.Errorf("expected a note for the finished child only:\n%s", mermaid)
+	}
+}
+
+func TestNestedReorgReplacedBlocks(t *testing.T) {
+	testCases := []struct {
+		description     string
+		parentReplaced  int
+		nestedReplaced  int
+		nestedFinished  bool
+		nestedStartsOff string // common parent of the nested reorg
+	}{
+		// B1, B2 and B3 are replaced in the outer reorg, C2 only in the reorg nested in the side chain
+		{"A4 B3 C1@B1", 3, 1, true, "B1"},
+		// an unfinished nested reorg isn't sent, so its blocks are counted in the outer reorg
+		{"A4 B2 C1@B1", 3, 1, false, "B1"},
+		// the blocks of a fork of the main chain aren't involved in the outer reorg
+		{"A4 B1 C2@A1", 1, 2, true, "A1"},
+	}
+
+	for _, testCase := range testCases {
+		blocks := newTestChain(t, testCase.description)
+		tree := NewBlockTree()
+		for _, block := range blocks {
+			if err := tree.AddBlock(block); err != nil {
+				t.Fatal(err)
+			}
+		}
+		treeAnalysis, err := NewTreeAnalysis(tree, 100, 104)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var parent, nested *Reorg
+		for _, reorg := range treeAnalysis.Reorgs {
+			if reorg.IsNested() {
+				nested = reorg
+			} else {
+				parent = reorg
+			}
+		}
+		if len(treeAnalysis.Reorgs) != 2 || parent == nil || nested == nil || nested.Parent != parent {
+			t.Errorf("%s: expected a reorg nested in another, got %v", testCase.description, treeAnalysis.Reorgs)
+			continue
+		}
+		if nested.CommonParent != blocks[testCase.nestedStartsOff] || nested.IsFinished != testCase.nestedFinished {
+			t.Errorf("%s: expected nested reorg at %s (finished: %v), got %s", testCase.description, testCase.nestedStartsOff, testCase.nestedFinished, nested)
+		}
+		if parent.NumReplacedBlocks != testCase.parentReplaced || nested.NumReplacedBlocks != testCase.nestedReplaced {
+			t.Errorf("%s: expected %d and %d replaced blocks, got %d and %d", testCase.description, testCase.parentReplaced, testCase.nestedReplaced, parent.NumReplacedBlocks, nested.NumReplacedBlocks)
+		}
+	}
+}
@@ -1,5 +1,9 @@
 // Tree of blocks, used to traverse up (from children to parents) and down (from parents to children).
 // Reorgs start on each node with more than one child.
+//
+// The tree is long-lived and updated incrementally: adding a block only touches the branch it belongs to,
+// blocks may arrive before their parent (they become a root until the parent shows up), and old blocks
+// can be removed again to keep the tree bounded.
 package analysis
 
 import (
@@ -13,69 +17,306 @@ type BlockTree struct {
 	LatestNodes         []*TreeNode // Nodes at latest blockheight (can be more than 1)
 	NodeByHash          map[common.Hash]*TreeNode
 	MainChainNodeByHash map[common.Hash]*TreeNode
+
+	Roots           map[common.Hash]*TreeNode // Nodes without a known parent (FirstNode is the earliest of them)
+	ReorgCandidates map[common.Hash]*TreeNode // Nodes with more than one child
+
+	ForkChoice ForkChoice  // Strategy to select the head of the chain (default: longest chain)
+	HeadNodes  []*TreeNode // Head of the chain according to the fork choice (more than 1 if it can't decide yet)
+
+	nodesByHeight map[uint64][]*TreeNode
+	mainChainTip  *TreeNode // Latest node that is certainly part of the main chain
 }
 
 func NewBlockTree() *BlockTree {
+	return NewBlockTreeWithForkChoice(LongestChain{})
+}
+
+func NewBlockTreeWithForkChoice(forkChoice ForkChoice) *BlockTree {
 	return &BlockTree{
+		ForkChoice:          forkChoice,
+		HeadNodes:           []*TreeNode{},
 		LatestNodes:         []*TreeNode{},
 		NodeByHash:          make(map[common.Hash]*TreeNode),
 		MainChainNodeByHash: make(map[common.Hash]*TreeNode),
+		Roots:               make(map[common.Hash]*TreeNode),
+		ReorgCandidates:     make(map[common.Hash]*TreeNode),
+		nodesByHeight:       make(map[uint64][]*TreeNode),
 	}
 }
 
+// AddBlock inserts a block into the tree. If the block is already known, only the block information is updated
+// (eg. a block first seen as uncle and later through a subscription).
 func (t *BlockTree) AddBlock(block *Block) error {
-	// First block is a special case
-	if t.FirstNode == nil {
-		node := NewTreeNode(block, nil)
-		t.FirstNode = node
-		t.LatestNodes = []*TreeNode{node}
-		t.NodeByHash[block.Hash] = node
+	if node, isKnown := t.NodeByHash[block.Hash]; isKnown {
+		node.Block = block
 		return nil
 	}
 
-	// All other blocks are inserted as child of it's parent parent
-	parent, parentFound := t.NodeByHash[block.ParentHash]
-	if !parentFound {
-		err := fmt.Errorf("error in BlockTree.AddBlock(): parent not found. block: %d %s, parent: %s", block.Number, block.Hash, block.ParentHash)
-		return err
-	}
-
+	// Insert as child of its parent, or as a new root if the parent is unknown
+	parent := t.NodeByHash[block.ParentHash]
 	node := NewTreeNode(block, parent)
 	t.NodeByHash[block.Hash] = node
-	parent.AddChild(node)
+	t.nodesByHeight[block.Number] = append(t.nodesByHeight[block.Number], node)
+	if parent != nil {
+		t.addChild(parent, node)
+	} else {
+		t.Roots[block.Hash] = node
+	}
+
+	// Adopt earlier roots for which this block is the parent
+	headCandidates := []*TreeNode{}
+	for _, root := range t.Roots {
+		if root.Block.ParentHash == block.Hash {
+			delete(t.Roots, root.Block.Hash)
+			root.Parent = node
+			root.IsFirst = false
+			root.updateTotalDifficulty()
+			t.addChild(node, root)
+			if root.IsMainChain {
+				t.markMainChain(node)
+			}
+			headCandidates = append(headCandidates, root.leaves()...)
+		}
+	}
+
+	if len(headCandidates) == 0 {
+		headCandidates = []*TreeNode{node}
+	} else { // adopted subtrees have a new total difficulty, so the fork choice might now prefer a different head
+		headCandidates = append(headCandidates, t.HeadNodes...)
+		t.HeadNodes = []*TreeNode{}
+	}
+
+	if parent == nil {
+		t.updateFirstNode()
+	}
 
 	// Remember nodes at latest block height
-	if len(t.LatestNodes) == 0 {
+	if len(t.LatestNodes) == 0 || block.Number > t.LatestNodes[0].Block.Number { // replace
 		t.LatestNodes = []*TreeNode{node}
-	} else {
-		if block.Number == t.LatestNodes[0].Block.Number { // add to list of latest nodes!
-			t.LatestNodes = append(t.LatestNodes, node)
-		} else if block.Number > t.LatestNodes[0].Block.Number { // replace
-			t.LatestNodes = []*TreeNode{node}
+	} else if block.Number == t.LatestNodes[0].Block.Number { // add to list of latest nodes!
+		t.LatestNodes = append(t.LatestNodes, node)
+	}
+
+	for _, candidate := range headCandidates {
+		t.considerHead(candidate)
+	}
+
+	t.updateMainChain()
+	return nil
+}
+
+// RemoveBlock removes a block from the tree. Its children become roots.
+func (t *BlockTree) RemoveBlock(hash common.Hash) {
+	node, isKnown := t.NodeByHash[hash]
+	if !isKnown {
+		return
+	}
+
+	delete(t.NodeByHash, hash)
+	delete(t.MainChainNodeByHash, hash)
+	delete(t.ReorgCandidates, hash)
+	delete(t.Roots, hash)
+	t.nodesByHeight[node.Block.Number] = removeNode(t.nodesByHeight[node.Block.Number], node)
+	if len(t.nodesByHeight[node.Block.Number]) == 0 {
+		delete(t.nodesByHeight, node.Block.Number)
+	}
+
+	if node.Parent != nil {
+		node.Parent.removeChild(node)
+		if len(node.Parent.Children) < 2 {
+			delete(t.ReorgCandidates, node.Parent.Block.Hash)
 		}
 	}
 
-	// Mark main-chain nodes as such. Step 1: reset all nodes to non-main-chain
-	t.MainChainNodeByHash = make(map[common.Hash]*TreeNode)
-	for _, n := range t.NodeByHash {
-		n.IsMainChain = false
+	// The total difficulty of the subtrees is kept: all their nodes lose the same amount, so comparisons don't change
+	for _, child := range node.Children {
+		child.Parent = nil
+		child.IsFirst = true
+		t.Roots[child.Block.Hash] = child
 	}
 
-	// Step 2: Traverse backwards and mark main chain. If there's more than 1 nodes at latest height, then we don't yet know which chain will be the main-chain
-	if len(t.LatestNodes) == 1 {
-		var TraverseMainChainFromLatestToEarliest func(node *TreeNode)
-		TraverseMainChainFromLatestToEarliest = func(node *TreeNode) {
-			if node == nil {
-				return
+	t.LatestNodes = removeNode(t.LatestNodes, node)
+	if len(t.LatestNodes) == 0 {
+		t.updateLatestNodes()
+	}
+	t.HeadNodes = removeNode(t.HeadNodes, node)
+	if len(t.HeadNodes) == 0 {
+		for _, root := range t.Roots {
+			for _, leaf := range root.leaves() {
+				t.considerHead(leaf)
 			}
-			node.IsMainChain = true
-			t.MainChainNodeByHash[node.Block.Hash] = node
-			TraverseMainChainFromLatestToEarliest(node.Parent)
 		}
-		TraverseMainChainFromLatestToEarliest(t.LatestNodes[0])
 	}
 
-	return nil
+	if t.mainChainTip == node {
+		t.mainChainTip = node.Parent
+	}
+	t.updateMainChain()
+
+	t.updateFirstNode()
+}
+
+// UpdateHead re-evaluates the fork choice for all leaves, eg. after the information it is based on changed
+func (t *BlockTree) UpdateHead() {
+	t.HeadNodes = []*TreeNode{}
+	for _, root := range t.Roots {
+		for _, leaf := range root.leaves() {
+			t.considerHead(leaf)
+		}
+	}
+	t.updateMainChain()
+}
+
+// UpdateHeadUnder re-evaluates the fork choice after the information it is based on changed for some blocks (unknown
+// hashes are ignored). Only the leaves under these blocks are compared to the head nodes. If a head node is among
+// them, it might have become worse than any other leaf, so all leaves are re-evaluated.
+func (t *BlockTree) UpdateHeadUnder(hashes []common.Hash) {
+	changed := make(map[*TreeNode]bool)
+	for _, hash := range hashes {
+		if node, found := t.NodeByHash[hash]; found {
+			changed[node] = true
+		}
+	}
+
+	candidates := []*TreeNode{}
+	for node := range changed {
+		if !changed[node.Parent] { // the leaves of nodes with a changed parent are already included
+			candidates = append(candidates, node.leaves()...)
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	isCandidate := make(map[*TreeNode]bool)
+	for _, candidate := range candidates {
+		isCandidate[candidate] = true
+	}
+	for _, headNode := range t.HeadNodes {
+		if isCandidate[headNode] {
+			t.UpdateHead()
+			return
+		}
+	}
+
+	for _, candidate := range candidates {
+		t.considerHead(candidate)
+	}
+	t.updateMainChain()
+}
+
+// NodesAtHeight returns all nodes with the given block number
+func (t *BlockTree) NodesAtHeight(height uint64) []*TreeNode {
+	return t.nodesByHeight[height]
+}
+
+func (t *BlockTree) addChild(parent, node *TreeNode) {
+	parent.AddChild(node)
+	if len(parent.Children) > 1 {
+		t.ReorgCandidates[parent.Block.Hash] = parent
+	}
+}
+
+// updateLatestNodes finds the nodes at the highest height
+func (t *BlockTree) updateLatestNodes() {
+	var latestHeight uint64
+	for height := range t.nodesByHeight {
+		if height > latestHeight {
+			latestHeight = height
+		}
+	}
+	t.LatestNodes = append([]*TreeNode{}, t.nodesByHeight[latestHeight]...)
+}
+
+func (t *BlockTree) updateFirstNode() {
+	t.FirstNode = nil
+	for _, root := range t.Roots {
+		if t.FirstNode == nil || root.Block.Number < t.FirstNode.Block.Number {
+			t.FirstNode = root
+		}
+	}
+}
+
+// considerHead compares a node to the current head according to the fork choice, and updates the head nodes
+func (t *BlockTree) considerHead(node *TreeNode) {
+	for _, headNode := range t.HeadNodes {
+		if headNode == node {
+			return
+		}
+	}
+
+	if len(t.HeadNodes) == 0 {
+		t.HeadNodes = []*TreeNode{node}
+		return
+	}
+
+	cmp := t.ForkChoice.Compare(node, t.HeadNodes[0])
+	if cmp > 0 { // replace
+		t.HeadNodes = []*TreeNode{node}
+	} else if cmp == 0 { // fork choice can't decide yet
+		t.HeadNodes = append(t.HeadNodes, node)
+	}
+}
+
+// updateMainChain moves the main chain to the head node, or to the common ancestor if there is more than one head
+// node (in that case we don't yet know which chain will be the main chain). Only the nodes between the old and the
+// new tip of the main chain are touched.
+func (t *BlockTree) updateMainChain() {
+	newTip := commonAncestor(t.HeadNodes)
+
+	// Step 1: mark nodes from the new tip back until reaching the existing main chain
+	forkPoint := t.markMainChain(newTip)
+
+	// Step 2: unmark nodes from the old tip back until reaching the fork point
+	for node := t.mainChainTip; node != nil && node != forkPoint; node = node.Parent {
+		node.IsMainChain = false
+		delete(t.MainChainNodeByHash, node.Block.Hash)
+	}
+
+	t.mainChainTip = newTip
+}
+
+// markMainChain marks the node and its ancestors as main chain, and returns the first ancestor which already was
+func (t *BlockTree) markMainChain(node *TreeNode) (forkPoint *TreeNode) {
+	for ; node != nil && !node.IsMainChain; node = node.Parent {
+		node.IsMainChain = true
+		t.MainChainNodeByHash[node.Block.Hash] = node
+	}
+	return node
+}
+
+// commonAncestor returns the latest node that all given nodes descend from (or nil if there is none)
+func commonAncestor(nodes []*TreeNode) *TreeNode {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	ancestor := nodes[0]
+	for _, node := range nodes[1:] {
+		for ancestor != nil && node != nil && ancestor != node {
+			if ancestor.Block.Number >= node.Block.Number {
+				ancestor = ancestor.Parent
+			} else {
+				node = node.Parent
+			}
+		}
+		if ancestor != node {
+			return nil
+		}
+	}
+	return ancestor
+}
+
+// removeNode returns a copy of nodes without node, because the slice might be shared (eg. NodesAtHeight)
+func removeNode(nodes []*TreeNode, node *TreeNode) []*TreeNode {
+	ret := make([]*TreeNode, 0, len(nodes))
+	for _, n := range nodes {
+		if n != node {
+			ret = append(ret, n)
+		}
+	}
+	return ret
 }
 
 func PrintNodeAndChildren(node *TreeNode, depth int) {
@@ -93,12 +334,20 @@ func (t *BlockTree) Print() {
 		return
 	}
 
-	// Print tree by traversing from parent to all children
-	PrintNodeAndChildren(t.FirstNode, 1)
+	// Print tree by traversing from each root to all children
+	for _, root := range t.Roots {
+		PrintNodeAndChildren(root, 1)
+	}
 
 	// Print latest nodes
 	fmt.Printf("Latest nodes:\n")
 	for _, n := range t.LatestNodes {
 		fmt.Println("-", n.String())
 	}
+
+	// Print head nodes
+	fmt.Printf("Head nodes (%s):\n", t.ForkChoice.Name())
+	for _, n := range t.HeadNodes {
+		fmt.Println("-", n.String())
+	}
 }
@@ -0,0 +1,124 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/flashbots/reorg-monitor/testutils/chaingen"
+)
+
+// newTestChain generates blocks from a chaingen description (common parent P at height 100), by label
+func newTestChain(t *testing.T, description string) map[string]*Block {
+	t.Helper()
+	chain, err := chaingen.Generate(100, description)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := make(map[string]*Block)
+	for _, ethBlock := range chain.Blocks {
+		blocks[chain.Label(ethBlock.Hash())] = NewBlock(ethBlock, OriginSubscription, "ws://node", 0)
+	}
+	return blocks
+}
+
+func addTestBlocks(t *testing.T, tree *BlockTree, blocks map[string]*Block, labels ...string) {
+	t.Helper()
+	for _, label := range labels {
+		if err := tree.AddBlock(blocks[label]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBlockTreeAddBlock(t *testing.T) {
+	blocks := newTestChain(t, "A2 B1")
+	tree := NewBlockTree()
+	addTestBlocks(t, tree, blocks, "P", "A1", "B1")
+
+	if len(tree.ReorgCandidates) != 1 || tree.ReorgCandidates[blocks["P"].Hash] == nil {
+		t.Errorf("expected P to be the only reorg candidate, got %d", len(tree.ReorgCandidates))
+	}
+	if len(tree.LatestNodes) != 2 || len(tree.HeadNodes) != 2 {
+		t.Errorf("expected 2 latest and head nodes, got %d and %d", len(tree.LatestNodes), len(tree.HeadNodes))
+	}
+	if len(tree.MainChainNodeByHash) != 1 || !tree.NodeByHash[blocks["P"].Hash].IsMainChain {
+		t.Errorf("expected only P on the main chain while A1 and B1 compete, got %d nodes", len(tree.MainChainNodeByHash))
+	}
+
+	addTestBlocks(t, tree, blocks, "A2", "A2") // the second add only updates the block
+	if len(tree.NodeByHash) != 4 || len(tree.LatestNodes) != 1 || tree.LatestNodes[0].Block != blocks["A2"] {
+		t.Errorf("expected 4 nodes with latest node A2, got %d nodes", len(tree.NodeByHash))
+	}
+	for _, label := range []string{"P", "A1", "A2"} {
+		if !tree.NodeByHash[blocks[label].Hash].IsMainChain {
+			t.Errorf("expected %s on the main chain", label)
+		}
+	}
+	if tree.NodeByHash[blocks["B1"].Hash].IsMainChain {
+		t.Error("expected B1 not on the main chain")
+	}
+}
+
+func TestBlockTreeAdoptsRoots(t *testing.T) {
+	blocks := newTestChain(t, "A3")
+	tree := NewBlockTree()
+
+	// A2 and A3 arrive before their ancestors
+	addTestBlocks(t, tree, blocks, "A3", "A2", "P")
+	if len(tree.Roots) != 2 || tree.FirstNode.Block != blocks["P"] {
+		t.Fatalf("expected roots P and A2 with first node P, got %d roots", len(tree.Roots))
+	}
+
+	addTestBlocks(t, tree, blocks, "A1")
+	if len(tree.Roots) != 1 || tree.FirstNode.Block != blocks["P"] {
+		t.Fatalf("expected only root P, got %d roots", len(tree.Roots))
+	}
+	a2 := tree.NodeByHash[blocks["A2"].Hash]
+	if a2.IsFirst || a2.Parent.Block != blocks["A1"] || a2.TotalDifficulty.Uint64() != 3 {
+		t.Errorf("expected A2 adopted by A1 with total difficulty 3, got %s (td %s)", a2, a2.TotalDifficulty)
+	}
+	if len(tree.HeadNodes) != 1 || tree.HeadNodes[0].Block != blocks["A3"] || len(tree.MainChainNodeByHash) != 4 {
+		t.Errorf("expected head A3 and 4 main chain nodes, got %v and %d", tree.HeadNodes, len(tree.MainChainNodeByHash))
+	}
+}
+
+func TestBlockTreeRemoveBlock(t *testing.T) {
+	blocks := newTestChain(t, "A3 B2")
+	tree := NewBlockTree()
+	addTestBlocks(t, tree, blocks, "P", "A1", "A2", "A3", "B1", "B2")
+
+	// Removing the only latest node makes the nodes below it the latest ones
+	tree.RemoveBlock(blocks["A3"].Hash)
+	if len(tree.LatestNodes) != 2 || tree.LatestNodes[0].Block.Number != 102 {
+		t.Errorf("expected the 2 nodes at 102 to be the latest, got %v", tree.LatestNodes)
+	}
+	if len(tree.HeadNodes) != 2 || len(tree.NodeByHash[blocks["A2"].Hash].Children) != 0 {
+		t.Errorf("expected A2 and B2 as heads, got %v", tree.HeadNodes)
+	}
+	if len(tree.MainChainNodeByHash) != 1 || tree.NodeByHash[blocks["A2"].Hash].IsMainChain || tree.NodeByHash[blocks["A1"].Hash].IsMainChain {
+		t.Errorf("expected only P on the main chain while A2 and B2 compete, got %d nodes", len(tree.MainChainNodeByHash))
+	}
+
+	// Removing the common parent turns its children into roots
+	tree.RemoveBlock(blocks["P"].Hash)
+	if len(tree.Roots) != 2 || len(tree.ReorgCandidates) != 0 || tree.FirstNode.Block.Number != 101 {
+		t.Errorf("expected roots A1 and B1 and no reorg candidates, got %d roots and %d candidates", len(tree.Roots), len(tree.ReorgCandidates))
+	}
+	if a1 := tree.NodeByHash[blocks["A1"].Hash]; !a1.IsFirst || a1.Parent != nil || a1.TotalDifficulty.Uint64() != 2 {
+		t.Errorf("expected root A1 keeping its total difficulty 2, got %s (td %s)", a1, a1.TotalDifficulty)
+	}
+	if tree.NodeByHash[blocks["P"].Hash] != nil || tree.MainChainNodeByHash[blocks["P"].Hash] != nil {
+		t.Error("expected P to be removed")
+	}
+
+	tree.RemoveBlock(blocks["P"].Hash) // unknown blocks are ignored
+	if len(tree.NodeByHash) != 4 {
+		t.Errorf("expected 4 nodes, got %d", len(tree.NodeByHash))
+	}
+
+	// Slices returned earlier are not modified
+	atHeight101 := tree.NodesAtHeight(101)
+	tree.RemoveBlock(blocks["A1"].Hash)
+	if len(atHeight101) != 2 || atHeight101[0].Block != blocks["A1"] || atHeight101[1].Block != blocks["B1"] {
+		t.Errorf("removal modified a slice returned earlier: %v", atHeight101)
+	}
+}
@@ -1,8 +1,9 @@
-// TreeAnalysis takes in a BlockTree and collects information about reorgs
+// TreeAnalysis is a view over a range of heights of a BlockTree and collects information about reorgs
 package analysis
 
 import (
 	"fmt"
+	"sort"
 )
 
 type TreeAnalysis struct {
@@ -16,22 +17,49 @@ type TreeAnalysis struct {
 	NumBlocksMainChain int
 
 	Reorgs map[string]*Reorg
+
+	BlindSpots []BlockRange // heights without any known block, where reorgs could not be detected
+}
+
+// BlockRange is a range of block heights (inclusive)
+type BlockRange struct {
+	Start uint64
+	End   uint64
+}
+
+func (r BlockRange) String() string {
+	if r.Start == r.End {
+		return fmt.Sprintf("%d", r.Start)
+	}
+	return fmt.Sprintf("%d - %d", r.Start, r.End)
 }
 
-func NewTreeAnalysis(t *BlockTree) (*TreeAnalysis, error) {
+// OverlapsBlindSpot returns true if any height between start and end (inclusive) is in a blind spot
+func (a *TreeAnalysis) OverlapsBlindSpot(start, end uint64) bool {
+	for _, blindSpot := range a.BlindSpots {
+		if blindSpot.Start <= end && blindSpot.End >= start {
+			return true
+		}
+	}
+	return false
+}
+
+// NewTreeAnalysis looks for reorgs between start and end height (inclusive). Only the tree's reorg candidates
+// (nodes with more than one child) are visited, so this is cheap even for large trees.
+func NewTreeAnalysis(t *BlockTree, startBlockHeight, endBlockHeight uint64) (*TreeAnalysis, error) {
 	analysis := TreeAnalysis{
 		Tree:   t,
 		Reorgs: make(map[string]*Reorg),
 	}
 
-	if t.FirstNode == nil { // empty analysis for empty tree
+	if t.FirstNode == nil || endBlockHeight < startBlockHeight { // empty analysis for empty tree
 		return &analysis, nil
 	}
 
-	analysis.StartBlockHeight = t.FirstNode.Block.Number
-	analysis.EndBlockHeight = t.LatestNodes[0].Block.Number
+	analysis.StartBlockHeight = startBlockHeight
+	analysis.EndBlockHeight = endBlockHeight
 
-	if len(t.LatestNodes) > 1 {
+	if len(t.NodesAtHeight(endBlockHeight)) > 1 {
 		analysis.IsSplitOngoing = true
 	}
 
@@ -39,40 +67,101 @@ func NewTreeAnalysis(t *BlockTree) (*TreeAnalysis, error) {
 	analysis.NumBlocksMainChain = len(t.MainChainNodeByHash)
 
 	// Find reorgs
-	for _, node := range t.NodeByHash {
-		if len(node.Children) > 1 {
-			reorg, err := NewReorg(node)
-			if err != nil {
-				return nil, err
-			}
-			analysis.Reorgs[reorg.Id()] = reorg
+	for _, node := range t.ReorgCandidates {
+		if node.Block.Number < startBlockHeight || node.Block.Number >= endBlockHeight {
+			continue
 		}
+
+		if node.NumChildrenUntil(endBlockHeight) < 2 {
+			continue
+		}
+
+		reorg, err := NewReorg(node, endBlockHeight)
+		if err != nil {
+			return nil, err
+		}
+		analysis.Reorgs[reorg.Id()] = reorg
 	}
 
+	analysis.nestReorgs()
 	return &analysis, nil
 }
 
+// nestReorgs links each reorg whose common parent is part of another reorg to the innermost such reorg. This is the
+// case for forks of a side chain, and for forks of the main chain inside the reorg.
+//
+// The blocks of a fork of a side chain are also involved in the outer reorg. Those replaced in the nested reorg are
+// counted only there once it is finished (it is sent on its own), so the outer reorg's NumReplacedBlocks excludes them.
+func (a *TreeAnalysis) nestReorgs() {
+	for _, reorg := range a.SortedReorgs() {
+		for _, other := range a.Reorgs {
+			if _, isInvolved := other.BlocksInvolved[reorg.CommonParent.Hash]; !isInvolved {
+				continue
+			}
+			if reorg.Parent == nil || other.CommonParent.Number > reorg.Parent.CommonParent.Number {
+				reorg.Parent = other
+			}
+		}
+		if reorg.Parent != nil {
+			reorg.Parent.Children = append(reorg.Parent.Children, reorg)
+		}
+	}
+
+	numReplacedBlocks := make(map[*Reorg]int) // before excluding the blocks of nested reorgs
+	for _, reorg := range a.Reorgs {
+		numReplacedBlocks[reorg] = reorg.NumReplacedBlocks
+	}
+	for _, reorg := range a.Reorgs {
+		if reorg.Parent == nil || !reorg.IsFinished {
+			continue
+		}
+		if _, isMainChain := reorg.Parent.MainChainBlocks[reorg.CommonParent.Hash]; !isMainChain {
+			reorg.Parent.NumReplacedBlocks -= numReplacedBlocks[reorg]
+		}
+	}
+}
+
+// SortedReorgs returns the reorgs ordered by height, so reorgs come before the reorgs nested in them
+func (a *TreeAnalysis) SortedReorgs() []*Reorg {
+	reorgs := make([]*Reorg, 0, len(a.Reorgs))
+	for _, reorg := range a.Reorgs {
+		reorgs = append(reorgs, reorg)
+	}
+	sort.Slice(reorgs, func(i, j int) bool {
+		if reorgs[i].StartBlockHeight != reorgs[j].StartBlockHeight {
+			return reorgs[i].StartBlockHeight < reorgs[j].StartBlockHeight
+		}
+		return reorgs[i].Id() < reorgs[j].Id()
+	})
+	return reorgs
+}
+
+// TopLevelReorgs returns the reorgs which aren't nested in another reorg, ordered by height
+func (a *TreeAnalysis) TopLevelReorgs() []*Reorg {
+	reorgs := []*Reorg{}
+	for _, reorg := range a.SortedReorgs() {
+		if !reorg.IsNested() {
+			reorgs = append(reorgs, reorg)
+		}
+	}
+	return reorgs
+}
+
 func (a *TreeAnalysis) Print() {
 	fmt.Printf("TreeAnalysis %d - %d, nodes: %d, mainchain: %d, reorgs: %d\n", a.StartBlockHeight, a.EndBlockHeight, a.NumBlocks, a.NumBlocksMainChain, len(a.Reorgs))
 	if a.IsSplitOngoing {
 		fmt.Println("- split ongoing")
 	}
+	for _, blindSpot := range a.BlindSpots {
+		fmt.Println("- blind spot:", blindSpot)
+	}
 
-	for _, reorg := range a.Reorgs {
+	for _, reorg := range a.SortedReorgs() {
 		fmt.Println("")
 		fmt.Println(reorg.String())
-		fmt.Printf("- common parent: %d %s, first block after: %d %s\n", reorg.CommonParent.Number, reorg.CommonParent.Hash, reorg.FirstBlockAfterReorg.Number, reorg.FirstBlockAfterReorg.Hash)
-
-		for chainKey, chain := range reorg.Chains {
-			if chainKey == reorg.MainChainHash {
-				fmt.Printf("- mainchain l=%d: ", len(chain))
-			} else {
-				fmt.Printf("- sidechain l=%d: ", len(chain))
-			}
-			for _, block := range chain {
-				fmt.Printf("%s ", block.Hash)
-			}
-			fmt.Print("\n")
+		if reorg.FirstBlockAfterReorg != nil {
+			fmt.Printf("- common parent: %d %s, first block after: %d %s\n", reorg.CommonParent.Number, reorg.CommonParent.Hash, reorg.FirstBlockAfterReorg.Number, reorg.FirstBlockAfterReorg.Hash)
 		}
+		reorg.PrintChains()
 	}
 }
@@ -1,6 +1,9 @@
 package analysis
 
-import "fmt"
+import (
+	"fmt"
+	"math/big"
+)
 
 type TreeNode struct {
 	Block    *Block
@@ -9,15 +12,19 @@ type TreeNode struct {
 
 	IsFirst     bool
 	IsMainChain bool
+
+	TotalDifficulty *big.Int // sum of difficulties from the first known ancestor up to and including this block (not from genesis)
 }
 
 func NewTreeNode(block *Block, parent *TreeNode) *TreeNode {
-	return &TreeNode{
+	node := &TreeNode{
 		Block:    block,
 		Parent:   parent,
 		Children: []*TreeNode{},
 		IsFirst:  parent == nil,
 	}
+	node.updateTotalDifficulty()
+	return node
 }
 
 func (tn *TreeNode) String() string {
@@ -27,3 +34,40 @@ func (tn *TreeNode) String() string {
 func (tn *TreeNode) AddChild(node *TreeNode) {
 	tn.Children = append(tn.Children, node)
 }
+
+func (tn *TreeNode) removeChild(node *TreeNode) {
+	tn.Children = removeNode(tn.Children, node)
+}
+
+// NumChildrenUntil returns the number of children with a block number up to the given height
+func (tn *TreeNode) NumChildrenUntil(height uint64) (numChildren int) {
+	for _, child := range tn.Children {
+		if child.Block.Number <= height {
+			numChildren++
+		}
+	}
+	return numChildren
+}
+
+// updateTotalDifficulty recalculates the total difficulty of this node and all its descendants
+func (tn *TreeNode) updateTotalDifficulty() {
+	tn.TotalDifficulty = tn.Block.Block.Difficulty()
+	if tn.Parent != nil {
+		tn.TotalDifficulty.Add(tn.TotalDifficulty, tn.Parent.TotalDifficulty)
+	}
+
+	for _, child := range tn.Children {
+		child.updateTotalDifficulty()
+	}
+}
+
+// leaves returns all nodes without children in the subtree starting at this node
+func (tn *TreeNode) leaves() (leaves []*TreeNode) {
+	if len(tn.Children) == 0 {
+		return []*TreeNode{tn}
+	}
+	for _, child := range tn.Children {
+		leaves = append(leaves, child.leaves()...)
+	}
+	return leaves
+}
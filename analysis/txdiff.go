@@ -0,0 +1,135 @@
+package analysis
+
+import (
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type TxStatus string
+
+const (
+	TxDropped    TxStatus = "Dropped"    // in a replaced block, but not on the main chain
+	TxReincluded TxStatus = "Reincluded" // in a replaced block, and again on the main chain
+	TxNew        TxStatus = "New"        // only on the main chain
+)
+
+// TxInclusion is the position of a transaction in a block
+type TxInclusion struct {
+	BlockHash   common.Hash
+	BlockNumber uint64
+	Index       int
+}
+
+// ReorgTx is a transaction of the blocks in a reorg, and where it was included
+type ReorgTx struct {
+	Hash      common.Hash
+	Status    TxStatus
+	Replaced  []TxInclusion // in replaced blocks, ordered by height (more than one if it was in several side chains)
+	MainChain *TxInclusion  // nil if dropped
+}
+
+// Moved returns true if a re-included transaction is at another height or index than in the (first) replaced block
+func (tx *ReorgTx) Moved() bool {
+	if tx.MainChain == nil || len(tx.Replaced) == 0 {
+		return false
+	}
+	return tx.MainChain.BlockNumber != tx.Replaced[0].BlockNumber || tx.MainChain.Index != tx.Replaced[0].Index
+}
+
+// TxDiff compares the transactions of the replaced blocks with the ones of the main chain. Dropped transactions are
+// also looked up in the first block after the reorg, where they are often re-included; its other transactions are
+// not part of the diff.
+type TxDiff struct {
+	Txs []*ReorgTx // replaced transactions by position, then new ones by position
+
+	NumDropped    int
+	NumReincluded int
+	NumNew        int
+
+	// Blocks of which only the header is known (eg. uncles the node didn't have the body for). Their transactions
+	// are missing from the diff.
+	BlocksWithoutTxs []common.Hash
+}
+
+// hasTxs returns false if the block has transactions according to its header, but they weren't downloaded
+func hasTxs(block *Block) bool {
+	return len(block.Block.Transactions()) > 0 || block.Block.TxHash() == types.EmptyTxsHash
+}
+
+// NewTxDiff compares the transactions of the replaced blocks and the main chain of a finished reorg
+func NewTxDiff(reorg *Reorg) *TxDiff {
+	diff := &TxDiff{Txs: []*ReorgTx{}, BlocksWithoutTxs: []common.Hash{}}
+
+	blocksByHeight := func(blocks map[common.Hash]*Block) []*Block {
+		ret := make([]*Block, 0, len(blocks))
+		for _, block := range blocks {
+			if !hasTxs(block) {
+				diff.BlocksWithoutTxs = append(diff.BlocksWithoutTxs, block.Hash)
+				continue
+			}
+			ret = append(ret, block)
+		}
+		sort.Slice(ret, func(i, j int) bool {
+			if ret[i].Number != ret[j].Number {
+				return ret[i].Number < ret[j].Number
+			}
+			return ret[i].Hash.Hex() < ret[j].Hash.Hex()
+		})
+		return ret
+	}
+
+	replacedBlocks := make(map[common.Hash]*Block)
+	for hash, block := range reorg.BlocksInvolved {
+		if _, isMainChain := reorg.MainChainBlocks[hash]; !isMainChain {
+			replacedBlocks[hash] = block
+		}
+	}
+
+	txs := make(map[common.Hash]*ReorgTx)
+	for _, block := range blocksByHeight(replacedBlocks) {
+		for i, tx := range block.Block.Transactions() {
+			reorgTx, found := txs[tx.Hash()]
+			if !found {
+				reorgTx = &ReorgTx{Hash: tx.Hash(), Status: TxDropped}
+				txs[tx.Hash()] = reorgTx
+				diff.Txs = append(diff.Txs, reorgTx)
+			}
+			reorgTx.Replaced = append(reorgTx.Replaced, TxInclusion{BlockHash: block.Hash, BlockNumber: block.Number, Index: i})
+		}
+	}
+
+	mainChainBlocks := blocksByHeight(reorg.MainChainBlocks)
+	if reorg.FirstBlockAfterReorg != nil && hasTxs(reorg.FirstBlockAfterReorg) {
+		mainChainBlocks = append(mainChainBlocks, reorg.FirstBlockAfterReorg)
+	}
+	for _, block := range mainChainBlocks {
+		isAfterReorg := block == reorg.FirstBlockAfterReorg
+		for i, tx := range block.Block.Transactions() {
+			inclusion := &TxInclusion{BlockHash: block.Hash, BlockNumber: block.Number, Index: i}
+			reorgTx, found := txs[tx.Hash()]
+			switch {
+			case found && reorgTx.MainChain == nil:
+				reorgTx.Status = TxReincluded
+				reorgTx.MainChain = inclusion
+			case !found && !isAfterReorg:
+				reorgTx = &ReorgTx{Hash: tx.Hash(), Status: TxNew, MainChain: inclusion}
+				txs[tx.Hash()] = reorgTx
+				diff.Txs = append(diff.Txs, reorgTx)
+			}
+		}
+	}
+
+	for _, tx := range diff.Txs {
+		switch tx.Status {
+		case TxDropped:
+			diff.NumDropped++
+		case TxReincluded:
+			diff.NumReincluded++
+		case TxNew:
+			diff.NumNew++
+		}
+	}
+	return diff
+}
@@ -0,0 +1,81 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/reorgtest"
+)
+
+func newTestTx(nonce uint64) *types.Transaction {
+	return types.NewTx(&types.LegacyTx{Nonce: nonce})
+}
+
+func TestTxDiff(t *testing.T) {
+	tx1, tx2, tx3, tx4, tx5 := newTestTx(1), newTestTx(2), newTestTx(3), newTestTx(4), newTestTx(5)
+
+	// Replaced: A1 (tx1, tx2), A2 (tx3). Main chain: B1 (tx2, tx4), B2 (tx1). After the reorg: B3 (tx5, tx3).
+	blocks := reorgtest.NewBlocks(t, "A2 B3", map[string][]*types.Transaction{
+		"A1": {tx1, tx2}, "A2": {tx3}, "B1": {tx2, tx4}, "B2": {tx1}, "B3": {tx5, tx3},
+	})
+	a1, b1, b2, c := blocks["A1"], blocks["B1"], blocks["B2"], blocks["B3"]
+
+	reorg := reorgtest.NewReorg(t, blocks)
+	if !reorg.IsFinished {
+		t.Fatalf("expected finished reorg: %s", reorg)
+	}
+	diff := analysis.NewTxDiff(reorg)
+
+	if diff.NumDropped != 0 || diff.NumReincluded != 3 || diff.NumNew != 1 || len(diff.Txs) != 4 {
+		t.Fatalf("unexpected diff: dropped=%d reincluded=%d new=%d txs=%d", diff.NumDropped, diff.NumReincluded, diff.NumNew, len(diff.Txs))
+	}
+
+	expected := []struct {
+		hash      common.Hash
+		status    analysis.TxStatus
+		mainChain analysis.TxInclusion
+		moved     bool
+	}{
+		{tx1.Hash(), analysis.TxReincluded, analysis.TxInclusion{b2.Hash, 102, 0}, true},
+		{tx2.Hash(), analysis.TxReincluded, analysis.TxInclusion{b1.Hash, 101, 0}, true},
+		{tx3.Hash(), analysis.TxReincluded, analysis.TxInclusion{c.Hash, 103, 1}, true}, // in the first block after the reorg
+		{tx4.Hash(), analysis.TxNew, analysis.TxInclusion{b1.Hash, 101, 1}, false},
+	}
+	for i, e := range expected {
+		tx := diff.Txs[i]
+		if tx.Hash != e.hash || tx.Status != e.status || tx.MainChain == nil || *tx.MainChain != e.mainChain || tx.Moved() != e.moved {
+			t.Errorf("tx %d: expected %+v, got %+v (main chain %+v)", i, e, tx, tx.MainChain)
+		}
+	}
+	if replaced := diff.Txs[1].Replaced; len(replaced) != 1 || replaced[0] != (analysis.TxInclusion{a1.Hash, 101, 1}) {
+		t.Errorf("unexpected replaced position of tx2: %+v", replaced)
+	}
+}
+
+func TestTxDiffDroppedAndMissingBody(t *testing.T) {
+	tx1, tx2 := newTestTx(1), newTestTx(2)
+
+	blocks := reorgtest.NewBlocks(t, "A1 B2", map[string][]*types.Transaction{"A1": {tx1, tx2}, "B1": {tx1}})
+	a := blocks["A1"]
+
+	diff := analysis.NewTxDiff(reorgtest.NewReorg(t, blocks))
+	if diff.NumDropped != 1 || diff.NumReincluded != 1 || diff.NumNew != 0 || len(diff.BlocksWithoutTxs) != 0 {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+	if dropped := diff.Txs[1]; dropped.Hash != tx2.Hash() || dropped.Status != analysis.TxDropped || dropped.MainChain != nil || dropped.Moved() {
+		t.Errorf("expected tx2 to be dropped, got %+v", dropped)
+	}
+	if reincluded := diff.Txs[0]; reincluded.Moved() {
+		t.Errorf("expected tx1 at the same position, got %+v", reincluded)
+	}
+
+	// Only the header of the replaced block is known (eg. an uncle without body)
+	header := a.Block.Header()
+	a.Block = types.NewBlockWithHeader(header)
+	diff = analysis.NewTxDiff(reorgtest.NewReorg(t, blocks))
+	if len(diff.BlocksWithoutTxs) != 1 || diff.BlocksWithoutTxs[0] != a.Hash || diff.NumReincluded != 0 || diff.NumNew != 1 {
+		t.Errorf("expected replaced block without txs and one new tx, got %+v", diff)
+	}
+}
@@ -0,0 +1,155 @@
+// Package apitypes has the reorgs and blocks as returned by the monitor API, and sent to the webhooks. It only depends
+// on analysis, so that all packages can use it.
+package apitypes
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+)
+
+type ReorgInfo struct {
+	Id         string
+	ObservedAt time.Time // when the monitor found the finished reorg
+	Source     string    // memory or database
+
+	SeenLive          bool
+	StartBlockHeight  uint64
+	EndBlockHeight    uint64
+	Depth             int
+	NumChains         int
+	NumBlocksInvolved int
+	NumReplacedBlocks int
+
+	CommonParentHash         common.Hash
+	FirstBlockAfterReorgHash common.Hash
+	MainChainHash            common.Hash
+	NodeUris                 []string
+	BeaconReorgIds           []string
+
+	ParentId       string   `json:",omitempty"` // reorg this one is nested in
+	NestedReorgIds []string `json:",omitempty"` // finished reorgs nested in this one (only known in memory)
+
+	Chains []ChainInfo `json:",omitempty"` // only included when requesting a single reorg
+}
+
+// ChainInfo is a branch of a reorg: a chain between two forks
+type ChainInfo struct {
+	IsMainChain bool
+	ParentHash  common.Hash // block the branch forks off
+	Blocks      []BlockInfo
+}
+
+type BlockInfo struct {
+	Number          uint64
+	Hash            common.Hash
+	ParentHash      common.Hash
+	Timestamp       uint64
+	CoinbaseAddress common.Address
+	NumTx           int
+	NumUncles       int
+
+	Origin     analysis.BlockOrigin
+	NodeUri    string
+	ObservedAt time.Time
+
+	IsMainChain bool
+	ReorgIds    []string `json:",omitempty"` // known reorgs this block was part of
+}
+
+func NewReorgInfo(reorg *analysis.Reorg, observedAt time.Time) *ReorgInfo {
+	info := ReorgInfo{
+		Id:                reorg.Id(),
+		ObservedAt:        observedAt,
+		Source:            "memory",
+		SeenLive:          reorg.SeenLive,
+		StartBlockHeight:  reorg.StartBlockHeight,
+		EndBlockHeight:    reorg.EndBlockHeight,
+		Depth:             reorg.Depth,
+		NumChains:         len(reorg.Chains),
+		NumBlocksInvolved: len(reorg.BlocksInvolved),
+		NumReplacedBlocks: reorg.NumReplacedBlocks,
+		MainChainHash:     reorg.MainChainHash,
+		NodeUris:          []string{},
+		BeaconReorgIds:    []string{},
+		Chains:            []ChainInfo{},
+	}
+
+	if reorg.CommonParent != nil {
+		info.CommonParentHash = reorg.CommonParent.Hash
+	}
+	if reorg.FirstBlockAfterReorg != nil {
+		info.FirstBlockAfterReorgHash = reorg.FirstBlockAfterReorg.Hash
+	}
+
+	for nodeUri := range reorg.EthNodesInvolved {
+		info.NodeUris = append(info.NodeUris, nodeUri)
+	}
+	sort.Strings(info.NodeUris)
+
+	for _, beaconReorg := range reorg.BeaconReorgs {
+		info.BeaconReorgIds = append(info.BeaconReorgIds, beaconReorg.Id())
+	}
+
+	if reorg.Parent != nil {
+		info.ParentId = reorg.Parent.Id()
+	}
+	for _, child := range reorg.FinishedChildren() {
+		info.NestedReorgIds = append(info.NestedReorgIds, child.Id())
+	}
+
+	for chainHash, chain := range reorg.Chains {
+		chainInfo := ChainInfo{IsMainChain: reorg.IsMainChainBranch(chainHash), ParentHash: chain[0].ParentHash, Blocks: []BlockInfo{}}
+		for _, block := range chain {
+			_, isMainChain := reorg.MainChainBlocks[block.Hash]
+			blockInfo := NewBlockInfo(block, isMainChain)
+			blockInfo.ReorgIds = []string{info.Id}
+			chainInfo.Blocks = append(chainInfo.Blocks, blockInfo)
+		}
+		info.Chains = append(info.Chains, chainInfo)
+	}
+	SortChains(info.Chains)
+
+	return &info
+}
+
+func NewBlockInfo(block *analysis.Block, isMainChain bool) BlockInfo {
+	return BlockInfo{
+		Number:          block.Number,
+		Hash:            block.Hash,
+		ParentHash:      block.ParentHash,
+		Timestamp:       block.Block.Time(),
+		CoinbaseAddress: block.Block.Coinbase(),
+		NumTx:           len(block.Block.Transactions()),
+		NumUncles:       len(block.Block.Uncles()),
+		Origin:          block.Origin,
+		NodeUri:         block.NodeUri,
+		ObservedAt:      time.Unix(0, block.ObservedUnixTimestamp).UTC(),
+		IsMainChain:     isMainChain,
+	}
+}
+
+// Summary returns the reorg without chains, as listed by /v1/reorgs
+func (info *ReorgInfo) Summary() ReorgInfo {
+	ret := *info
+	ret.Chains = nil
+	return ret
+}
+
+// SortChains puts the main chain branches first, and orders the branches by the height and hash of their first block
+func SortChains(chains []ChainInfo) {
+	sort.Slice(chains, func(i, j int) bool {
+		if chains[i].IsMainChain != chains[j].IsMainChain {
+			return chains[i].IsMainChain
+		}
+		if len(chains[i].Blocks) == 0 || len(chains[j].Blocks) == 0 {
+			return len(chains[i].Blocks) > len(chains[j].Blocks)
+		}
+		if chains[i].Blocks[0].Number != chains[j].Blocks[0].Number {
+			return chains[i].Blocks[0].Number < chains[j].Blocks[0].Number
+		}
+		return chains[i].Blocks[0].Hash.Hex() < chains[j].Blocks[0].Hash.Hex()
+	})
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"flag"
 	"fmt"
 	"log"
@@ -109,7 +110,7 @@ func addUncle(uncleBlock, mainchainBlock *types.Block) {
 	fmt.Println("addUncle", uncleBlock.Hash())
 
 	// Add to database now
-	_, err := db.BlockEntry(uncleBlock.Hash())
+	_, err := db.BlockEntry(context.Background(), uncleBlock.Hash())
 	if err == nil { // already exists
 		fmt.Println("- block already exists in db, skipping update")
 		return
@@ -166,40 +167,21 @@ func addUncle(uncleBlock, mainchainBlock *types.Block) {
 	mainChainBlockEntry := database.NewBlockEntry(_mainChainBlock, &reorg)
 	mainChainBlockEntry.UpdateWitCallBundleResponse(mainChainSimRes)
 
-	// Time to use for created_at is time of next block
-	t := time.Unix(int64(mainChainBlockChild1.Header().Time), 0).UTC()
-	tf := t.Format("2006-01-02 15:04:05")
+	// Time to use for created_at is time of next block. It's saved in the same transaction as the entries.
+	createdAt := sql.NullTime{Time: time.Unix(int64(mainChainBlockChild1.Header().Time), 0).UTC(), Valid: true}
+	uncleBlockEntry.Created_At = createdAt
+	mainChainBlockEntry.Created_At = createdAt
 
 	// Save
 	reorgEntry := database.NewReorgEntry(&reorg)
+	reorgEntry.Created_At = createdAt
 
-	err = db.AddReorgEntry(reorgEntry)
+	isNew, err := db.AddReorgWithBlocks(context.Background(), reorgEntry, []database.BlockEntry{uncleBlockEntry, mainChainBlockEntry}, nil)
 	if err != nil {
 		fmt.Println("-", err)
 		return
 	}
-
-	_, err = db.DB.Exec("Update reorg_summary SET Created_At=$1 WHERE Key=$2", tf, reorgEntry.Key)
-	if err != nil {
-		fmt.Println("-", err)
-		return
-	}
-
-	err = db.AddBlockEntry(uncleBlockEntry)
-	if err != nil {
-		fmt.Println("-", err)
-		return
-	}
-
-	err = db.AddBlockEntry(mainChainBlockEntry)
-	if err != nil {
-		fmt.Println("-", err)
-		return
-	}
-
-	_, err = db.DB.Exec("Update reorg_block SET Created_At=$1 WHERE Reorg_Key=$2", tf, reorgEntry.Key)
-	if err != nil {
-		fmt.Println("-", err)
-		return
+	if !isNew {
+		fmt.Println("- reorg already exists")
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -61,10 +62,10 @@ func main() {
 	for _, ethBlock := range testutils.BlocksForStrings(test.BlockInfo) {
 		observed := time.Now().UTC().UnixNano()
 		block := analysis.NewBlock(ethBlock, analysis.OriginSubscription, *ethUriPtr, observed)
-		testutils.Monitor.AddBlock(block)
+		testutils.Monitor.AddBlock(context.Background(), block)
 	}
 
-	analysis, err := testutils.Monitor.AnalyzeTree(100, 0)
+	analysis, err := testutils.Monitor.AnalyzeTree(context.Background(), 100, 0)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -78,13 +79,9 @@ func main() {
 	for _, reorg := range analysis.Reorgs {
 		fmt.Println(reorg)
 
-		entry := database.NewReorgEntry(reorg)
-		db.AddReorgEntry(entry)
-
-		// blocks
-		for _, block := range reorg.BlocksInvolved {
-			blockEntry := database.NewBlockEntry(block, reorg)
-			db.AddBlockEntry(blockEntry)
+		_, err := db.AddReorgWithBlocks(context.Background(), database.NewReorgEntry(reorg), database.NewBlockEntries(reorg, nil), database.NewTxEntries(reorg))
+		if err != nil {
+			fmt.Println(err)
 		}
 	}
 }
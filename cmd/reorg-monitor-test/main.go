@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -21,6 +22,7 @@ func main() {
 	log.SetOutput(os.Stdout)
 
 	ethUriPtr := flag.String("eth", os.Getenv("ETH_NODES"), "Ethereum node URI")
+	recordDirPtr := flag.String("record", "", "record the blocks of all mainnet test cases as fixtures into this directory, needs an archive node")
 	flag.Parse()
 
 	ethUris := strings.Split(*ethUriPtr, ",")
@@ -32,6 +34,11 @@ func main() {
 	_, err := testutils.ConnectClient(ethUris[0])
 	reorgutils.Perror(err)
 
+	if *recordDirPtr != "" {
+		RecordFixtures(*recordDirPtr)
+		return
+	}
+
 	// Create a new monitor instance (and connect its geth clients to fetch further blocks if required)
 	mon = monitor.NewReorgMonitor(ethUris, make(chan *analysis.Reorg), true, 100)
 	numConnectedClients := mon.ConnectClients()
@@ -51,11 +58,11 @@ func CheckReorg(blockIds []string) {
 	// Add the blocks
 	for _, ethBlock := range testutils.BlocksForStrings(blockIds) {
 		block := analysis.NewBlock(ethBlock, analysis.OriginSubscription, testutils.EthNodeUri, time.Now().UTC().UnixNano())
-		mon.AddBlock(block)
+		mon.AddBlock(context.Background(), block)
 	}
 
 	// Analyze
-	analysis, err := mon.AnalyzeTree(0, 0)
+	analysis, err := mon.AnalyzeTree(context.Background(), 0, 0)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -75,22 +82,22 @@ func TestAndVerify(testCase testutils.TestCase) {
 	// Add the blocks
 	for _, ethBlock := range testutils.BlocksForStrings(testCase.BlockInfo) {
 		block := analysis.NewBlock(ethBlock, analysis.OriginSubscription, testutils.EthNodeUri, time.Now().UTC().UnixNano())
-		testutils.Monitor.AddBlock(block)
+		testutils.Monitor.AddBlock(context.Background(), block)
 	}
 
-	// reorgs := testutils.ReorgCheckAndPrint()
-	// testutils.Pcheck("NumReorgs", len(reorgs), 1)
-
-	// reorg := reorgs[0]
-	// testutils.Pcheck("StartBlock", reorg.StartBlockHeight, testCase.ExpectedResult.StartBlock)
-	// testutils.Pcheck("EndBlock", reorg.EndBlockHeight, testCase.ExpectedResult.EndBlock)
-	// testutils.Pcheck("Depth", reorg.Depth, testCase.ExpectedResult.Depth)
-	// testutils.Pcheck("NumBlocks", len(reorg.BlocksInvolved), testCase.ExpectedResult.NumBlocks)
-	// testutils.Pcheck("NumReplacedBlocks", reorg.NumReplacedBlocks, testCase.ExpectedResult.NumReplacedBlocks)
-
-	// if testCase.ExpectedResult.MustBeLive {
-	// 	testutils.Pcheck("MustBeLive", reorg.SeenLive, true)
-	// }
+	analysis, err := testutils.Monitor.AnalyzeTree(context.Background(), 0, 0)
+	reorgutils.Perror(err)
+	analysis.Print()
+	reorgutils.Perror(testCase.ExpectedResult.Check(analysis))
+}
 
-	// fmt.Println(reorg.MermaidSyntax())
+// RecordFixtures saves the blocks of all mainnet test cases, so that they can run without a node
+func RecordFixtures(dir string) {
+	for id, testCase := range testutils.MainnetTestCases {
+		name := testutils.MainnetFixtureName(id)
+		fixture, err := testutils.RecordFixture(context.Background(), name, testCase)
+		reorgutils.Perror(err)
+		reorgutils.Perror(fixture.Save(dir))
+		fmt.Printf("recorded %s: %d blocks\n", name, len(fixture.Blocks))
+	}
 }
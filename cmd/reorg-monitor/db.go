@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flashbots/reorg-monitor/database"
+	"github.com/flashbots/reorg-monitor/monitor"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagMigrateTo  = "to"
+	usageMigrateTo = "schema version to migrate up or down to (default: latest)"
+)
+
+// dbCmd manages the database schema (db migrate, db status)
+func dbCmd(conf *monitor.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Manage the database schema",
+	}
+
+	var targetVersion int
+	migrateCmd := &cobra.Command{
+		Use:          "migrate",
+		Short:        "Migrate the database schema to the latest (or the given) version",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openDatabase(conf)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			ctx := cmd.Context()
+			version, err := db.CheckSchemaVersion(ctx)
+			if err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed(flagMigrateTo) {
+				targetVersion = database.LatestSchemaVersion
+			}
+			if version == targetVersion {
+				fmt.Printf("database schema is at version %d, nothing to do\n", version)
+				return nil
+			}
+
+			fmt.Printf("migrating database schema from version %d to %d...\n", version, targetVersion)
+			if err := db.Migrate(ctx, targetVersion); err != nil {
+				return err
+			}
+			fmt.Println("done")
+			return nil
+		},
+	}
+	migrateCmd.Flags().IntVar(&targetVersion, flagMigrateTo, 0, usageMigrateTo)
+
+	statusCmd := &cobra.Command{
+		Use:          "status",
+		Short:        "Show the database schema version and the known migrations",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openDatabase(conf)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			// Only reads, an unversioned database gets its schema_version table from db migrate
+			ctx := cmd.Context()
+			isVersioned, err := db.IsVersioned(ctx)
+			if err != nil {
+				return err
+			}
+			version, err := db.SchemaVersion(ctx)
+			if err != nil {
+				return err
+			}
+			statuses, err := db.MigrationStatus(ctx)
+			if err != nil {
+				return err
+			}
+
+			if isVersioned {
+				fmt.Printf("schema version: %d (latest known: %d)\n", version, database.LatestSchemaVersion)
+			} else {
+				fmt.Printf("schema version: unversioned (latest known: %d)\n", database.LatestSchemaVersion)
+			}
+			for _, status := range statuses {
+				applied := "pending"
+				if !status.AppliedAt.IsZero() {
+					applied = "applied " + status.AppliedAt.UTC().Format(time.RFC3339)
+				}
+				fmt.Printf("%4d  %-40s %s\n", status.Version, status.Name, applied)
+			}
+			if version > database.LatestSchemaVersion {
+				return database.ErrSchemaTooNew
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(migrateCmd, statusCmd)
+	return cmd
+}
+
+func openDatabase(conf *monitor.Config) (database.Store, error) {
+	if conf.DatabaseDSN == "" {
+		return nil, fmt.Errorf("--%s is required", flagDatabaseDSN)
+	}
+	return database.OpenStore(conf.DatabaseDSN)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/database"
+	"github.com/flashbots/reorg-monitor/monitor"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagReplaySpeed  = "speed"
+	usageReplaySpeed = "replay speed relative to the recording (1: original speed, 10: ten times faster, 0: as fast as possible)"
+
+	defaultReplaySpeed = 1.0
+)
+
+// replayCmd re-runs the monitor on a recording (see --record). Blocks the monitor downloads are served from the
+// recording, so no nodes are needed. Reorgs are printed, and saved if a database is configured, but not sent to
+// webhooks.
+func replayCmd(conf *monitor.Config) *cobra.Command {
+	var speed float64
+
+	cmd := &cobra.Command{
+		Use:          "replay FILE",
+		Short:        "Re-run the monitor on the blocks of a recording",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := args[0]
+			if conf.RecordFile == path {
+				return fmt.Errorf("cannot record a replay into the replayed file")
+			}
+
+			recording, err := monitor.LoadRecording(path)
+			if err != nil {
+				return err
+			}
+			log.Printf("replaying %d blocks from %s (speed: %v)\n", recording.NumBlocks(), path, speed)
+
+			var db database.Store
+			if conf.DatabaseDSN != "" {
+				db, err = database.NewStore(conf.DatabaseDSN)
+				if err != nil {
+					return fmt.Errorf("error initializing database service with endpoint %s - %v", database.DescribeDSN(conf.DatabaseDSN), err)
+				}
+				defer db.Close()
+			}
+
+			reorgChan := make(chan *analysis.Reorg)
+			mon := monitor.NewReorgMonitor(recording.NodeUris(), reorgChan, true, conf.MaxBlocks)
+			if conf.RecordFile != "" {
+				recorder, err := monitor.NewRecorder(conf.RecordFile)
+				if err != nil {
+					return err
+				}
+				defer recorder.Close()
+				if err := mon.SetRecorder(recorder); err != nil {
+					return err
+				}
+			}
+			if err := mon.AddReplaySources(recording); err != nil {
+				return err
+			}
+			if err := mon.SetForkChoice(conf.ForkChoice, conf.ForkChoiceNode); err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			numReorgs := 0
+			handlerDone := make(chan struct{})
+			go func() {
+				defer close(handlerDone)
+				for reorg := range reorgChan {
+					numReorgs += 1
+					handleReorg(ctx, false, db, nil, reorg)
+				}
+			}()
+
+			err = mon.Replay(ctx, recording, speed)
+			close(reorgChan) // Replay returns after the monitor sent its last reorg
+			<-handlerDone
+			if err != nil {
+				return err
+			}
+
+			log.Printf("replay complete: %d reorgs\n", numReorgs)
+			return nil
+		},
+	}
+
+	cmd.Flags().Float64Var(&speed, flagReplaySpeed, defaultReplaySpeed, usageReplaySpeed)
+	return cmd
+}
@@ -1,120 +1,285 @@
 package database
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/flashbots/reorg-monitor/analysis"
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 )
 
-const driverName = "postgres"
+const (
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite3"
+)
 
+// DatabaseService is the Store for Postgres and SQLite. Queries are written with ? placeholders, and rebound for the
+// driver.
 type DatabaseService struct {
 	DB *sqlx.DB
+
+	migrations []Migration // the schema versions in the dialect of the driver
 }
 
+// NewDatabaseService connects to the database, and migrates the schema to the latest version. It fails if the schema
+// is newer than this binary supports.
 func NewDatabaseService(dsn string) (*DatabaseService, error) {
-	db, err := sqlx.Connect(driverName, dsn)
+	s, err := OpenDatabaseService(dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = db.Exec(Schema)
+	if err := s.Migrate(context.Background(), LatestSchemaVersion); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenDatabaseService connects to the database without checking or changing the schema. DSNs starting with sqlite://
+// open a SQLite database file, all others a Postgres database.
+func OpenDatabaseService(dsn string) (*DatabaseService, error) {
+	if strings.HasPrefix(dsn, SchemeSQLite) {
+		return openSQLite(dsn)
+	}
+
+	db, err := sqlx.Connect(driverPostgres, dsn)
 	if err != nil {
 		return nil, err
 	}
 
 	return &DatabaseService{
-		DB: db,
+		DB:         db,
+		migrations: PostgresMigrations,
 	}, nil
 }
 
+// Reset deletes all data, by migrating down to an empty database and up again
 func (s *DatabaseService) Reset() {
-	s.DB.MustExec(`DROP TABLE "reorg_summary";`)
-	s.DB.MustExec(`DROP TABLE "reorg_block";`)
-	s.DB.MustExec(Schema)
+	ctx := context.Background()
+	if err := s.Migrate(ctx, 0); err != nil {
+		panic(err)
+	}
+	if err := s.Migrate(ctx, LatestSchemaVersion); err != nil {
+		panic(err)
+	}
 }
 
 func (s *DatabaseService) Close() {
 	s.DB.Close()
 }
 
-func (s *DatabaseService) ReorgEntry(key string) (entry ReorgEntry, err error) {
-	err = s.DB.Get(&entry, "SELECT * FROM reorg_summary WHERE Key=$1", key)
+// reorgEntrySelect selects reorg summaries with the key of the reorg they are nested in
+const reorgEntrySelect = "SELECT reorg_summary.*, reorg_nesting.ParentKey FROM reorg_summary LEFT JOIN reorg_nesting ON reorg_nesting.Reorg_Key = reorg_summary.Key"
+
+func (s *DatabaseService) ReorgEntry(ctx context.Context, key string) (entry ReorgEntry, err error) {
+	err = s.DB.GetContext(ctx, &entry, s.DB.Rebind(reorgEntrySelect+" WHERE Key=?"), key)
 	return entry, err
 }
 
-func (s *DatabaseService) BlockEntry(hash common.Hash) (entry BlockEntry, err error) {
-	err = s.DB.Get(&entry, "SELECT * FROM reorg_block WHERE BlockHash=$1", strings.ToLower(hash.Hex()))
+func (s *DatabaseService) BlockEntry(ctx context.Context, hash common.Hash) (entry BlockEntry, err error) {
+	err = s.DB.GetContext(ctx, &entry, s.DB.Rebind("SELECT * FROM reorg_block WHERE BlockHash=?"), strings.ToLower(hash.Hex()))
 	return entry, err
 }
 
-func (s *DatabaseService) AddReorgEntry(entry ReorgEntry) error {
-	var count int
-	err := s.DB.QueryRow("SELECT COUNT(*) FROM reorg_summary WHERE Key = $1", entry.Key).Scan(&count)
-	if err != nil {
-		return err
+// ReorgEntriesFilter selects reorg summaries. Zero values don't filter.
+type ReorgEntriesFilter struct {
+	MinDepth int
+	MaxDepth int
+	SeenLive sql.NullBool
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// timeArg returns t in the format of the Created_At columns
+func (s *DatabaseService) timeArg(t time.Time) interface{} {
+	if s.DB.DriverName() == driverSQLite {
+		return t.UTC().Format("2006-01-02 15:04:05") // current_timestamp, compared as text
 	}
-	if count > 0 { // already exists
-		return fmt.Errorf("a reorg with key %s already exists", entry.Key)
+	return t.UTC()
+}
+
+// createdAtArg returns the value for a Created_At column: the time of the entry if set, else the current time
+func (s *DatabaseService) createdAtArg(createdAt sql.NullTime) interface{} {
+	if !createdAt.Valid {
+		return nil // COALESCE(?, current_timestamp)
 	}
+	return s.timeArg(createdAt.Time)
+}
 
-	// Insert
-	_, err = s.DB.Exec("INSERT INTO reorg_summary (Key, SeenLive, StartBlockNumber, EndBlockNumber, Depth, NumChains, NumBlocksInvolved, NumBlocksReplaced, MermaidSyntax) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)", entry.Key, entry.SeenLive, entry.StartBlockNumber, entry.EndBlockNumber, entry.Depth, entry.NumChains, entry.NumBlocksInvolved, entry.NumBlocksReplaced, entry.MermaidSyntax)
-	return err
+// ReorgEntries returns the reorg summaries matching the filter, latest (highest) first
+func (s *DatabaseService) ReorgEntries(ctx context.Context, filter ReorgEntriesFilter) (entries []ReorgEntry, err error) {
+	conditions := []string{"TRUE"}
+	args := []interface{}{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, condition)
+	}
+
+	if filter.MinDepth > 0 {
+		addCondition("Depth >= ?", filter.MinDepth)
+	}
+	if filter.MaxDepth > 0 {
+		addCondition("Depth <= ?", filter.MaxDepth)
+	}
+	if filter.SeenLive.Valid {
+		addCondition("SeenLive = ?", filter.SeenLive.Bool)
+	}
+	if !filter.Since.IsZero() {
+		addCondition("Created_At >= ?", s.timeArg(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		addCondition("Created_At <= ?", s.timeArg(filter.Until))
+	}
+
+	query := reorgEntrySelect + " WHERE " + strings.Join(conditions, " AND ") + " ORDER BY EndBlockNumber DESC, StartBlockNumber DESC, Id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	err = s.DB.SelectContext(ctx, &entries, s.DB.Rebind(query), args...)
+	return entries, err
 }
 
-func (s *DatabaseService) AddBlockEntry(entry BlockEntry) error {
-	var count int
-	err := s.DB.QueryRow("SELECT COUNT(*) FROM reorg_block WHERE Reorg_Key = $1 AND BlockHash = $2", entry.Reorg_Key, strings.ToLower(entry.BlockHash)).Scan(&count)
+// blockEntryColumns are the columns of reorg_block without the simulation results (MevGeth_*), which are NULL for
+// blocks that weren't simulated
+const blockEntryColumns = "Id, Created_At, Reorg_Key, Origin, NodeUri, BlockNumber, BlockHash, ParentHash, BlockTimestamp, CoinbaseAddress, Difficulty, NumUncles, NumTx, IsPartOfReorg, IsMainChain, IsFirst, Beacon_Slot, Beacon_ProposerIndex, Beacon_BlockRoot"
+
+// BlockEntriesForReorg returns the blocks of a reorg, without the simulation results
+func (s *DatabaseService) BlockEntriesForReorg(ctx context.Context, reorgKey string) (entries []BlockEntry, err error) {
+	err = s.DB.SelectContext(ctx, &entries, s.DB.Rebind("SELECT "+blockEntryColumns+" FROM reorg_block WHERE Reorg_Key=? ORDER BY BlockNumber, BlockHash"), reorgKey)
+	return entries, err
+}
+
+// BlockEntriesByHash returns a block for every reorg it was part of, without the simulation results
+func (s *DatabaseService) BlockEntriesByHash(ctx context.Context, hash common.Hash) (entries []BlockEntry, err error) {
+	err = s.DB.SelectContext(ctx, &entries, s.DB.Rebind("SELECT "+blockEntryColumns+" FROM reorg_block WHERE BlockHash=? ORDER BY Created_At DESC"), strings.ToLower(hash.Hex()))
+	return entries, err
+}
+
+// AddReorgWithBlocks inserts a reorg summary, its blocks and its transactions in one transaction. Saving a reorg
+// again is a no-op, which is reported by isNew. Blocks and transactions missing from an existing reorg are added. The
+// Created_At of the entries is used if set (eg. for reorgs added after the fact), else the current time.
+func (s *DatabaseService) AddReorgWithBlocks(ctx context.Context, entry ReorgEntry, blockEntries []BlockEntry, txEntries []TxEntry) (isNew bool, err error) {
+	tx, err := s.DB.BeginTxx(ctx, nil)
 	if err != nil {
-		return err
+		return false, err
+	}
+	defer tx.Rollback() // no-op after commit
+
+	res, err := tx.ExecContext(ctx, tx.Rebind("INSERT INTO reorg_summary (Created_At, Key, SeenLive, StartBlockNumber, EndBlockNumber, Depth, NumChains, NumBlocksInvolved, NumBlocksReplaced, MermaidSyntax) VALUES (COALESCE(?, current_timestamp), ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (Key) DO NOTHING"), s.createdAtArg(entry.Created_At), entry.Key, entry.SeenLive, entry.StartBlockNumber, entry.EndBlockNumber, entry.Depth, entry.NumChains, entry.NumBlocksInvolved, entry.NumBlocksReplaced, entry.MermaidSyntax)
+	if err != nil {
+		return false, errors.Wrapf(err, "inserting reorg %s failed", entry.Key)
 	}
-	if count > 0 { // already exists
-		return fmt.Errorf("a block with hash %s for reorg %s already exists", entry.BlockHash, entry.Reorg_Key)
+	numInserted, err := res.RowsAffected()
+	if err != nil {
+		return false, err
 	}
 
-	// Insert
-	_, err = s.DB.Exec("INSERT INTO reorg_block (Reorg_Key, Origin, NodeUri, BlockNumber, BlockHash, ParentHash, BlockTimestamp, CoinbaseAddress, Difficulty, NumUncles, NumTx, IsPartOfReorg, IsMainChain, IsFirst, MevGeth_CoinbaseDiffEth, MevGeth_CoinbaseDiffWei, MevGeth_GasFeesWei, MevGeth_EthSentToCoinbaseWei, MevGeth_EthSentToCoinbase) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)",
-		entry.Reorg_Key, entry.Origin, entry.NodeUri, entry.BlockNumber, entry.BlockHash, entry.ParentHash, entry.BlockTimestamp, entry.CoinbaseAddress, entry.Difficulty, entry.NumUncles, entry.NumTx, entry.IsPartOfReorg, entry.IsMainChain, entry.IsFirst, entry.MevGeth_CoinbaseDiffEth, entry.MevGeth_CoinbaseDiffWei, entry.MevGeth_GasFeesWei, entry.MevGeth_EthSentToCoinbaseWei, entry.MevGeth_EthSentToCoinbase)
-	return err
+	if entry.ParentKey.Valid {
+		_, err = tx.ExecContext(ctx, tx.Rebind("INSERT INTO reorg_nesting (Reorg_Key, ParentKey) VALUES (?, ?) ON CONFLICT (Reorg_Key) DO NOTHING"), entry.Key, entry.ParentKey)
+		if err != nil {
+			return false, errors.Wrapf(err, "inserting parent of reorg %s failed", entry.Key)
+		}
+	}
+
+	for _, blockEntry := range blockEntries {
+		if blockEntry.Reorg_Key != entry.Key {
+			return false, fmt.Errorf("block %s belongs to reorg %s, not %s", blockEntry.BlockHash, blockEntry.Reorg_Key, entry.Key)
+		}
+
+		_, err = tx.ExecContext(ctx, tx.Rebind("INSERT INTO reorg_block (Created_At, Reorg_Key, Origin, NodeUri, BlockNumber, BlockHash, ParentHash, BlockTimestamp, CoinbaseAddress, Difficulty, NumUncles, NumTx, IsPartOfReorg, IsMainChain, IsFirst, MevGeth_CoinbaseDiffEth, MevGeth_CoinbaseDiffWei, MevGeth_GasFeesWei, MevGeth_EthSentToCoinbaseWei, MevGeth_EthSentToCoinbase, Beacon_Slot, Beacon_ProposerIndex, Beacon_BlockRoot) VALUES (COALESCE(?, current_timestamp), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (Reorg_Key, BlockHash) DO NOTHING"),
+			s.createdAtArg(blockEntry.Created_At), blockEntry.Reorg_Key, blockEntry.Origin, blockEntry.NodeUri, blockEntry.BlockNumber, strings.ToLower(blockEntry.BlockHash), blockEntry.ParentHash, blockEntry.BlockTimestamp, blockEntry.CoinbaseAddress, blockEntry.Difficulty, blockEntry.NumUncles, blockEntry.NumTx, blockEntry.IsPartOfReorg, blockEntry.IsMainChain, blockEntry.IsFirst, blockEntry.MevGeth_CoinbaseDiffEth, blockEntry.MevGeth_CoinbaseDiffWei, blockEntry.MevGeth_GasFeesWei, blockEntry.MevGeth_EthSentToCoinbaseWei, blockEntry.MevGeth_EthSentToCoinbase, blockEntry.Beacon_Slot, blockEntry.Beacon_ProposerIndex, blockEntry.Beacon_BlockRoot)
+		if err != nil {
+			return false, errors.Wrapf(err, "inserting block %s of reorg %s failed", blockEntry.BlockHash, entry.Key)
+		}
+	}
+
+	for _, txEntry := range txEntries {
+		if txEntry.Reorg_Key != entry.Key {
+			return false, fmt.Errorf("tx %s belongs to reorg %s, not %s", txEntry.TxHash, txEntry.Reorg_Key, entry.Key)
+		}
+
+		_, err = tx.ExecContext(ctx, tx.Rebind("INSERT INTO reorg_tx (Reorg_Key, TxHash, Status, ReplacedBlockHash, ReplacedBlockNumber, ReplacedTxIndex, MainChainBlockHash, MainChainBlockNumber, MainChainTxIndex) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (Reorg_Key, TxHash) DO NOTHING"),
+			txEntry.Reorg_Key, strings.ToLower(txEntry.TxHash), txEntry.Status, txEntry.ReplacedBlockHash, txEntry.ReplacedBlockNumber, txEntry.ReplacedTxIndex, txEntry.MainChainBlockHash, txEntry.MainChainBlockNumber, txEntry.MainChainTxIndex)
+		if err != nil {
+			return false, errors.Wrapf(err, "inserting tx %s of reorg %s failed", txEntry.TxHash, entry.Key)
+		}
+	}
+
+	return numInserted > 0, tx.Commit()
 }
 
-func (s *DatabaseService) AddReorgWithBlocks(reorg *analysis.Reorg) error {
-	// First add the reorg summary
-	err := s.AddReorgEntry(NewReorgEntry(reorg))
+// TxEntriesForReorg returns the tx diff of a reorg, ordered like analysis.TxDiff
+func (s *DatabaseService) TxEntriesForReorg(ctx context.Context, reorgKey string) (entries []TxEntry, err error) {
+	err = s.DB.SelectContext(ctx, &entries, s.DB.Rebind("SELECT * FROM reorg_tx WHERE Reorg_Key=? ORDER BY Id"), reorgKey)
+	return entries, err
+}
+
+// AddBeaconReorgEntries links beacon reorgs to stored reorgs. Links which already exist are skipped.
+func (s *DatabaseService) AddBeaconReorgEntries(ctx context.Context, entries []BeaconReorgEntry) error {
+	tx, err := s.DB.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback() // no-op after commit
 
-	// Then add all the blocks
-	for _, block := range reorg.BlocksInvolved {
-		err := s.AddBlockEntry(NewBlockEntry(block, reorg))
+	for _, entry := range entries {
+		_, err = tx.ExecContext(ctx, tx.Rebind("INSERT INTO reorg_beacon_reorg (Reorg_Key, BeaconReorgId, Slot, Depth, OldHeadBlock, NewHeadBlock, NodeUri) VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (Reorg_Key, BeaconReorgId) DO NOTHING"),
+			entry.Reorg_Key, entry.BeaconReorgId, entry.Slot, entry.Depth, entry.OldHeadBlock, entry.NewHeadBlock, entry.NodeUri)
 		if err != nil {
-			return err
+			return errors.Wrapf(err, "inserting beacon reorg %s of reorg %s failed", entry.BeaconReorgId, entry.Reorg_Key)
 		}
 	}
+	return tx.Commit()
+}
 
-	return nil
+// BeaconReorgEntriesForReorg returns the beacon reorgs matching a reorg, in the order they were added
+func (s *DatabaseService) BeaconReorgEntriesForReorg(ctx context.Context, reorgKey string) (entries []BeaconReorgEntry, err error) {
+	err = s.DB.SelectContext(ctx, &entries, s.DB.Rebind("SELECT * FROM reorg_beacon_reorg WHERE Reorg_Key=? ORDER BY Id"), reorgKey)
+	return entries, err
 }
 
 func (s *DatabaseService) DeleteReorgWithBlocks(entry ReorgEntry) error {
-	_, err := s.DB.Exec("DELETE FROM reorg_block WHERE Reorg_Key=$1", entry.Key)
+	_, err := s.DB.Exec(s.DB.Rebind("DELETE FROM reorg_nesting WHERE Reorg_Key=? OR ParentKey=?"), entry.Key, entry.Key)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(s.DB.Rebind("DELETE FROM reorg_beacon_reorg WHERE Reorg_Key=?"), entry.Key)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(s.DB.Rebind("DELETE FROM reorg_tx WHERE Reorg_Key=?"), entry.Key)
 	if err != nil {
 		return err
 	}
-	_, err = s.DB.Exec("DELETE FROM reorg_summary WHERE id=$1", entry.Id)
+	_, err = s.DB.Exec(s.DB.Rebind("DELETE FROM reorg_block WHERE Reorg_Key=?"), entry.Key)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(s.DB.Rebind("DELETE FROM reorg_summary WHERE id=?"), entry.Id)
 	return err
 }
 
 func (s *DatabaseService) DeleteReorgEntry(entry ReorgEntry) error {
-	_, err := s.DB.Exec("DELETE FROM reorg_summary WHERE id=$1", entry.Id)
+	_, err := s.DB.Exec(s.DB.Rebind("DELETE FROM reorg_nesting WHERE Reorg_Key=? OR ParentKey=?"), entry.Key, entry.Key)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(s.DB.Rebind("DELETE FROM reorg_beacon_reorg WHERE Reorg_Key=?"), entry.Key)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(s.DB.Rebind("DELETE FROM reorg_summary WHERE id=?"), entry.Id)
 	return err
 }
 
 func (s *DatabaseService) DeleteBlockEntry(entry BlockEntry) error {
-	_, err := s.DB.Exec("DELETE FROM reorg_block WHERE id=$1", entry.Id)
+	_, err := s.DB.Exec(s.DB.Rebind("DELETE FROM reorg_block WHERE id=?"), entry.Id)
 	return err
 }
@@ -0,0 +1,307 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Migration changes the schema from Version-1 to Version (Up), or back (Down). Migrations are applied in order, each
+// in its own transaction, and recorded in the schema_version table.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// PostgresMigrations are the schema versions this binary knows. Never change a released migration, add a new one
+// instead, to both these and SQLiteMigrations. The first two use IF NOT EXISTS, because databases created before
+// schema_version already have these tables.
+var PostgresMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "reorg_summary and reorg_block",
+		Up: `
+CREATE TABLE IF NOT EXISTS reorg_summary (
+    Id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	Created_At timestamp NOT NULL default current_timestamp,
+
+    Key      VARCHAR (40) NOT NULL UNIQUE,
+    SeenLive boolean NOT NULL,
+
+    StartBlockNumber   integer NOT NULL,
+    EndBlockNumber     integer NOT NULL,
+    Depth              integer NOT NULL,
+    NumChains          integer NOT NULL,
+    NumBlocksInvolved  integer NOT NULL,
+    NumBlocksReplaced  integer NOT NULL,
+    MermaidSyntax text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS reorg_block (
+    Id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	Created_At timestamp NOT NULL default current_timestamp,
+
+    Reorg_Key VARCHAR (40) REFERENCES reorg_summary (Key) NOT NULL,
+
+    Origin        VARCHAR (20) NOT NULL,
+    NodeUri       text NOT NULL,
+
+    BlockNumber     integer NOT NULL,
+    BlockHash       text NOT NULL,
+    ParentHash      text NOT NULL,
+    BlockTimestamp  integer NOT NULL,
+    CoinbaseAddress text NOT NULL,
+
+    Difficulty bigint  NOT NULL,
+    NumUncles  integer NOT NULL,
+    NumTx      integer NOT NULL,
+
+    IsPartOfReorg boolean NOT NULL,
+    IsMainChain   boolean NOT NULL,
+    IsFirst       boolean NOT NULL,
+
+    MevGeth_CoinbaseDiffWei      NUMERIC(48, 0),
+    MevGeth_GasFeesWei           NUMERIC(48, 0),
+    MevGeth_EthSentToCoinbaseWei NUMERIC(48, 0),
+
+    MevGeth_CoinbaseDiffEth      VARCHAR(10),
+    MevGeth_EthSentToCoinbase    VARCHAR(10)
+);`,
+		Down: `
+DROP TABLE reorg_block;
+DROP TABLE reorg_summary;`,
+	},
+	{
+		Version: 2,
+		Name:    "beacon slot of reorg blocks",
+		Up: `
+ALTER TABLE reorg_block ADD COLUMN IF NOT EXISTS Beacon_Slot integer;
+ALTER TABLE reorg_block ADD COLUMN IF NOT EXISTS Beacon_ProposerIndex integer;
+ALTER TABLE reorg_block ADD COLUMN IF NOT EXISTS Beacon_BlockRoot text;`,
+		Down: `
+ALTER TABLE reorg_block DROP COLUMN Beacon_Slot;
+ALTER TABLE reorg_block DROP COLUMN Beacon_ProposerIndex;
+ALTER TABLE reorg_block DROP COLUMN Beacon_BlockRoot;`,
+	},
+	{
+		Version: 3,
+		Name:    "unique blocks per reorg",
+		// Remove duplicates left by the earlier non-atomic inserts, keeping the first row
+		Up: `
+DELETE FROM reorg_block a USING reorg_block b
+    WHERE a.Reorg_Key = b.Reorg_Key AND a.BlockHash = b.BlockHash AND a.Id > b.Id;
+ALTER TABLE reorg_block ADD CONSTRAINT reorg_block_reorg_key_blockhash_key UNIQUE (Reorg_Key, BlockHash);`,
+		Down: `
+ALTER TABLE reorg_block DROP CONSTRAINT reorg_block_reorg_key_blockhash_key;`,
+	},
+	{
+		Version: 4,
+		Name:    "reorg_tx",
+		Up: `
+CREATE TABLE reorg_tx (
+    Id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    Created_At timestamp NOT NULL default current_timestamp,
+
+    Reorg_Key VARCHAR (40) REFERENCES reorg_summary (Key) NOT NULL,
+    TxHash    text NOT NULL,
+    Status    VARCHAR (20) NOT NULL,
+
+    ReplacedBlockHash   text,
+    ReplacedBlockNumber integer,
+    ReplacedTxIndex     integer,
+
+    MainChainBlockHash   text,
+    MainChainBlockNumber integer,
+    MainChainTxIndex     integer,
+
+    UNIQUE (Reorg_Key, TxHash)
+);
+CREATE INDEX reorg_tx_txhash_idx ON reorg_tx (TxHash);`,
+		Down: `
+DROP TABLE reorg_tx;`,
+	},
+	{
+		Version: 5,
+		Name:    "reorg_nesting",
+		// A table instead of a reorg_summary column, because SQLite can't drop columns of referenced tables
+		Up: `
+CREATE TABLE reorg_nesting (
+    Reorg_Key VARCHAR (40) REFERENCES reorg_summary (Key) NOT NULL UNIQUE,
+    ParentKey VARCHAR (40) NOT NULL
+);
+CREATE INDEX reorg_nesting_parentkey_idx ON reorg_nesting (ParentKey);`,
+		Down: `
+DROP TABLE reorg_nesting;`,
+	},
+	{
+		Version: 6,
+		Name:    "reorg_beacon_reorg",
+		Up: `
+CREATE TABLE reorg_beacon_reorg (
+    Id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    Created_At timestamp NOT NULL default current_timestamp,
+
+    Reorg_Key     VARCHAR (40) REFERENCES reorg_summary (Key) NOT NULL,
+    BeaconReorgId VARCHAR (40) NOT NULL,
+    Slot          integer NOT NULL,
+    Depth         integer NOT NULL,
+    OldHeadBlock  text NOT NULL,
+    NewHeadBlock  text NOT NULL,
+    NodeUri       text NOT NULL,
+
+    UNIQUE (Reorg_Key, BeaconReorgId)
+);`,
+		Down: `
+DROP TABLE reorg_beacon_reorg;`,
+	},
+}
+
+// LatestSchemaVersion is the newest schema this binary can work with
+var LatestSchemaVersion = PostgresMigrations[len(PostgresMigrations)-1].Version
+
+// schemaVersionTable is lower case like the SQLite tables, and works with both databases. Migrate creates it.
+var schemaVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_version (
+    version    integer PRIMARY KEY,
+    name       text NOT NULL,
+    applied_at timestamp NOT NULL default current_timestamp
+);`
+
+// ErrSchemaTooNew is returned if the database was migrated by a newer version of the reorg monitor
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports, please upgrade reorg-monitor")
+
+// MigrationStatus is a known migration, and when it was applied (zero if it wasn't)
+type MigrationStatus struct {
+	Migration
+	AppliedAt time.Time
+}
+
+// IsVersioned returns false if the database has no schema_version table, because it is empty or was created before
+// schema versions. It only reads.
+func (s *DatabaseService) IsVersioned(ctx context.Context) (isVersioned bool, err error) {
+	query := "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_version')"
+	if s.DB.DriverName() == driverSQLite {
+		query = "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_version')"
+	}
+	err = s.DB.GetContext(ctx, &isVersioned, query)
+	return isVersioned, err
+}
+
+// SchemaVersion returns the current schema version (0 for an unversioned database). It only reads.
+func (s *DatabaseService) SchemaVersion(ctx context.Context) (version int, err error) {
+	isVersioned, err := s.IsVersioned(ctx)
+	if err != nil || !isVersioned {
+		return 0, err
+	}
+	err = s.DB.GetContext(ctx, &version, "SELECT COALESCE(MAX(Version), 0) FROM schema_version")
+	return version, err
+}
+
+// CheckSchemaVersion returns ErrSchemaTooNew if the database has a schema version this binary doesn't know
+func (s *DatabaseService) CheckSchemaVersion(ctx context.Context) (version int, err error) {
+	version, err = s.SchemaVersion(ctx)
+	if err != nil {
+		return version, err
+	}
+	if version > LatestSchemaVersion {
+		return version, errors.Wrapf(ErrSchemaTooNew, "database version %d, latest known version %d", version, LatestSchemaVersion)
+	}
+	return version, nil
+}
+
+// Migrate applies the up or down migrations to reach the target version
+func (s *DatabaseService) Migrate(ctx context.Context, targetVersion int) error {
+	if targetVersion < 0 || targetVersion > LatestSchemaVersion {
+		return fmt.Errorf("invalid schema version %d (latest: %d)", targetVersion, LatestSchemaVersion)
+	}
+
+	version, err := s.CheckSchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := s.DB.ExecContext(ctx, schemaVersionTable); err != nil {
+		return err
+	}
+
+	for _, migration := range s.migrations {
+		if migration.Version > version && migration.Version <= targetVersion {
+			if err := s.applyMigration(ctx, migration, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := len(s.migrations) - 1; i >= 0; i-- {
+		migration := s.migrations[i]
+		if migration.Version <= version && migration.Version > targetVersion {
+			if err := s.applyMigration(ctx, migration, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *DatabaseService) applyMigration(ctx context.Context, migration Migration, isUp bool) error {
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op after commit
+
+	direction := "down"
+	query := migration.Down
+	if isUp {
+		direction = "up"
+		query = migration.Up
+	}
+
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return errors.Wrapf(err, "migration %d (%s) %s failed", migration.Version, migration.Name, direction)
+	}
+
+	// The primary key also makes concurrent migrations of the same version fail
+	if isUp {
+		_, err = tx.ExecContext(ctx, tx.Rebind("INSERT INTO schema_version (Version, Name) VALUES (?, ?)"), migration.Version, migration.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, tx.Rebind("DELETE FROM schema_version WHERE Version = ?"), migration.Version)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "updating schema_version for migration %d failed", migration.Version)
+	}
+
+	return tx.Commit()
+}
+
+// MigrationStatus returns all known migrations, and when they were applied (none for an unversioned database). It
+// only reads.
+func (s *DatabaseService) MigrationStatus(ctx context.Context) (ret []MigrationStatus, err error) {
+	isVersioned, err := s.IsVersioned(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []struct {
+		Version    int
+		Applied_At sql.NullTime
+	}
+	if isVersioned {
+		if err := s.DB.SelectContext(ctx, &applied, "SELECT Version, Applied_At FROM schema_version"); err != nil {
+			return nil, err
+		}
+	}
+	appliedAt := make(map[int]time.Time)
+	for _, row := range applied {
+		appliedAt[row.Version] = row.Applied_At.Time
+	}
+
+	for _, migration := range s.migrations {
+		ret = append(ret, MigrationStatus{Migration: migration, AppliedAt: appliedAt[migration.Version]})
+	}
+	return ret, nil
+}
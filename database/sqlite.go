@@ -0,0 +1,171 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3" // needs cgo, and SQLite 3.35 for DROP COLUMN
+)
+
+// SQLiteMigrations are PostgresMigrations in the SQLite dialect. Names are lower case, because SQLite returns column
+// names as declared, and sqlx matches them with the lower case field names. Wei amounts are text, because they
+// don't fit into an integer.
+var SQLiteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "reorg_summary and reorg_block",
+		Up: `
+CREATE TABLE reorg_summary (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at timestamp NOT NULL default current_timestamp,
+
+    key      varchar(40) NOT NULL UNIQUE,
+    seenlive boolean NOT NULL,
+
+    startblocknumber   integer NOT NULL,
+    endblocknumber     integer NOT NULL,
+    depth              integer NOT NULL,
+    numchains          integer NOT NULL,
+    numblocksinvolved  integer NOT NULL,
+    numblocksreplaced  integer NOT NULL,
+    mermaidsyntax text NOT NULL
+);
+
+CREATE TABLE reorg_block (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at timestamp NOT NULL default current_timestamp,
+
+    reorg_key varchar(40) REFERENCES reorg_summary (key) NOT NULL,
+
+    origin        varchar(20) NOT NULL,
+    nodeuri       text NOT NULL,
+
+    blocknumber     integer NOT NULL,
+    blockhash       text NOT NULL,
+    parenthash      text NOT NULL,
+    blocktimestamp  integer NOT NULL,
+    coinbaseaddress text NOT NULL,
+
+    difficulty integer NOT NULL,
+    numuncles  integer NOT NULL,
+    numtx      integer NOT NULL,
+
+    ispartofreorg boolean NOT NULL,
+    ismainchain   boolean NOT NULL,
+    isfirst       boolean NOT NULL,
+
+    mevgeth_coinbasediffwei      text,
+    mevgeth_gasfeeswei           text,
+    mevgeth_ethsenttocoinbasewei text,
+
+    mevgeth_coinbasediffeth      text,
+    mevgeth_ethsenttocoinbase    text
+);`,
+		Down: `
+DROP TABLE reorg_block;
+DROP TABLE reorg_summary;`,
+	},
+	{
+		Version: 2,
+		Name:    "beacon slot of reorg blocks",
+		Up: `
+ALTER TABLE reorg_block ADD COLUMN beacon_slot integer;
+ALTER TABLE reorg_block ADD COLUMN beacon_proposerindex integer;
+ALTER TABLE reorg_block ADD COLUMN beacon_blockroot text;`,
+		Down: `
+ALTER TABLE reorg_block DROP COLUMN beacon_slot;
+ALTER TABLE reorg_block DROP COLUMN beacon_proposerindex;
+ALTER TABLE reorg_block DROP COLUMN beacon_blockroot;`,
+	},
+	{
+		Version: 3,
+		Name:    "unique blocks per reorg",
+		Up: `
+CREATE UNIQUE INDEX reorg_block_reorg_key_blockhash_key ON reorg_block (reorg_key, blockhash);`,
+		Down: `
+DROP INDEX reorg_block_reorg_key_blockhash_key;`,
+	},
+	{
+		Version: 4,
+		Name:    "reorg_tx",
+		Up: `
+CREATE TABLE reorg_tx (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at timestamp NOT NULL default current_timestamp,
+
+    reorg_key varchar(40) REFERENCES reorg_summary (key) NOT NULL,
+    txhash    text NOT NULL,
+    status    varchar(20) NOT NULL,
+
+    replacedblockhash   text,
+    replacedblocknumber integer,
+    replacedtxindex     integer,
+
+    mainchainblockhash   text,
+    mainchainblocknumber integer,
+    mainchaintxindex     integer,
+
+    UNIQUE (reorg_key, txhash)
+);
+CREATE INDEX reorg_tx_txhash_idx ON reorg_tx (txhash);`,
+		Down: `
+DROP TABLE reorg_tx;`,
+	},
+	{
+		Version: 5,
+		Name:    "reorg_nesting",
+		Up: `
+CREATE TABLE reorg_nesting (
+    reorg_key varchar(40) REFERENCES reorg_summary (key) NOT NULL UNIQUE,
+    parentkey varchar(40) NOT NULL
+);
+CREATE INDEX reorg_nesting_parentkey_idx ON reorg_nesting (parentkey);`,
+		Down: `
+DROP TABLE reorg_nesting;`,
+	},
+	{
+		Version: 6,
+		Name:    "reorg_beacon_reorg",
+		Up: `
+CREATE TABLE reorg_beacon_reorg (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at timestamp NOT NULL default current_timestamp,
+
+    reorg_key     varchar(40) REFERENCES reorg_summary (key) NOT NULL,
+    beaconreorgid varchar(40) NOT NULL,
+    slot          integer NOT NULL,
+    depth         integer NOT NULL,
+    oldheadblock  text NOT NULL,
+    newheadblock  text NOT NULL,
+    nodeuri       text NOT NULL,
+
+    UNIQUE (reorg_key, beaconreorgid)
+);`,
+		Down: `
+DROP TABLE reorg_beacon_reorg;`,
+	},
+}
+
+// openSQLite opens (or creates) the database file of a sqlite:// DSN. Query parameters are passed to the driver, see
+// https://github.com/mattn/go-sqlite3#connection-string
+func openSQLite(dsn string) (*DatabaseService, error) {
+	path := strings.TrimPrefix(dsn, SchemeSQLite)
+	params := "_busy_timeout=5000&_foreign_keys=1"
+	if strings.Contains(path, "?") {
+		path += "&" + params
+	} else {
+		path += "?" + params
+	}
+
+	db, err := sqlx.Connect(driverSQLite, path)
+	if err != nil {
+		return nil, err
+	}
+	// One connection: writes are serialized anyway, and every connection to :memory: would be a new database
+	db.SetMaxOpenConns(1)
+
+	return &DatabaseService{
+		DB:         db,
+		migrations: SQLiteMigrations,
+	}, nil
+}
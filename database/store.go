@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Store persists reorgs and their blocks. The backend is selected by the DSN scheme (see NewStore).
+type Store interface {
+	AddReorgWithBlocks(ctx context.Context, entry ReorgEntry, blockEntries []BlockEntry, txEntries []TxEntry) (isNew bool, err error)
+	AddBeaconReorgEntries(ctx context.Context, entries []BeaconReorgEntry) error
+
+	ReorgEntry(ctx context.Context, key string) (ReorgEntry, error)
+	ReorgEntries(ctx context.Context, filter ReorgEntriesFilter) ([]ReorgEntry, error)
+	BlockEntry(ctx context.Context, hash common.Hash) (BlockEntry, error)
+	BlockEntriesForReorg(ctx context.Context, reorgKey string) ([]BlockEntry, error)
+	BlockEntriesByHash(ctx context.Context, hash common.Hash) ([]BlockEntry, error)
+	TxEntriesForReorg(ctx context.Context, reorgKey string) ([]TxEntry, error)
+	BeaconReorgEntriesForReorg(ctx context.Context, reorgKey string) ([]BeaconReorgEntry, error)
+
+	IsVersioned(ctx context.Context) (bool, error)
+	SchemaVersion(ctx context.Context) (int, error)
+	CheckSchemaVersion(ctx context.Context) (int, error)
+	Migrate(ctx context.Context, targetVersion int) error
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+
+	Close()
+}
+
+var _ Store = (*DatabaseService)(nil)
+
+// SchemeSQLite selects SQLite: sqlite://reorgs.db (relative), sqlite:///var/lib/reorgs.db, sqlite://:memory:. All
+// other DSNs (eg. "postgres://..." or "host=localhost user=...") are passed to Postgres.
+const SchemeSQLite = "sqlite://"
+
+// NewStore connects to the database of the DSN, and migrates the schema to the latest version
+func NewStore(dsn string) (Store, error) {
+	s, err := NewDatabaseService(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenStore connects to the database of the DSN without checking or changing the schema
+func OpenStore(dsn string) (Store, error) {
+	s, err := OpenDatabaseService(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DescribeDSN returns the DSN without credentials, for logging
+func DescribeDSN(dsn string) string {
+	if strings.HasPrefix(dsn, SchemeSQLite) {
+		return dsn
+	}
+	if i := strings.LastIndex(dsn, "@"); i >= 0 {
+		return dsn[i+1:]
+	}
+	return "postgres"
+}
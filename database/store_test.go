@@ -0,0 +1,312 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/reorgtest"
+	"github.com/metachris/flashbotsrpc"
+)
+
+// newTestReorg builds a finished reorg of depth 1 at height 101: block A1 (txs 1 and 2) was replaced by block B1
+// (txs 2 and 3), whose child B2 ends the reorg
+func newTestReorg(t *testing.T) (reorg *analysis.Reorg, replacedHash common.Hash) {
+	t.Helper()
+	newTx := func(nonce uint64) *types.Transaction { return types.NewTx(&types.LegacyTx{Nonce: nonce}) }
+	blocks := reorgtest.NewBlocks(t, "A1 B2", map[string][]*types.Transaction{
+		"A1": {newTx(1), newTx(2)},
+		"B1": {newTx(2), newTx(3)},
+	})
+	reorg = reorgtest.NewReorg(t, blocks)
+	reorg.TxDiff = analysis.NewTxDiff(reorg)
+	return reorg, blocks["A1"].Hash
+}
+
+func newTestStore(t *testing.T) Store {
+	t.Helper()
+	store, err := NewStore(SchemeSQLite + filepath.Join(t.TempDir(), "reorgs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	return store
+}
+
+func TestSQLiteMigrationsMatchPostgres(t *testing.T) {
+	if len(SQLiteMigrations) != len(PostgresMigrations) {
+		t.Fatalf("expected %d SQLite migrations, got %d", len(PostgresMigrations), len(SQLiteMigrations))
+	}
+	for i, migration := range SQLiteMigrations {
+		if migration.Version != PostgresMigrations[i].Version || migration.Name != PostgresMigrations[i].Name {
+			t.Errorf("SQLite migration %d (%s) doesn't match Postgres migration %d (%s)", migration.Version, migration.Name, PostgresMigrations[i].Version, PostgresMigrations[i].Name)
+		}
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	reorg, replacedHash := newTestReorg(t)
+
+	version, err := store.SchemaVersion(ctx)
+	if err != nil || version != LatestSchemaVersion {
+		t.Fatalf("expected schema version %d, got %d (%v)", LatestSchemaVersion, version, err)
+	}
+
+	simulations := map[common.Hash]*flashbotsrpc.FlashbotsCallBundleResponse{
+		replacedHash: {CoinbaseDiff: "250000000000000000000000", GasFees: "1", EthSentToCoinbase: "0"},
+	}
+	entry := NewReorgEntry(reorg)
+	for i, expectNew := range []bool{true, false} {
+		isNew, err := store.AddReorgWithBlocks(ctx, entry, NewBlockEntries(reorg, simulations), NewTxEntries(reorg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isNew != expectNew {
+			t.Errorf("save %d: expected isNew=%v", i, expectNew)
+		}
+	}
+
+	savedEntry, err := store.ReorgEntry(ctx, entry.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if savedEntry.Depth != 1 || savedEntry.NumBlocksReplaced != 1 || !savedEntry.Created_At.Valid {
+		t.Errorf("unexpected reorg entry: %+v", savedEntry)
+	}
+
+	blockEntries, err := store.BlockEntriesForReorg(ctx, entry.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blockEntries) != len(reorg.BlocksInvolved) {
+		t.Fatalf("expected %d blocks (saved once), got %d", len(reorg.BlocksInvolved), len(blockEntries))
+	}
+
+	replaced, err := store.BlockEntry(ctx, replacedHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.MevGeth_CoinbaseDiffWei != "250000000000000000000000" || replaced.IsMainChain {
+		t.Errorf("unexpected replaced block: %+v", replaced)
+	}
+	if byHash, err := store.BlockEntriesByHash(ctx, replacedHash); err != nil || len(byHash) != 1 {
+		t.Errorf("expected one block by hash, got %d (%v)", len(byHash), err)
+	}
+
+	txEntries, err := store.TxEntriesForReorg(ctx, entry.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []string{}
+	for _, txEntry := range txEntries {
+		statuses = append(statuses, txEntry.Status)
+	}
+	if len(statuses) != 3 || statuses[0] != string(analysis.TxDropped) || statuses[1] != string(analysis.TxReincluded) || statuses[2] != string(analysis.TxNew) {
+		t.Errorf("expected dropped, reincluded and new tx (saved once), got %v", statuses)
+	}
+	if replacedTx := txEntries[1]; replacedTx.ReplacedTxIndex.Int64 != 1 || replacedTx.MainChainTxIndex.Int64 != 0 || !replacedTx.MainChainBlockHash.Valid {
+		t.Errorf("unexpected positions of re-included tx: %+v", replacedTx)
+	}
+
+	filters := map[string]ReorgEntriesFilter{
+		"all":        {},
+		"live":       {SeenLive: sql.NullBool{Bool: true, Valid: true}},
+		"since":      {Since: time.Now().Add(-time.Hour)},
+		"depth":      {MinDepth: 1, MaxDepth: 1},
+		"until-none": {Until: time.Now().Add(-time.Hour)},
+	}
+	for name, filter := range filters {
+		entries, err := store.ReorgEntries(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := name != "until-none"; (len(entries) == 1) != expected {
+			t.Errorf("filter %s: got %d reorgs", name, len(entries))
+		}
+	}
+}
+
+func TestSQLiteCreatedAt(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	reorg, _ := newTestReorg(t)
+
+	// Reorgs added after the fact keep their time, in the same transaction
+	createdAt := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	entry := NewReorgEntry(reorg)
+	entry.Created_At = sql.NullTime{Time: createdAt, Valid: true}
+	blockEntries := NewBlockEntries(reorg, nil)
+	blockEntries[0].Created_At = entry.Created_At
+	if _, err := store.AddReorgWithBlocks(ctx, entry, blockEntries, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := store.ReorgEntry(ctx, entry.Key)
+	if err != nil || !saved.Created_At.Time.Equal(createdAt) {
+		t.Fatalf("expected the reorg created at %s, got %s (%v)", createdAt, saved.Created_At.Time, err)
+	}
+	for _, filter := range []ReorgEntriesFilter{
+		{Since: createdAt, Until: createdAt},
+		{Since: createdAt.Add(-time.Second).In(time.FixedZone("UTC+2", 2*60*60)), Until: createdAt.Add(time.Second)},
+	} {
+		if entries, err := store.ReorgEntries(ctx, filter); err != nil || len(entries) != 1 {
+			t.Errorf("expected the reorg for %s - %s, got %d (%v)", filter.Since, filter.Until, len(entries), err)
+		}
+	}
+
+	savedBlocks, err := store.BlockEntriesForReorg(ctx, entry.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range savedBlocks {
+		if isOld := block.Created_At.Time.Equal(createdAt); isOld != (block.BlockHash == blockEntries[0].BlockHash) {
+			t.Errorf("expected only block %s created at %s, got %s at %s", blockEntries[0].BlockHash, createdAt, block.BlockHash, block.Created_At.Time)
+		}
+	}
+}
+
+func TestSQLiteNestedReorg(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	reorg, _ := newTestReorg(t)
+
+	parent := NewReorgEntry(reorg)
+	nested := NewReorgEntry(reorg)
+	nested.Key = "102_102_d1_b2_l"
+	nested.ParentKey = sql.NullString{String: parent.Key, Valid: true}
+	for _, entry := range []ReorgEntry{parent, nested} {
+		if _, err := store.AddReorgWithBlocks(ctx, entry, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, entry := range []ReorgEntry{parent, nested} {
+		saved, err := store.ReorgEntry(ctx, entry.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.ParentKey != entry.ParentKey {
+			t.Errorf("reorg %s: expected parent %+v, got %+v", entry.Key, entry.ParentKey, saved.ParentKey)
+		}
+	}
+	if entries, err := store.ReorgEntries(ctx, ReorgEntriesFilter{}); err != nil || len(entries) != 2 {
+		t.Errorf("expected 2 reorgs, got %d (%v)", len(entries), err)
+	}
+
+	// Deleting the parent deletes the nesting of the reorgs nested in it
+	saved, err := store.ReorgEntry(ctx, parent.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.(*DatabaseService).DeleteReorgEntry(saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := store.ReorgEntry(ctx, nested.Key); err != nil || saved.ParentKey.Valid {
+		t.Errorf("expected no parent after deleting it, got %+v (%v)", saved.ParentKey, err)
+	}
+}
+
+func TestSQLiteBeaconReorgs(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	reorg, _ := newTestReorg(t)
+	reorg.BeaconReorgs = append(reorg.BeaconReorgs, &analysis.BeaconReorg{Slot: 2, Depth: 1, NewHeadBlock: common.Hash{2}, NodeUri: "http://beacon"})
+	entry := NewReorgEntry(reorg)
+	if _, err := store.AddReorgWithBlocks(ctx, entry, NewBlockEntries(reorg, nil), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A beacon reorg matched later is added, adding one again is a no-op
+	late := NewBeaconReorgEntry(reorg.Id(), &analysis.BeaconReorg{Slot: 3, Depth: 1, NewHeadBlock: common.Hash{3}, NodeUri: "http://beacon"})
+	for _, entries := range [][]BeaconReorgEntry{NewBeaconReorgEntries(reorg), {late}, {late}} {
+		if err := store.AddBeaconReorgEntries(ctx, entries); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved, err := store.BeaconReorgEntriesForReorg(ctx, reorg.Id())
+	if err != nil || len(saved) != 2 || saved[0].BeaconReorgId != reorg.BeaconReorgs[0].Id() || saved[1].Slot != 3 {
+		t.Fatalf("expected 2 beacon reorgs, got %+v (%v)", saved, err)
+	}
+
+	// Deleting the reorg deletes its beacon reorgs
+	entry, err = store.ReorgEntry(ctx, reorg.Id())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.(*DatabaseService).DeleteReorgWithBlocks(entry); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := store.BeaconReorgEntriesForReorg(ctx, reorg.Id()); err != nil || len(saved) != 0 {
+		t.Errorf("expected no beacon reorgs after deleting the reorg, got %d (%v)", len(saved), err)
+	}
+}
+
+func TestSQLiteMigrateDownAndUp(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	reorg, _ := newTestReorg(t)
+	if _, err := store.AddReorgWithBlocks(ctx, NewReorgEntry(reorg), NewBlockEntries(reorg, nil), NewTxEntries(reorg)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Migration 2 down drops the beacon columns, and keeps the blocks
+	if err := store.Migrate(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Migrate(ctx, LatestSchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	blockEntries, err := store.BlockEntriesForReorg(ctx, reorg.Id())
+	if err != nil || len(blockEntries) != len(reorg.BlocksInvolved) {
+		t.Fatalf("expected %d blocks after migrating down and up, got %d (%v)", len(reorg.BlocksInvolved), len(blockEntries), err)
+	}
+
+	if err := store.Migrate(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := store.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if !status.AppliedAt.IsZero() {
+			t.Errorf("expected migration %d to be reverted", status.Version)
+		}
+	}
+}
+
+func TestSQLiteStatusOnlyReads(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenStore(SchemeSQLite + filepath.Join(t.TempDir(), "reorgs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 2; i++ {
+		if isVersioned, err := store.IsVersioned(ctx); err != nil || isVersioned {
+			t.Fatalf("expected an unversioned database, got %v (%v)", isVersioned, err)
+		}
+		if version, err := store.SchemaVersion(ctx); err != nil || version != 0 {
+			t.Fatalf("expected version 0, got %d (%v)", version, err)
+		}
+		statuses, err := store.MigrationStatus(ctx)
+		if err != nil || len(statuses) != LatestSchemaVersion || !statuses[0].AppliedAt.IsZero() {
+			t.Fatalf("expected %d pending migrations, got %+v (%v)", LatestSchemaVersion, statuses, err)
+		}
+	}
+
+	if err := store.Migrate(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if isVersioned, err := store.IsVersioned(ctx); err != nil || !isVersioned {
+		t.Errorf("expected a versioned database after migrating, got %v (%v)", isVersioned, err)
+	}
+}
@@ -3,62 +3,15 @@ package database
 import (
 	"database/sql"
 	"math/big"
+	"sort"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/flashbots/reorg-monitor/analysis"
 	"github.com/flashbots/reorg-monitor/reorgutils"
 	_ "github.com/lib/pq"
 	"github.com/metachris/flashbotsrpc"
 )
 
-var Schema = `
-CREATE TABLE IF NOT EXISTS reorg_summary (
-    Id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
-	Created_At timestamp NOT NULL default current_timestamp,
-
-    Key      VARCHAR (40) NOT NULL UNIQUE,
-    SeenLive boolean NOT NULL,
-
-    StartBlockNumber   integer NOT NULL,
-    EndBlockNumber     integer NOT NULL,
-    Depth              integer NOT NULL,
-    NumChains          integer NOT NULL,
-    NumBlocksInvolved  integer NOT NULL,
-    NumBlocksReplaced  integer NOT NULL,
-    MermaidSyntax text NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS reorg_block (
-    Id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
-	Created_At timestamp NOT NULL default current_timestamp,
-
-    Reorg_Key VARCHAR (40) REFERENCES reorg_summary (Key) NOT NULL,
-
-    Origin        VARCHAR (20) NOT NULL,
-    NodeUri       text NOT NULL,
-
-    BlockNumber     integer NOT NULL,
-    BlockHash       text NOT NULL,
-    ParentHash      text NOT NULL,
-    BlockTimestamp  integer NOT NULL,
-    CoinbaseAddress text NOT NULL,
-
-    Difficulty bigint  NOT NULL,
-    NumUncles  integer NOT NULL,
-    NumTx      integer NOT NULL,
-
-    IsPartOfReorg boolean NOT NULL,
-    IsMainChain   boolean NOT NULL,
-    IsFirst       boolean NOT NULL,
-
-    MevGeth_CoinbaseDiffWei      NUMERIC(48, 0),
-    MevGeth_GasFeesWei           NUMERIC(48, 0),
-    MevGeth_EthSentToCoinbaseWei NUMERIC(48, 0),
-
-    MevGeth_CoinbaseDiffEth      VARCHAR(10),
-    MevGeth_EthSentToCoinbase    VARCHAR(10)
-);
-`
-
 type ReorgEntry struct {
 	Id         int
 	Created_At sql.NullTime
@@ -73,10 +26,12 @@ type ReorgEntry struct {
 	NumBlocksInvolved int
 	NumBlocksReplaced int
 	MermaidSyntax     string
+
+	ParentKey sql.NullString // reorg this one is nested in (stored in reorg_nesting)
 }
 
 func NewReorgEntry(reorg *analysis.Reorg) ReorgEntry {
-	return ReorgEntry{
+	entry := ReorgEntry{
 		Key:               reorg.Id(),
 		SeenLive:          reorg.SeenLive,
 		StartBlockNumber:  reorg.StartBlockHeight,
@@ -87,6 +42,10 @@ func NewReorgEntry(reorg *analysis.Reorg) ReorgEntry {
 		NumBlocksReplaced: reorg.NumReplacedBlocks,
 		MermaidSyntax:     reorg.MermaidSyntax(),
 	}
+	if reorg.Parent != nil {
+		entry.ParentKey = sql.NullString{String: reorg.Parent.Id(), Valid: true}
+	}
+	return entry
 }
 
 type BlockEntry struct {
@@ -117,6 +76,10 @@ type BlockEntry struct {
 
 	MevGeth_CoinbaseDiffEth   string
 	MevGeth_EthSentToCoinbase string
+
+	Beacon_Slot          sql.NullInt64
+	Beacon_ProposerIndex sql.NullInt64
+	Beacon_BlockRoot     sql.NullString
 }
 
 func NewBlockEntry(block *analysis.Block, reorg *analysis.Reorg) BlockEntry {
@@ -150,9 +113,118 @@ func NewBlockEntry(block *analysis.Block, reorg *analysis.Reorg) BlockEntry {
 		MevGeth_EthSentToCoinbase: "0.000000",
 	}
 
+	if beaconBlock, found := reorg.BeaconBlocks[block.Hash]; found {
+		blockEntry.Beacon_Slot = sql.NullInt64{Int64: int64(beaconBlock.Slot), Valid: true}
+		blockEntry.Beacon_ProposerIndex = sql.NullInt64{Int64: int64(beaconBlock.ProposerIndex), Valid: true}
+		blockEntry.Beacon_BlockRoot = sql.NullString{String: beaconBlock.Root.String(), Valid: true}
+	}
+
 	return blockEntry
 }
 
+// NewBlockEntries returns the entries for all blocks involved in a reorg, ordered by block number and hash, with the
+// simulation results where available (simulations may be nil)
+func NewBlockEntries(reorg *analysis.Reorg, simulations map[common.Hash]*flashbotsrpc.FlashbotsCallBundleResponse) []BlockEntry {
+	entries := make([]BlockEntry, 0, len(reorg.BlocksInvolved))
+	for _, block := range reorg.BlocksInvolved {
+		entry := NewBlockEntry(block, reorg)
+		if res, found := simulations[block.Hash]; found {
+			entry.UpdateWitCallBundleResponse(*res)
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].BlockNumber != entries[j].BlockNumber {
+			return entries[i].BlockNumber < entries[j].BlockNumber
+		}
+		return entries[i].BlockHash < entries[j].BlockHash
+	})
+	return entries
+}
+
+// TxEntry is a transaction of a reorg from the tx diff. The replaced position is the first replaced block that
+// included it.
+type TxEntry struct {
+	Id         int
+	Created_At sql.NullTime
+
+	Reorg_Key string
+	TxHash    string
+	Status    string
+
+	ReplacedBlockHash   sql.NullString
+	ReplacedBlockNumber sql.NullInt64
+	ReplacedTxIndex     sql.NullInt64
+
+	MainChainBlockHash   sql.NullString
+	MainChainBlockNumber sql.NullInt64
+	MainChainTxIndex     sql.NullInt64
+}
+
+// NewTxEntries returns the entries for the tx diff of a reorg (none if it has no tx diff)
+func NewTxEntries(reorg *analysis.Reorg) []TxEntry {
+	if reorg.TxDiff == nil {
+		return nil
+	}
+
+	entries := make([]TxEntry, 0, len(reorg.TxDiff.Txs))
+	for _, tx := range reorg.TxDiff.Txs {
+		entry := TxEntry{
+			Reorg_Key: reorg.Id(),
+			TxHash:    tx.Hash.String(),
+			Status:    string(tx.Status),
+		}
+		if len(tx.Replaced) > 0 {
+			entry.ReplacedBlockHash = sql.NullString{String: tx.Replaced[0].BlockHash.String(), Valid: true}
+			entry.ReplacedBlockNumber = sql.NullInt64{Int64: int64(tx.Replaced[0].BlockNumber), Valid: true}
+			entry.ReplacedTxIndex = sql.NullInt64{Int64: int64(tx.Replaced[0].Index), Valid: true}
+		}
+		if tx.MainChain != nil {
+			entry.MainChainBlockHash = sql.NullString{String: tx.MainChain.BlockHash.String(), Valid: true}
+			entry.MainChainBlockNumber = sql.NullInt64{Int64: int64(tx.MainChain.BlockNumber), Valid: true}
+			entry.MainChainTxIndex = sql.NullInt64{Int64: int64(tx.MainChain.Index), Valid: true}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// BeaconReorgEntry is a chain_reorg event of a beacon node which matches a reorg
+type BeaconReorgEntry struct {
+	Id         int
+	Created_At sql.NullTime
+
+	Reorg_Key     string
+	BeaconReorgId string
+	Slot          uint64
+	Depth         uint64
+	OldHeadBlock  string
+	NewHeadBlock  string
+	NodeUri       string
+}
+
+func NewBeaconReorgEntry(reorgKey string, beaconReorg *analysis.BeaconReorg) BeaconReorgEntry {
+	return BeaconReorgEntry{
+		Reorg_Key:     reorgKey,
+		BeaconReorgId: beaconReorg.Id(),
+		Slot:          beaconReorg.Slot,
+		Depth:         beaconReorg.Depth,
+		OldHeadBlock:  beaconReorg.OldHeadBlock.String(),
+		NewHeadBlock:  beaconReorg.NewHeadBlock.String(),
+		NodeUri:       beaconReorg.NodeUri,
+	}
+}
+
+// NewBeaconReorgEntries returns the entries for the beacon reorgs matched when the reorg was finished
+func NewBeaconReorgEntries(reorg *analysis.Reorg) []BeaconReorgEntry {
+	entries := make([]BeaconReorgEntry, 0, len(reorg.BeaconReorgs))
+	for _, beaconReorg := range reorg.BeaconReorgs {
+		entries = append(entries, NewBeaconReorgEntry(reorg.Id(), beaconReorg))
+	}
+	return entries
+}
+
 func (e *BlockEntry) UpdateWitCallBundleResponse(callBundleResponse flashbotsrpc.FlashbotsCallBundleResponse) {
 	coinbaseDiffWei := new(big.Int)
 	coinbaseDiffWei.SetString(callBundleResponse.CoinbaseDiff, 10)
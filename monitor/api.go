@@ -0,0 +1,325 @@
+package monitor
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/apitypes"
+	"github.com/flashbots/reorg-monitor/database"
+)
+
+const (
+	defaultReorgsLimit = 100
+	maxReorgsLimit     = 1000
+)
+
+type ReorgsResponse struct {
+	Reorgs []apitypes.ReorgInfo
+}
+
+type ErrorResponse struct {
+	Error string
+}
+
+func (ws *MonitorWebserver) registerApiHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/reorgs", ws.HandleReorgsRequest)
+	mux.HandleFunc("/v1/reorgs/", ws.HandleReorgRequest)
+	mux.HandleFunc("/v1/blocks/", ws.HandleBlockRequest)
+	mux.HandleFunc("/v1/tree", ws.HandleTreeRequest)
+	mux.HandleFunc("/v1/stream", ws.HandleStreamRequest)
+	mux.HandleFunc("/v1/ws", ws.HandleWebsocketRequest)
+}
+
+// HandleReorgsRequest lists recent reorgs, latest (highest) first. Query parameters: min_depth, max_depth, live (true/false),
+// since and until (RFC3339 or unix timestamp), limit.
+func (ws *MonitorWebserver) HandleReorgsRequest(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseReorgFilter(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	res := ReorgsResponse{Reorgs: []apitypes.ReorgInfo{}}
+	isKnown := make(map[string]bool)
+	for _, info := range ws.Monitor.History.Reorgs(filter) {
+		res.Reorgs = append(res.Reorgs, info.Summary())
+		isKnown[info.Id] = true
+	}
+
+	// Older reorgs are only in the database
+	if ws.DB != nil && len(res.Reorgs) < filter.Limit {
+		entries, err := ws.DB.ReorgEntries(r.Context(), reorgEntriesFilter(filter))
+		if err != nil {
+			log.Println("error at db.ReorgEntries:", err)
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "database error"})
+			return
+		}
+
+		for _, entry := range entries {
+			if !isKnown[entry.Key] {
+				res.Reorgs = append(res.Reorgs, *reorgInfoFromEntry(entry))
+			}
+		}
+		// By height, because the observation times of the monitor and the database are from different clocks
+		sort.Slice(res.Reorgs, func(i, j int) bool {
+			if res.Reorgs[i].EndBlockHeight != res.Reorgs[j].EndBlockHeight {
+				return res.Reorgs[i].EndBlockHeight > res.Reorgs[j].EndBlockHeight
+			}
+			if res.Reorgs[i].StartBlockHeight != res.Reorgs[j].StartBlockHeight {
+				return res.Reorgs[i].StartBlockHeight > res.Reorgs[j].StartBlockHeight
+			}
+			return res.Reorgs[i].Id < res.Reorgs[j].Id
+		})
+		if len(res.Reorgs) > filter.Limit {
+			res.Reorgs = res.Reorgs[:filter.Limit]
+		}
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// HandleReorgRequest returns a reorg including all its chains (/v1/reorgs/{id})
+func (ws *MonitorWebserver) HandleReorgRequest(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/reorgs/")
+	if info := ws.Monitor.History.Reorg(id); info != nil {
+		writeJSON(w, http.StatusOK, info)
+		return
+	}
+
+	if ws.DB != nil {
+		entry, err := ws.DB.ReorgEntry(r.Context(), id)
+		if err == nil {
+			info := reorgInfoFromEntry(entry)
+			blockEntries, err := ws.DB.BlockEntriesForReorg(r.Context(), id)
+			if err != nil {
+				log.Println("error at db.BlockEntriesForReorg:", err)
+				writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "database error"})
+				return
+			}
+			info.Chains = chainsFromBlockEntries(blockEntries)
+			beaconReorgEntries, err := ws.DB.BeaconReorgEntriesForReorg(r.Context(), id)
+			if err != nil {
+				log.Println("error at db.BeaconReorgEntriesForReorg:", err)
+				writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "database error"})
+				return
+			}
+			for _, beaconReorgEntry := range beaconReorgEntries {
+				info.BeaconReorgIds = append(info.BeaconReorgIds, beaconReorgEntry.BeaconReorgId)
+			}
+			writeJSON(w, http.StatusOK, info)
+			return
+		} else if err != sql.ErrNoRows {
+			log.Println("error at db.ReorgEntry:", err)
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "database error"})
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("reorg %s not found", id)})
+}
+
+// HandleBlockRequest returns a block from the monitor cache, or from a known reorg (/v1/blocks/{hash})
+func (ws *MonitorWebserver) HandleBlockRequest(w http.ResponseWriter, r *http.Request) {
+	hashStr := strings.TrimPrefix(r.URL.Path, "/v1/blocks/")
+	if len(hashStr) != 66 || !strings.HasPrefix(hashStr, "0x") {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid block hash"})
+		return
+	}
+	hash := common.HexToHash(hashStr)
+
+	historyInfo := ws.Monitor.History.Block(hash)
+	if info := ws.Monitor.blockInfo(hash); info != nil {
+		if historyInfo != nil {
+			info.ReorgIds = historyInfo.ReorgIds
+		}
+		writeJSON(w, http.StatusOK, info)
+		return
+	}
+
+	if historyInfo != nil {
+		writeJSON(w, http.StatusOK, historyInfo)
+		return
+	}
+
+	if ws.DB != nil {
+		entries, err := ws.DB.BlockEntriesByHash(r.Context(), hash)
+		if err != nil {
+			log.Println("error at db.BlockEntriesByHash:", err)
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "database error"})
+			return
+		}
+		if len(entries) > 0 {
+			info := blockInfoFromEntry(entries[0])
+			for _, entry := range entries {
+				info.ReorgIds = append(info.ReorgIds, entry.Reorg_Key)
+			}
+			writeJSON(w, http.StatusOK, info)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("block %s not found", hash)})
+}
+
+// HandleTreeRequest returns all blocks in memory, as tree
+func (ws *MonitorWebserver) HandleTreeRequest(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ws.Monitor.TreeInfo())
+}
+
+// blockInfo returns a block from the cache, or nil if it isn't known
+func (mon *ReorgMonitor) blockInfo(hash common.Hash) *apitypes.BlockInfo {
+	mon.lock.RLock()
+	defer mon.lock.RUnlock()
+
+	block, found := mon.BlockByHash[hash]
+	if !found {
+		return nil
+	}
+	_, isMainChain := mon.Tree.MainChainNodeByHash[hash]
+	info := apitypes.NewBlockInfo(block, isMainChain)
+	return &info
+}
+
+func writeJSON(w http.ResponseWriter, status int, res interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+func parseReorgFilter(r *http.Request) (filter ReorgFilter, err error) {
+	query := r.URL.Query()
+	filter.Limit = defaultReorgsLimit
+
+	parseInt := func(key string, dst *int) {
+		if value := query.Get(key); value != "" && err == nil {
+			if *dst, err = strconv.Atoi(value); err != nil {
+				err = fmt.Errorf("invalid %s: %s", key, value)
+			}
+		}
+	}
+	parseInt("min_depth", &filter.MinDepth)
+	parseInt("max_depth", &filter.MaxDepth)
+	parseInt("limit", &filter.Limit)
+	if err != nil {
+		return filter, err
+	}
+	if filter.Limit <= 0 {
+		return filter, fmt.Errorf("invalid limit: %d", filter.Limit)
+	}
+	if filter.Limit > maxReorgsLimit {
+		filter.Limit = maxReorgsLimit
+	}
+
+	if value := query.Get("live"); value != "" {
+		seenLive, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid live: %s", value)
+		}
+		filter.SeenLive = &seenLive
+	}
+
+	if filter.Since, err = parseTime(query.Get("since")); err != nil {
+		return filter, fmt.Errorf("invalid since: %s", query.Get("since"))
+	}
+	if filter.Until, err = parseTime(query.Get("until")); err != nil {
+		return filter, fmt.Errorf("invalid until: %s", query.Get("until"))
+	}
+	return filter, nil
+}
+
+// parseTime accepts RFC3339 and unix timestamps (seconds). An empty string is the zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if timestamp, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(timestamp, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func reorgEntriesFilter(filter ReorgFilter) database.ReorgEntriesFilter {
+	ret := database.ReorgEntriesFilter{
+		MinDepth: filter.MinDepth,
+		MaxDepth: filter.MaxDepth,
+		Since:    filter.Since,
+		Until:    filter.Until,
+		Limit:    filter.Limit,
+	}
+	if filter.SeenLive != nil {
+		ret.SeenLive = sql.NullBool{Bool: *filter.SeenLive, Valid: true}
+	}
+	return ret
+}
+
+func reorgInfoFromEntry(entry database.ReorgEntry) *apitypes.ReorgInfo {
+	return &apitypes.ReorgInfo{
+		Id:                entry.Key,
+		ObservedAt:        entry.Created_At.Time.UTC(),
+		Source:            "database",
+		SeenLive:          entry.SeenLive,
+		StartBlockHeight:  entry.StartBlockNumber,
+		EndBlockHeight:    entry.EndBlockNumber,
+		Depth:             entry.Depth,
+		NumChains:         entry.NumChains,
+		NumBlocksInvolved: entry.NumBlocksInvolved,
+		NumReplacedBlocks: entry.NumBlocksReplaced,
+		ParentId:          entry.ParentKey.String,
+		NodeUris:          []string{},
+		BeaconReorgIds:    []string{},
+	}
+}
+
+func blockInfoFromEntry(entry database.BlockEntry) apitypes.BlockInfo {
+	return apitypes.BlockInfo{
+		Number:          entry.BlockNumber,
+		Hash:            common.HexToHash(entry.BlockHash),
+		ParentHash:      common.HexToHash(entry.ParentHash),
+		Timestamp:       entry.BlockTimestamp,
+		CoinbaseAddress: common.HexToAddress(entry.CoinbaseAddress),
+		NumTx:           entry.NumTx,
+		NumUncles:       entry.NumUncles,
+		Origin:          analysis.BlockOrigin(entry.Origin),
+		NodeUri:         entry.NodeUri,
+		ObservedAt:      entry.Created_At.Time.UTC(),
+		IsMainChain:     entry.IsMainChain,
+	}
+}
+
+// chainsFromBlockEntries rebuilds the branches of a stored reorg: a branch starts at each block without a parent in
+// the reorg or with siblings, and ends at the next fork.
+func chainsFromBlockEntries(entries []database.BlockEntry) []apitypes.ChainInfo {
+	blockByHash := make(map[common.Hash]apitypes.BlockInfo)
+	childrenByHash := make(map[common.Hash][]common.Hash)
+	for _, entry := range entries {
+		info := blockInfoFromEntry(entry)
+		info.ReorgIds = []string{entry.Reorg_Key}
+		blockByHash[info.Hash] = info
+		childrenByHash[info.ParentHash] = append(childrenByHash[info.ParentHash], info.Hash)
+	}
+
+	chains := []apitypes.ChainInfo{}
+	for _, entry := range entries {
+		root := blockByHash[common.HexToHash(entry.BlockHash)]
+		if _, hasParent := blockByHash[root.ParentHash]; hasParent && len(childrenByHash[root.ParentHash]) == 1 {
+			continue
+		}
+
+		chain := apitypes.ChainInfo{IsMainChain: root.IsMainChain, ParentHash: root.ParentHash, Blocks: []apitypes.BlockInfo{root}}
+		for children := childrenByHash[root.Hash]; len(children) == 1; children = childrenByHash[children[0]] {
+			chain.Blocks = append(chain.Blocks, blockByHash[children[0]])
+		}
+		chains = append(chains, chain)
+	}
+	apitypes.SortChains(chains)
+	return chains
+}
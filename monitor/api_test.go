@@ -0,0 +1,120 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/apitypes"
+	"github.com/flashbots/reorg-monitor/database"
+)
+
+// newTestWebserver serves reorgs 110 and 120 from the history, and 100 and 120 from the database
+func newTestWebserver(t *testing.T) *MonitorWebserver {
+	t.Helper()
+	mon := NewReorgMonitor(nil, make(chan *analysis.Reorg), false, 100)
+	for _, info := range []*apitypes.ReorgInfo{newTestReorgInfo("m110", 110, 1), newTestReorgInfo("m120", 120, 2)} {
+		info.Source = "memory"
+		mon.History.Add(info)
+	}
+
+	db, err := database.NewStore(database.SchemeSQLite + filepath.Join(t.TempDir(), "reorgs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	ctx := context.Background()
+	for _, entry := range []database.ReorgEntry{
+		{Key: "db100", StartBlockNumber: 100, EndBlockNumber: 100, Depth: 1, SeenLive: true},
+		{Key: "m120", StartBlockNumber: 120, EndBlockNumber: 121, Depth: 2},
+	} {
+		if _, err := db.AddReorgWithBlocks(ctx, entry, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	beaconReorg := &analysis.BeaconReorg{Slot: 1, Depth: 1, NewHeadBlock: common.Hash{1}}
+	if err := db.AddBeaconReorgEntries(ctx, []database.BeaconReorgEntry{database.NewBeaconReorgEntry("db100", beaconReorg)}); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := NewMonitorWebserver(mon, "")
+	ws.DB = db
+	return ws
+}
+
+func getJSON(t *testing.T, ws *MonitorWebserver, path string, res interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	ws.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code == http.StatusOK && res != nil {
+		if err := json.NewDecoder(rec.Body).Decode(res); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestHandleReorgsRequest(t *testing.T) {
+	ws := newTestWebserver(t)
+
+	// Merged by id, and ordered by height
+	var res ReorgsResponse
+	if code := getJSON(t, ws, "/v1/reorgs", &res); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if len(res.Reorgs) != 3 || res.Reorgs[0].Id != "m120" || res.Reorgs[1].Id != "m110" || res.Reorgs[2].Id != "db100" {
+		t.Fatalf("expected reorgs m120, m110, db100, got %+v", res.Reorgs)
+	}
+	if res.Reorgs[0].Source != "memory" || res.Reorgs[2].Source != "database" || res.Reorgs[0].Chains != nil {
+		t.Errorf("expected summaries from memory before the database, got %+v", res.Reorgs)
+	}
+
+	res = ReorgsResponse{}
+	if code := getJSON(t, ws, "/v1/reorgs?limit=2&max_depth=1", &res); code != http.StatusOK || len(res.Reorgs) != 2 || res.Reorgs[1].Id != "db100" {
+		t.Errorf("expected reorgs m110 and db100, got %d %+v", code, res.Reorgs)
+	}
+
+	for _, query := range []string{"limit=0", "limit=-1", "limit=x", "min_depth=x", "live=x", "since=x"} {
+		if code := getJSON(t, ws, "/v1/reorgs?"+query, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, code)
+		}
+	}
+}
+
+func TestHandleReorgRequest(t *testing.T) {
+	ws := newTestWebserver(t)
+
+	var info apitypes.ReorgInfo
+	if code := getJSON(t, ws, "/v1/reorgs/m110", &info); code != http.StatusOK || info.Source != "memory" || len(info.Chains) != 1 {
+		t.Errorf("expected reorg m110 with its chain from memory, got %d %+v", code, info)
+	}
+
+	info = apitypes.ReorgInfo{}
+	if code := getJSON(t, ws, "/v1/reorgs/db100", &info); code != http.StatusOK || info.Source != "database" || len(info.BeaconReorgIds) != 1 {
+		t.Errorf("expected reorg db100 with a beacon reorg from the database, got %d %+v", code, info)
+	}
+
+	if code := getJSON(t, ws, "/v1/reorgs/unknown", nil); code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", code)
+	}
+}
+
+func TestHandleBlockRequest(t *testing.T) {
+	ws := newTestWebserver(t)
+
+	var info apitypes.BlockInfo
+	if code := getJSON(t, ws, "/v1/blocks/"+common.Hash{110}.Hex(), &info); code != http.StatusOK || len(info.ReorgIds) != 1 || info.ReorgIds[0] != "m110" {
+		t.Errorf("expected block 110 of reorg m110, got %d %+v", code, info)
+	}
+	if code := getJSON(t, ws, "/v1/blocks/0x12", nil); code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", code)
+	}
+	if code := getJSON(t, ws, "/v1/blocks/"+common.Hash{1}.Hex(), nil); code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", code)
+	}
+}
@@ -0,0 +1,81 @@
+package monitor
+
+import (
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+)
+
+// API responses of the /v1 endpoints. They are built from the monitor state when a reorg is found or a request is
+// served, and share no memory with the monitor. Reorgs and blocks are in the apitypes package.
+
+type TreeInfo struct {
+	FirstBlockHash common.Hash
+	ForkChoice     string
+	HeadHashes     []common.Hash
+	RootHashes     []common.Hash
+	Nodes          []TreeNodeInfo // sorted by height
+}
+
+type TreeNodeInfo struct {
+	Number      uint64
+	Hash        common.Hash
+	ParentHash  common.Hash
+	ChildHashes []common.Hash
+	IsMainChain bool
+	Origin      analysis.BlockOrigin
+	NodeUri     string
+}
+
+// TreeInfo returns a copy of the block tree
+func (mon *ReorgMonitor) TreeInfo() *TreeInfo {
+	mon.lock.RLock()
+	defer mon.lock.RUnlock()
+	return newTreeInfo(mon.Tree)
+}
+
+// newTreeInfo converts the tree, the caller must hold the monitor lock
+func newTreeInfo(t *analysis.BlockTree) *TreeInfo {
+	info := TreeInfo{
+		ForkChoice: t.ForkChoice.Name(),
+		HeadHashes: []common.Hash{},
+		RootHashes: []common.Hash{},
+		Nodes:      make([]TreeNodeInfo, 0, len(t.NodeByHash)),
+	}
+
+	if t.FirstNode != nil {
+		info.FirstBlockHash = t.FirstNode.Block.Hash
+	}
+	for _, node := range t.HeadNodes {
+		info.HeadHashes = append(info.HeadHashes, node.Block.Hash)
+	}
+	for hash := range t.Roots {
+		info.RootHashes = append(info.RootHashes, hash)
+	}
+	sort.Slice(info.RootHashes, func(i, j int) bool { return info.RootHashes[i].Hex() < info.RootHashes[j].Hex() })
+
+	for _, node := range t.NodeByHash {
+		nodeInfo := TreeNodeInfo{
+			Number:      node.Block.Number,
+			Hash:        node.Block.Hash,
+			ParentHash:  node.Block.ParentHash,
+			ChildHashes: make([]common.Hash, 0, len(node.Children)),
+			IsMainChain: node.IsMainChain,
+			Origin:      node.Block.Origin,
+			NodeUri:     node.Block.NodeUri,
+		}
+		for _, child := range node.Children {
+			nodeInfo.ChildHashes = append(nodeInfo.ChildHashes, child.Block.Hash)
+		}
+		info.Nodes = append(info.Nodes, nodeInfo)
+	}
+	sort.Slice(info.Nodes, func(i, j int) bool {
+		if info.Nodes[i].Number == info.Nodes[j].Number {
+			return info.Nodes[i].Hash.Hex() < info.Nodes[j].Hash.Hex()
+		}
+		return info.Nodes[i].Number < info.Nodes[j].Number
+	})
+
+	return &info
+}
@@ -0,0 +1,147 @@
+package monitor
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/reorgutils"
+)
+
+const backfillConcurrency = 5
+
+type backfillBlock struct {
+	block   *types.Block
+	nodeUri string
+}
+
+// Backfill adds the last numBlocks canonical blocks of every connected node to the tree, so that reorgs spanning a
+// restart can be detected. Uncles of these blocks are downloaded by AddBlock. Nodes which disagree about the recent
+// chain contribute their own heads, which become siblings in the tree.
+//
+// The range is downloaded by number from the first node only. The other nodes are walked back from their heads by
+// parent hash, concurrently, until reaching a block which is already known.
+//
+// Backfill must be called before SubscribeAndListen, because it adds blocks from the calling goroutine.
+func (mon *ReorgMonitor) Backfill(ctx context.Context, numBlocks uint64) {
+	if numBlocks > uint64(mon.maxBlocksInCache) {
+		log.Printf("backfill: limiting %d blocks to max-blocks (%d)\n", numBlocks, mon.maxBlocksInCache)
+		numBlocks = uint64(mon.maxBlocksInCache)
+	}
+	if numBlocks == 0 {
+		return
+	}
+
+	timeStarted := time.Now()
+	conns := mon.connectionsByPreference("")
+	heads := make([]*types.Block, len(conns))
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn BlockSource) {
+			defer wg.Done()
+			latest, err := conn.BlockByNumber(ctx, nil)
+			if err != nil {
+				log.Printf("[conn %s] backfill: error getting latest block: %v\n", conn.Name(), err)
+				return
+			}
+			heads[i] = latest
+		}(i, conn)
+	}
+	wg.Wait()
+
+	var lock sync.Mutex
+	blocks := make(map[common.Hash]backfillBlock)
+	addBlock := func(block *types.Block, nodeUri string) (isNew bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		if _, isKnown := blocks[block.Hash()]; isKnown {
+			return false
+		}
+		blocks[block.Hash()] = backfillBlock{block: block, nodeUri: nodeUri}
+		return true
+	}
+
+	// The range of the first node
+	first := -1
+	for i, head := range heads {
+		if head == nil {
+			continue
+		}
+		first = i
+		endBlock := head.NumberU64()
+		startBlock := uint64(0)
+		if endBlock >= numBlocks {
+			startBlock = endBlock - numBlocks + 1
+		}
+
+		log.Printf("[conn %s] backfill: fetching blocks %d - %d\n", conns[i].Name(), startBlock, endBlock)
+		addBlock(head, conns[i].Name())
+		blockChan := make(chan *types.Block, 100)
+		go func() {
+			reorgutils.GetBlocksWithContext(ctx, blockChan, conns[i], int64(startBlock), int64(endBlock)-1, backfillConcurrency)
+			close(blockChan)
+		}()
+		for block := range blockChan {
+			addBlock(block, conns[i].Name())
+		}
+		break
+	}
+	if first < 0 || ctx.Err() != nil {
+		return
+	}
+
+	// The blocks of the other nodes which differ from the first node
+	for i, head := range heads {
+		if head == nil || i == first {
+			continue
+		}
+		wg.Add(1)
+		go func(conn BlockSource, head *types.Block) {
+			defer wg.Done()
+			block := head
+			for n := uint64(1); addBlock(block, conn.Name()) && n < numBlocks && block.NumberU64() > 0; n++ {
+				parent, err := conn.BlockByHash(ctx, block.ParentHash())
+				if err != nil {
+					log.Printf("[conn %s] backfill: error getting parent of %d %s: %v\n", conn.Name(), block.NumberU64(), block.Hash(), err)
+					return
+				}
+				block = parent
+			}
+		}(conns[i], head)
+	}
+	wg.Wait()
+
+	// Add from earliest to latest, so that parents are always known before their children
+	sortedBlocks := make([]backfillBlock, 0, len(blocks))
+	for _, b := range blocks {
+		sortedBlocks = append(sortedBlocks, b)
+	}
+	sort.Slice(sortedBlocks, func(i, j int) bool {
+		if sortedBlocks[i].block.NumberU64() == sortedBlocks[j].block.NumberU64() {
+			return sortedBlocks[i].block.Hash().Hex() < sortedBlocks[j].block.Hash().Hex()
+		}
+		return sortedBlocks[i].block.NumberU64() < sortedBlocks[j].block.NumberU64()
+	})
+
+	observed := time.Now().UTC().UnixNano()
+	for _, b := range sortedBlocks {
+		if ctx.Err() != nil {
+			return
+		}
+		mon.addBackfillBlock(ctx, analysis.NewBlock(b.block, analysis.OriginBackfill, b.nodeUri, observed))
+	}
+
+	log.Printf("backfill: added %d blocks in %.1f sec, %s\n", len(sortedBlocks), time.Since(timeStarted).Seconds(), mon)
+}
+
+// addBackfillBlock records and adds a backfilled block, without a reorg check
+func (mon *ReorgMonitor) addBackfillBlock(ctx context.Context, block *analysis.Block) {
+	mon.recordBlock(RecordBackfill, block)
+	mon.AddBlock(ctx, block)
+}
@@ -0,0 +1,72 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/mocknode"
+)
+
+func TestBackfill(t *testing.T) {
+	nodeA, nodeB := mocknode.NewNode(), mocknode.NewNode()
+	defer nodeA.Close()
+	defer nodeB.Close()
+
+	// Both nodes are at 110, node b has its own chain since 108
+	chainA := []*types.Block{newTestEthBlock(100, nil, 0)}
+	for i := 1; i <= 10; i++ {
+		chainA = append(chainA, newTestEthBlock(uint64(100+i), chainA[i-1], 0))
+	}
+	chainB := append([]*types.Block{}, chainA[:8]...)
+	for i := 8; i <= 10; i++ {
+		chainB = append(chainB, newTestEthBlock(uint64(100+i), chainB[i-1], 1))
+	}
+	nodeA.AddBlocks(chainA...)
+	nodeA.Announce(chainA[10])
+	nodeB.AddBlocks(chainB...)
+	nodeB.Announce(chainB[10])
+
+	// Polled (HTTP) nodes, to count requests. The range is downloaded from the node with the lower URI.
+	mon := NewReorgMonitor([]string{nodeA.HttpURL(), nodeB.HttpURL()}, make(chan *analysis.Reorg, 10), false, 100)
+	if mon.ConnectClients() != 2 {
+		t.Fatal("expected to connect to both nodes")
+	}
+	other := nodeB
+	if nodeB.HttpURL() < nodeA.HttpURL() {
+		other = nodeA
+	}
+	otherRequests := other.NumConnections()
+	mon.Backfill(context.Background(), 10)
+
+	for _, block := range append(chainA[1:], chainB[8:]...) {
+		if _, found := mon.BlockByHash[block.Hash()]; !found {
+			t.Errorf("expected block %d %s to be backfilled", block.NumberU64(), block.Hash())
+		} else if mon.BlockByHash[block.Hash()].Origin != analysis.OriginBackfill {
+			t.Errorf("expected block %d to be a backfilled block", block.NumberU64())
+		}
+	}
+	if len(mon.Tree.NodeByHash[chainA[7].Hash()].Children) != 2 {
+		t.Error("expected the chains of both nodes to fork after 107")
+	}
+
+	// Backfilled blocks are canonical blocks of the nodes, so the reorg between them counts as seen live
+	treeAnalysis, err := mon.AnalyzeTree(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(treeAnalysis.Reorgs) != 1 {
+		t.Fatalf("expected 1 reorg, got %d", len(treeAnalysis.Reorgs))
+	}
+	for _, reorg := range treeAnalysis.Reorgs {
+		if !reorg.SeenLive {
+			t.Errorf("expected the reorg of backfilled blocks to be seen live, got %s", reorg)
+		}
+	}
+
+	// The other node only serves its head 110, and its parents up to the first known block: 109, 108 and 107
+	if requests := other.NumConnections() - otherRequests; requests != 4 {
+		t.Errorf("expected 4 requests to the other node, got %d", requests)
+	}
+}
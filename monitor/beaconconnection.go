@@ -0,0 +1,387 @@
+// BeaconConnection consumes the event stream of a beacon node (Beacon API) and tries to reconnect on error
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/pkg/errors"
+)
+
+const (
+	beaconEventTopics     = "head,block,chain_reorg"
+	beaconRequestTimeout  = 10 * time.Second
+	beaconMaxEventLineLen = 1024 * 1024
+)
+
+// BeaconConnection reads events from its own goroutine. The fields below NewBeaconReorgChan are guarded by lock
+// (use Health() to read them).
+type BeaconConnection struct {
+	NodeUri            string
+	NewBlockChan       chan<- *analysis.BeaconBlock
+	NewBeaconReorgChan chan<- *analysis.BeaconReorg
+
+	lock sync.RWMutex
+
+	IsConnected         bool
+	IsSubscribed        bool
+	NextRetryTimeoutSec int64 // Wait time before retry. Starts at 5 seconds and doubles after each unsuccessful retry (max: 3 min).
+
+	NumResubscribes int64
+	NumBlocks       uint64
+	NumReorgs       uint64
+	HeadSlot        uint64
+	HeadRoot        common.Hash
+
+	client *http.Client // without timeout, used for the event stream
+}
+
+// BeaconConnectionHealth is the connection status of a beacon connection
+type BeaconConnectionHealth struct {
+	IsConnected         bool
+	IsSubscribed        bool
+	NumBlocks           uint64
+	NumReorgs           uint64
+	NumResubscribes     int64
+	NextRetryTimeoutSec int64
+	HeadSlot            uint64
+	HeadRoot            common.Hash
+}
+
+// Beacon API response and event payloads. Numbers are encoded as strings.
+type beaconSyncingResponse struct {
+	Data struct {
+		HeadSlot     string `json:"head_slot"`
+		SyncDistance string `json:"sync_distance"`
+		IsSyncing    bool   `json:"is_syncing"`
+	} `json:"data"`
+}
+
+type beaconHeadEvent struct {
+	Slot  string      `json:"slot"`
+	Block common.Hash `json:"block"`
+}
+
+type beaconBlockEvent struct {
+	Slot  string      `json:"slot"`
+	Block common.Hash `json:"block"`
+}
+
+type beaconChainReorgEvent struct {
+	Slot         string      `json:"slot"`
+	Depth        string      `json:"depth"`
+	OldHeadBlock common.Hash `json:"old_head_block"`
+	NewHeadBlock common.Hash `json:"new_head_block"`
+	OldHeadState common.Hash `json:"old_head_state"`
+	NewHeadState common.Hash `json:"new_head_state"`
+	Epoch        string      `json:"epoch"`
+}
+
+type beaconBlockResponse struct {
+	Data struct {
+		Message struct {
+			Slot          string      `json:"slot"`
+			ProposerIndex string      `json:"proposer_index"`
+			ParentRoot    common.Hash `json:"parent_root"`
+			Body          struct {
+				ExecutionPayload *struct {
+					BlockHash   common.Hash `json:"block_hash"`
+					BlockNumber string      `json:"block_number"`
+				} `json:"execution_payload"`
+			} `json:"body"`
+		} `json:"message"`
+	} `json:"data"`
+}
+
+func NewBeaconConnection(nodeUri string, newBlockChan chan<- *analysis.BeaconBlock, newBeaconReorgChan chan<- *analysis.BeaconReorg) (*BeaconConnection, error) {
+	conn := BeaconConnection{
+		NodeUri:             strings.TrimSuffix(nodeUri, "/"),
+		NewBlockChan:        newBlockChan,
+		NewBeaconReorgChan:  newBeaconReorgChan,
+		NextRetryTimeoutSec: 5,
+		client:              &http.Client{},
+	}
+
+	err := conn.Connect(context.Background())
+	return &conn, err
+}
+
+func (conn *BeaconConnection) Health() BeaconConnectionHealth {
+	conn.lock.RLock()
+	defer conn.lock.RUnlock()
+	return BeaconConnectionHealth{
+		IsConnected:         conn.IsConnected,
+		IsSubscribed:        conn.IsSubscribed,
+		NumBlocks:           conn.NumBlocks,
+		NumReorgs:           conn.NumReorgs,
+		NumResubscribes:     conn.NumResubscribes,
+		NextRetryTimeoutSec: conn.NextRetryTimeoutSec,
+		HeadSlot:            conn.HeadSlot,
+		HeadRoot:            conn.HeadRoot,
+	}
+}
+
+func (conn *BeaconConnection) setStatus(isConnected, isSubscribed bool) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	conn.IsConnected = isConnected
+	conn.IsSubscribed = isSubscribed
+	if isSubscribed {
+		conn.NextRetryTimeoutSec = 5
+	}
+}
+
+// Connect checks that the beacon node is reachable and not syncing
+func (conn *BeaconConnection) Connect(ctx context.Context) error {
+	fmt.Printf("[%25s] Connecting to beacon node... ", conn.NodeUri)
+	var syncing beaconSyncingResponse
+	err := conn.getJSON(ctx, "/eth/v1/node/syncing", &syncing)
+	if err != nil {
+		conn.setStatus(false, false)
+		return errors.Wrap(err, "error at node/syncing")
+	}
+
+	if syncing.Data.IsSyncing {
+		conn.setStatus(false, false)
+		return fmt.Errorf("error: sync in progress")
+	}
+
+	fmt.Printf("ok\n")
+	conn.setStatus(true, false)
+	return nil
+}
+
+// Subscribe consumes the beacon node event stream, and reconnects with increasing timeouts if it ends. It returns
+// once ctx is cancelled.
+func (conn *BeaconConnection) Subscribe(ctx context.Context) {
+	for ctx.Err() == nil {
+		if conn.Health().IsConnected || conn.Connect(ctx) == nil {
+			err := conn.readEventStream(ctx)
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("[conn %s] Event stream error: %+v\n", conn.NodeUri, err)
+			conn.setStatus(false, false)
+		}
+
+		conn.lock.Lock()
+		conn.NumResubscribes += 1
+		timeoutSec := conn.NextRetryTimeoutSec
+
+		// Now double time until next retry (max 3 min)
+		conn.NextRetryTimeoutSec *= 2
+		if conn.NextRetryTimeoutSec > 60*3 {
+			conn.NextRetryTimeoutSec = 60 * 3
+		}
+		conn.lock.Unlock()
+
+		log.Printf("[conn %s] resubscribing in %d seconds...\n", conn.NodeUri, timeoutSec)
+		select {
+		case <-time.After(time.Duration(timeoutSec) * time.Second):
+		case <-ctx.Done():
+		}
+	}
+	conn.setStatus(false, false)
+}
+
+// readEventStream reads server-sent events until the stream ends
+func (conn *BeaconConnection) readEventStream(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, conn.NodeUri+"/eth/v1/events?topics="+beaconEventTopics, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := conn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("event stream returned status %d", resp.StatusCode)
+	}
+
+	conn.setStatus(true, true)
+
+	// Events are separated by an empty line, and consist of "event:" and "data:" lines. The data of multiple "data:"
+	// lines is joined with newlines.
+	var eventType string
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), beaconMaxEventLineLen)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if eventType != "" {
+				err := conn.handleEvent(ctx, eventType, []byte(data.String()))
+				if err != nil {
+					log.Printf("[conn %s] error handling %s event: %+v\n", conn.NodeUri, eventType, err)
+				}
+			}
+			eventType = ""
+			data.Reset()
+		case strings.HasPrefix(line, ":"): // comment / keepalive
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteString("\n")
+			}
+			data.WriteString(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("event stream closed")
+}
+
+func (conn *BeaconConnection) handleEvent(ctx context.Context, eventType string, data []byte) error {
+	observed := time.Now().UTC().UnixNano()
+
+	switch eventType {
+	case "head":
+		var event beaconHeadEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		slot, err := strconv.ParseUint(event.Slot, 10, 64)
+		if err != nil {
+			return err
+		}
+		conn.lock.Lock()
+		conn.HeadSlot = slot
+		conn.HeadRoot = event.Block
+		conn.lock.Unlock()
+
+	case "block":
+		var event beaconBlockEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		conn.lock.Lock()
+		conn.NumBlocks += 1
+		conn.lock.Unlock()
+
+		// Fetch the full block, to get the proposer and execution payload
+		block, err := conn.BeaconBlockByRoot(ctx, event.Block)
+		if err != nil {
+			return err
+		}
+		block.ObservedUnixTimestamp = observed
+		select {
+		case conn.NewBlockChan <- block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+	case "chain_reorg":
+		var event beaconChainReorgEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		reorg, err := event.toBeaconReorg()
+		if err != nil {
+			return err
+		}
+		conn.lock.Lock()
+		conn.NumReorgs += 1
+		conn.lock.Unlock()
+		reorg.NodeUri = conn.NodeUri
+		reorg.ObservedUnixTimestamp = observed
+		select {
+		case conn.NewBeaconReorgChan <- reorg:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// BeaconBlockByRoot downloads a beacon block (/eth/v2/beacon/blocks)
+func (conn *BeaconConnection) BeaconBlockByRoot(ctx context.Context, root common.Hash) (*analysis.BeaconBlock, error) {
+	var resp beaconBlockResponse
+	err := conn.getJSON(ctx, "/eth/v2/beacon/blocks/"+root.Hex(), &resp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching beacon block %s", root)
+	}
+
+	msg := resp.Data.Message
+	block := analysis.BeaconBlock{
+		Root:       root,
+		ParentRoot: msg.ParentRoot,
+		NodeUri:    conn.NodeUri,
+	}
+
+	if block.Slot, err = strconv.ParseUint(msg.Slot, 10, 64); err != nil {
+		return nil, errors.Wrap(err, "invalid slot")
+	}
+	if block.ProposerIndex, err = strconv.ParseUint(msg.ProposerIndex, 10, 64); err != nil {
+		return nil, errors.Wrap(err, "invalid proposer index")
+	}
+
+	if payload := msg.Body.ExecutionPayload; payload != nil {
+		block.ExecutionBlockHash = payload.BlockHash
+		if block.ExecutionBlockNumber, err = strconv.ParseUint(payload.BlockNumber, 10, 64); err != nil {
+			return nil, errors.Wrap(err, "invalid execution block number")
+		}
+	}
+
+	return &block, nil
+}
+
+func (conn *BeaconConnection) getJSON(ctx context.Context, path string, dst interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, beaconRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, conn.NodeUri+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := conn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+func (event *beaconChainReorgEvent) toBeaconReorg() (reorg *analysis.BeaconReorg, err error) {
+	reorg = &analysis.BeaconReorg{
+		OldHeadBlock: event.OldHeadBlock,
+		NewHeadBlock: event.NewHeadBlock,
+		OldHeadState: event.OldHeadState,
+		NewHeadState: event.NewHeadState,
+	}
+
+	if reorg.Slot, err = strconv.ParseUint(event.Slot, 10, 64); err != nil {
+		return nil, errors.Wrap(err, "invalid slot")
+	}
+	if reorg.Depth, err = strconv.ParseUint(event.Depth, 10, 64); err != nil {
+		return nil, errors.Wrap(err, "invalid depth")
+	}
+	if reorg.Epoch, err = strconv.ParseUint(event.Epoch, 10, 64); err != nil {
+		return nil, errors.Wrap(err, "invalid epoch")
+	}
+	return reorg, nil
+}
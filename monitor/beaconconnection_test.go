@@ -0,0 +1,119 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+)
+
+var (
+	testBeaconBlockRoot = common.HexToHash("0x1111111111111111111111111111111111111111111111111111111111111111")
+	testExecBlockHash   = common.HexToHash("0x2222222222222222222222222222222222222222222222222222222222222222")
+	testOldHeadRoot     = common.HexToHash("0x3333333333333333333333333333333333333333333333333333333333333333")
+)
+
+// newBeaconTestServer is a stand-in for a beacon node, which sends a fixed list of events and then closes the stream
+func newBeaconTestServer(t *testing.T, events string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/eth/v1/node/syncing", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"head_slot":"100","sync_distance":"0","is_syncing":false}}`)
+	})
+	mux.HandleFunc("/eth/v2/beacon/blocks/"+testBeaconBlockRoot.Hex(), func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"version":"deneb","data":{"message":{"slot":"100","proposer_index":"42","parent_root":"%s","body":{"execution_payload":{"block_hash":"%s","block_number":"1000"}}}}}`, testOldHeadRoot.Hex(), testExecBlockHash.Hex())
+	})
+	mux.HandleFunc("/eth/v1/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("topics") != beaconEventTopics {
+			http.Error(w, "unexpected topics", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, events)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestBeaconConnectionEvents(t *testing.T) {
+	// The chain_reorg event has its data on two lines
+	events := ": keepalive\n\n" +
+		fmt.Sprintf("event: head\ndata: {\"slot\":\"100\",\"block\":\"%s\",\"epoch_transition\":false}\n\n", testBeaconBlockRoot.Hex()) +
+		fmt.Sprintf("event: block\ndata: {\"slot\":\"100\",\"block\":\"%s\"}\n\n", testBeaconBlockRoot.Hex()) +
+		fmt.Sprintf("event: chain_reorg\ndata: {\"slot\":\"100\",\"depth\":\"2\",\ndata: \"old_head_block\":\"%s\",\"new_head_block\":\"%s\",\"old_head_state\":\"%s\",\"new_head_state\":\"%s\",\"epoch\":\"3\"}\n\n",
+			testOldHeadRoot.Hex(), testBeaconBlockRoot.Hex(), testOldHeadRoot.Hex(), testBeaconBlockRoot.Hex())
+	srv := newBeaconTestServer(t, events)
+
+	blockChan := make(chan *analysis.BeaconBlock, 10)
+	reorgChan := make(chan *analysis.BeaconReorg, 10)
+	conn, err := NewBeaconConnection(srv.URL, blockChan, reorgChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.readEventStream(context.Background()); err == nil {
+		t.Fatal("expected error when event stream is closed")
+	}
+
+	if conn.HeadSlot != 100 || conn.HeadRoot != testBeaconBlockRoot {
+		t.Errorf("head mismatch: got slot %d root %s", conn.HeadSlot, conn.HeadRoot)
+	}
+
+	if len(blockChan) != 1 {
+		t.Fatalf("expected 1 beacon block, got %d", len(blockChan))
+	}
+	block := <-blockChan
+	if block.Slot != 100 || block.ProposerIndex != 42 || block.ParentRoot != testOldHeadRoot {
+		t.Errorf("beacon block mismatch: %s", block)
+	}
+	if block.ExecutionBlockHash != testExecBlockHash || block.ExecutionBlockNumber != 1000 {
+		t.Errorf("execution payload mismatch: %s", block)
+	}
+
+	if len(reorgChan) != 1 {
+		t.Fatalf("expected 1 beacon reorg, got %d", len(reorgChan))
+	}
+	reorg := <-reorgChan
+	if reorg.Slot != 100 || reorg.Depth != 2 || reorg.Epoch != 3 {
+		t.Errorf("beacon reorg mismatch: %s", reorg)
+	}
+	if reorg.OldHeadBlock != testOldHeadRoot || reorg.NewHeadBlock != testBeaconBlockRoot || reorg.NodeUri != srv.URL {
+		t.Errorf("beacon reorg mismatch: %s", reorg)
+	}
+}
+
+func TestBeaconConnectionSyncing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"head_slot":"100","sync_distance":"20","is_syncing":true}}`)
+	}))
+	defer srv.Close()
+
+	conn, err := NewBeaconConnection(srv.URL, nil, nil)
+	if err == nil || conn.IsConnected {
+		t.Fatal("expected connection to a syncing node to fail")
+	}
+}
+
+func TestBeaconConnectionCancel(t *testing.T) {
+	blocked := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-blocked
+	}))
+	defer srv.Close()
+	defer close(blocked)
+
+	conn := &BeaconConnection{NodeUri: srv.URL, client: &http.Client{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := conn.Connect(ctx); err == nil || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancelled context to abort the request, got %v", err)
+	}
+}
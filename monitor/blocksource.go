@@ -0,0 +1,47 @@
+package monitor
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/reorg-monitor/analysis"
+)
+
+// BlockSource delivers new blocks to the monitor, and serves blocks the monitor is missing (parents, uncles).
+// GethConnection is the implementation for a websocket or IPC connection to a node.
+type BlockSource interface {
+	// Name identifies the source (the node URI), and is used as NodeUri of the blocks it delivers
+	Name() string
+
+	// Subscribe sends new blocks to the channel the source was created with. It blocks until ctx is cancelled.
+	Subscribe(ctx context.Context) error
+
+	BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+
+	Health() BlockSourceHealth
+}
+
+// BlockSourceHealth is the connection status of a block source
+type BlockSourceHealth struct {
+	IsConnected         bool
+	IsSubscribed        bool
+	NumBlocks           uint64
+	HeadBlockNumber     uint64 // latest head announced by the node
+	NumReconnects       int64
+	NumResubscribes     int64 // subscribing connections only
+	NextRetryTimeoutSec int64 // subscribing connections only
+	NumPollErrors       int64 // polling connections only
+}
+
+// NewBlockSource creates the block source for a node URI: HTTP endpoints are polled, all others are subscribed to.
+// The source is returned even if the first connection attempt fails, because it keeps trying to reconnect.
+func NewBlockSource(nodeUri string, newBlockChan chan<- *analysis.Block, pollInterval time.Duration) (BlockSource, error) {
+	if IsPollingUri(nodeUri) {
+		return NewPollingConnection(nodeUri, newBlockChan, pollInterval)
+	}
+	return NewGethConnection(nodeUri, newBlockChan)
+}
@@ -1,13 +1,24 @@
 package monitor
 
+import "time"
+
 // Config defines attributes used by reorg monitor server and used for reading in
 // environment variables and CLI flags.
 type Config struct { // NOTE: ensure struct tags match flag names for each attribute
-	EthereumJsonRpcURIs []string `mapstructure:"ethereum-jsonrpc-uris"`
-	PostgresDSN         string   `mapstructure:"postgres-dsn"`
-	ListenAddress       string   `mapstructure:"listen-address"`
-	SimulateBlocks      bool     `mapstructure:"simulate-blocks"`
-	MevGethURI          string   `mapstructure:"mev-geth-uri"`
-	MaxBlocks           int      `mapstructure:"max-blocks"`
-	EnableDebug         bool     `mapstructure:"debug"`
+	EthereumJsonRpcURIs []string      `mapstructure:"ethereum-jsonrpc-uris"`
+	PollInterval        time.Duration `mapstructure:"poll-interval"`
+	BeaconApiURIs       []string      `mapstructure:"beacon-api-uris"`
+	DatabaseDSN         string        `mapstructure:"database-dsn"`
+	PostgresDSN         string        `mapstructure:"postgres-dsn"` // deprecated, DatabaseDSN is used if set
+	ListenAddress       string        `mapstructure:"listen-address"`
+	SimulateBlocks      bool          `mapstructure:"simulate-blocks"`
+	MevGethURI          string        `mapstructure:"mev-geth-uri"`
+	MaxBlocks           int           `mapstructure:"max-blocks"`
+	EnableDebug         bool          `mapstructure:"debug"`
+	ForkChoice          string        `mapstructure:"fork-choice"`
+	ForkChoiceNode      string        `mapstructure:"fork-choice-node"`
+	ShutdownTimeout     time.Duration `mapstructure:"shutdown-timeout"`
+	BackfillBlocks      uint64        `mapstructure:"backfill-blocks"`
+	WebhookConfig       string        `mapstructure:"webhook-config"`
+	RecordFile          string        `mapstructure:"record"`
 }
@@ -0,0 +1,108 @@
+package monitor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/flashbots/reorg-monitor/analysis"
+)
+
+// Time to wait for the matching reorg on the other layer, before raising a discrepancy. A beacon node reports a
+// chain_reorg as soon as its head changes, while an execution layer reorg is only finished a few blocks later.
+const reorgCorrelationWindow = 2 * time.Minute
+
+// How often correlations are checked for expiry, independent of incoming blocks and events
+const correlationCheckInterval = 10 * time.Second
+
+// pendingReorg is a finished reorg within the correlation window. It stays pending after a match, because several
+// beacon nodes can report the same reorg.
+type pendingReorg struct {
+	reorg          *analysis.Reorg
+	deadline       time.Time
+	beaconReorgIds map[string]bool // matched beacon reorgs
+}
+
+type pendingBeaconReorg struct {
+	beaconReorg *analysis.BeaconReorg
+	deadline    time.Time
+}
+
+// correlateReorg links a finished execution layer reorg to the slots of its blocks and to known beacon reorgs.
+// If there is no matching beacon reorg yet, it waits for one until the correlation window is over.
+func (mon *ReorgMonitor) correlateReorg(reorg *analysis.Reorg) {
+	for hash := range reorg.BlocksInvolved {
+		if beaconBlock, found := mon.BeaconBlockByExecutionHash[hash]; found {
+			reorg.BeaconBlocks[hash] = beaconBlock
+		}
+	}
+
+	if len(mon.beaconConnections) == 0 {
+		return
+	}
+
+	pending := &pendingReorg{reorg: reorg, deadline: time.Now().Add(reorgCorrelationWindow), beaconReorgIds: make(map[string]bool)}
+	for id, pendingBeacon := range mon.pendingBeaconReorgs {
+		if pendingBeacon.beaconReorg.Matches(reorg, mon.BeaconBlockByRoot) {
+			reorg.BeaconReorgs = append(reorg.BeaconReorgs, pendingBeacon.beaconReorg)
+			pending.beaconReorgIds[id] = true
+			delete(mon.pendingBeaconReorgs, id)
+		}
+	}
+	mon.pendingReorgs[reorg.Id()] = pending
+}
+
+// correlateBeaconReorg matches a beacon reorg to an execution layer reorg of the correlation window, or else waits
+// for the execution layer reorg until the window is over. That reorg was already sent, so the match is added to the
+// history here, and the beacon reorg links to it (see BeaconReorg.Reorg). Beacon reorgs which another beacon node
+// reported first are ignored.
+func (mon *ReorgMonitor) correlateBeaconReorg(beaconReorg *analysis.BeaconReorg) {
+	for id, pending := range mon.pendingReorgs {
+		if !beaconReorg.Matches(pending.reorg, mon.BeaconBlockByRoot) {
+			continue
+		}
+		if pending.beaconReorgIds[beaconReorg.Id()] {
+			return
+		}
+		log.Printf("%s matches %s\n", beaconReorg, id)
+		pending.beaconReorgIds[beaconReorg.Id()] = true
+		beaconReorg.Reorg = pending.reorg
+		mon.History.AddBeaconReorg(id, beaconReorg.Id())
+		return
+	}
+
+	if _, isPending := mon.pendingBeaconReorgs[beaconReorg.Id()]; !isPending {
+		mon.pendingBeaconReorgs[beaconReorg.Id()] = &pendingBeaconReorg{beaconReorg: beaconReorg, deadline: time.Now().Add(reorgCorrelationWindow)}
+	}
+}
+
+// expireCorrelations ends the correlation window of reorgs, and raises a discrepancy for every reorg which didn't get
+// a match on the other layer in time
+func (mon *ReorgMonitor) expireCorrelations(ctx context.Context, now time.Time) {
+	for id, pending := range mon.pendingReorgs {
+		if !now.After(pending.deadline) {
+			continue
+		}
+		delete(mon.pendingReorgs, id)
+		if len(pending.beaconReorgIds) == 0 {
+			mon.sendDiscrepancy(ctx, &analysis.ReorgDiscrepancy{Kind: analysis.DiscrepancyExecutionReorgOnly, Reorg: pending.reorg})
+		}
+	}
+
+	for id, pending := range mon.pendingBeaconReorgs {
+		if now.After(pending.deadline) {
+			delete(mon.pendingBeaconReorgs, id)
+			mon.sendDiscrepancy(ctx, &analysis.ReorgDiscrepancy{Kind: analysis.DiscrepancyBeaconReorgOnly, BeaconReorg: pending.beaconReorg})
+		}
+	}
+}
+
+func (mon *ReorgMonitor) sendDiscrepancy(ctx context.Context, discrepancy *analysis.ReorgDiscrepancy) {
+	log.Println(discrepancy.String())
+	if mon.NewDiscrepancyChan != nil {
+		select {
+		case mon.NewDiscrepancyChan <- discrepancy:
+		case <-ctx.Done():
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/apitypes"
+)
+
+// newCorrelationTestMonitor returns a monitor with a beacon connection, and a finished reorg of depth 1 at height 101,
+// whose replaced block is in the beacon block with root 0x01
+func newCorrelationTestMonitor(t *testing.T) (*ReorgMonitor, *analysis.Reorg, chan *analysis.ReorgDiscrepancy) {
+	t.Helper()
+	discrepancyChan := make(chan *analysis.ReorgDiscrepancy, 10)
+	mon := NewReorgMonitor([]string{"ws://a"}, make(chan *analysis.Reorg, 10), false, 100)
+	mon.beaconConnections["http://beacon"] = &BeaconConnection{}
+	mon.NewDiscrepancyChan = discrepancyChan
+
+	p := newTestEthBlock(100, nil, 0)
+	a1 := newTestEthBlock(101, p, 1)
+	b1 := newTestEthBlock(101, p, 2)
+	b2 := newTestEthBlock(102, b1, 2)
+	for _, block := range []*analysis.Block{
+		analysis.NewBlock(p, analysis.OriginSubscription, "ws://a", 0),
+		analysis.NewBlock(a1, analysis.OriginSubscription, "ws://a", 0),
+		analysis.NewBlock(b1, analysis.OriginSubscription, "ws://a", 0),
+		analysis.NewBlock(b2, analysis.OriginSubscription, "ws://a", 0),
+	} {
+		if err := mon.Tree.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reorg, err := analysis.NewReorg(mon.Tree.NodeByHash[p.Hash()], 102)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mon.BeaconBlockByRoot[common.Hash{1}] = &analysis.BeaconBlock{Slot: 1, Root: common.Hash{1}, ExecutionBlockHash: a1.Hash()}
+	return mon, reorg, discrepancyChan
+}
+
+func newTestBeaconReorg(nodeUri string, oldHead common.Hash) *analysis.BeaconReorg {
+	return &analysis.BeaconReorg{Slot: 2, Depth: 1, OldHeadBlock: oldHead, NewHeadBlock: common.Hash{2}, NodeUri: nodeUri}
+}
+
+func TestCorrelateBeaconReorgAfterReorg(t *testing.T) {
+	mon, reorg, discrepancyChan := newCorrelationTestMonitor(t)
+	mon.correlateReorg(reorg)
+	mon.History.Add(apitypes.NewReorgInfo(reorg, time.Now()))
+
+	// The reorg was already sent, so the match is linked from the beacon reorg and added to the history
+	beaconReorg := newTestBeaconReorg("http://beacon", common.Hash{1})
+	mon.correlateBeaconReorg(beaconReorg)
+	if beaconReorg.Reorg != reorg {
+		t.Fatal("expected the beacon reorg to link to the reorg")
+	}
+	if info := mon.History.Reorg(reorg.Id()); len(info.BeaconReorgIds) != 1 || info.BeaconReorgIds[0] != beaconReorg.Id() {
+		t.Errorf("expected the beacon reorg in the history, got %v", info.BeaconReorgIds)
+	}
+
+	// The same reorg reported by another beacon node is neither linked again nor a discrepancy
+	duplicate := newTestBeaconReorg("http://beacon2", common.Hash{1})
+	mon.correlateBeaconReorg(duplicate)
+	if duplicate.Reorg != nil || len(mon.pendingBeaconReorgs) != 0 {
+		t.Error("expected the duplicate to be ignored")
+	}
+	if info := mon.History.Reorg(reorg.Id()); len(info.BeaconReorgIds) != 1 {
+		t.Errorf("expected one beacon reorg in the history, got %v", info.BeaconReorgIds)
+	}
+
+	mon.expireCorrelations(context.Background(), time.Now().Add(reorgCorrelationWindow+time.Second))
+	if len(mon.pendingReorgs) != 0 || len(discrepancyChan) != 0 {
+		t.Errorf("expected no pending reorgs and no discrepancy, got %d and %d", len(mon.pendingReorgs), len(discrepancyChan))
+	}
+}
+
+func TestCorrelateBeaconReorgBeforeReorg(t *testing.T) {
+	mon, reorg, discrepancyChan := newCorrelationTestMonitor(t)
+	beaconReorg := newTestBeaconReorg("http://beacon", common.Hash{1})
+	mon.correlateBeaconReorg(beaconReorg)
+	if len(mon.pendingBeaconReorgs) != 1 {
+		t.Fatalf("expected a pending beacon reorg, got %d", len(mon.pendingBeaconReorgs))
+	}
+
+	mon.correlateReorg(reorg)
+	if len(reorg.BeaconReorgs) != 1 || reorg.BeaconReorgs[0] != beaconReorg || len(mon.pendingBeaconReorgs) != 0 {
+		t.Fatalf("expected the beacon reorg attached to the reorg, got %v", reorg.BeaconReorgs)
+	}
+	if info := apitypes.NewReorgInfo(reorg, time.Now()); len(info.BeaconReorgIds) != 1 {
+		t.Errorf("expected the beacon reorg id in the reorg info, got %v", info.BeaconReorgIds)
+	}
+
+	mon.expireCorrelations(context.Background(), time.Now().Add(reorgCorrelationWindow+time.Second))
+	if len(discrepancyChan) != 0 {
+		t.Errorf("expected no discrepancy, got %d", len(discrepancyChan))
+	}
+}
+
+func TestCorrelationDiscrepancies(t *testing.T) {
+	mon, reorg, discrepancyChan := newCorrelationTestMonitor(t)
+	unrelated := newTestBeaconReorg("http://beacon", common.Hash{3})
+	mon.correlateBeaconReorg(unrelated)
+	mon.correlateReorg(reorg)
+
+	// Nothing expires within the window
+	ctx := context.Background()
+	mon.expireCorrelations(ctx, time.Now().Add(reorgCorrelationWindow/2))
+	if len(discrepancyChan) != 0 {
+		t.Fatalf("expected no discrepancy within the window, got %d", len(discrepancyChan))
+	}
+
+	mon.expireCorrelations(ctx, time.Now().Add(reorgCorrelationWindow+time.Second))
+	if len(discrepancyChan) != 2 || len(mon.pendingReorgs) != 0 || len(mon.pendingBeaconReorgs) != 0 {
+		t.Fatalf("expected 2 discrepancies and nothing pending, got %d", len(discrepancyChan))
+	}
+	for i := 0; i < 2; i++ {
+		discrepancy := <-discrepancyChan
+		switch discrepancy.Kind {
+		case analysis.DiscrepancyExecutionReorgOnly:
+			if discrepancy.Reorg != reorg {
+				t.Errorf("expected discrepancy for reorg %s, got %s", reorg.Id(), discrepancy)
+			}
+		case analysis.DiscrepancyBeaconReorgOnly:
+			if discrepancy.BeaconReorg != unrelated {
+				t.Errorf("expected discrepancy for beacon reorg %s, got %s", unrelated.Id(), discrepancy)
+			}
+		}
+	}
+}
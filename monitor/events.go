@@ -0,0 +1,238 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/apitypes"
+)
+
+type EventType string
+
+const (
+	EventNewBlock         EventType = "new_block"         // a block was added to the monitor (Block)
+	EventSplitStarted     EventType = "split_started"     // a block got a second or later child (Split)
+	EventReorgFinished    EventType = "reorg_finished"    // a reorg is finished (Reorg)
+	EventNodeDisconnected EventType = "node_disconnected" // a node lost its subscription (NodeUri)
+	EventNodeConnected    EventType = "node_connected"    // a node is subscribed again (NodeUri)
+)
+
+var AllEventTypes = []EventType{EventNewBlock, EventSplitStarted, EventReorgFinished, EventNodeDisconnected, EventNodeConnected}
+
+// Event is sent to the clients of the event stream. Only the field for the event type is set.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	Block   *apitypes.BlockInfo `json:",omitempty"`
+	Split   *SplitInfo          `json:",omitempty"`
+	Reorg   *apitypes.ReorgInfo `json:",omitempty"`
+	NodeUri string              `json:",omitempty"`
+}
+
+// SplitInfo describes competing blocks at the same height, with the same parent
+type SplitInfo struct {
+	Height           uint64
+	CommonParentHash common.Hash
+	BlockHashes      []common.Hash
+}
+
+// EventFilter selects the events of a subscription. MinDepth only applies to reorg events.
+type EventFilter struct {
+	Types    map[EventType]bool // all types if empty
+	MinDepth int
+}
+
+// ParseEventTypes parses a comma separated list of event types
+func ParseEventTypes(s string) (map[EventType]bool, error) {
+	types := make(map[EventType]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		isKnown := false
+		for _, eventType := range AllEventTypes {
+			if string(eventType) == name {
+				types[eventType] = true
+				isKnown = true
+			}
+		}
+		if !isKnown {
+			return nil, fmt.Errorf("unknown event type: %s", name)
+		}
+	}
+	return types, nil
+}
+
+func (f EventFilter) Matches(event *Event) bool {
+	if len(f.Types) > 0 && !f.Types[event.Type] {
+		return false
+	}
+	if event.Reorg != nil && event.Reorg.Depth < f.MinDepth {
+		return false
+	}
+	return true
+}
+
+// Number of events buffered per subscriber. If a client can't keep up, further events are dropped for it, because
+// the monitor must never wait for a client.
+const eventBufferSize = 256
+
+// EventHub distributes the monitor events to all subscribers
+type EventHub struct {
+	lock        sync.Mutex
+	subscribers map[*EventSubscription]bool
+	isClosed    bool
+}
+
+type EventSubscription struct {
+	C      chan *Event // closed when the subscription or the hub is closed
+	Filter EventFilter
+
+	hub        *EventHub
+	numDropped uint64 // guarded by hub.lock
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subscribers: make(map[*EventSubscription]bool),
+	}
+}
+
+func (h *EventHub) Subscribe(filter EventFilter) *EventSubscription {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	sub := &EventSubscription{
+		C:      make(chan *Event, eventBufferSize),
+		Filter: filter,
+		hub:    h,
+	}
+	if h.isClosed {
+		close(sub.C)
+		return sub
+	}
+	h.subscribers[sub] = true
+	return sub
+}
+
+// Publish sends the event to all matching subscribers without blocking. The event must not be changed afterwards.
+func (h *EventHub) Publish(event *Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for sub := range h.subscribers {
+		if !sub.Filter.Matches(event) {
+			continue
+		}
+		select {
+		case sub.C <- event:
+		default:
+			sub.numDropped += 1
+		}
+	}
+}
+
+// NumSubscribers returns the number of open subscriptions
+func (h *EventHub) NumSubscribers() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.subscribers)
+}
+
+// Close ends all subscriptions, eg. on shutdown
+func (h *EventHub) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.isClosed = true
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.C)
+	}
+}
+
+// Close ends the subscription. It's safe to call it more than once.
+func (sub *EventSubscription) Close() {
+	sub.hub.lock.Lock()
+	defer sub.hub.lock.Unlock()
+
+	if sub.hub.subscribers[sub] {
+		delete(sub.hub.subscribers, sub)
+		close(sub.C)
+	}
+}
+
+// NumDropped returns the number of events which were dropped because the subscriber was too slow
+func (sub *EventSubscription) NumDropped() uint64 {
+	sub.hub.lock.Lock()
+	defer sub.hub.lock.Unlock()
+	return sub.numDropped
+}
+
+// How often the monitor checks the connections for node_connected and node_disconnected events
+const connectionCheckInterval = time.Second
+
+// blockEvents returns the events for a block that was just added to the tree. isNew is false if the block replaced
+// one only known as an uncle, whose splits were already published. The caller must hold the monitor lock.
+func (mon *ReorgMonitor) blockEvents(block *analysis.Block, isNew bool) []*Event {
+	now := time.Now().UTC()
+	_, isMainChain := mon.Tree.MainChainNodeByHash[block.Hash]
+	blockInfo := apitypes.NewBlockInfo(block, isMainChain)
+	events := []*Event{{Type: EventNewBlock, Time: now, Block: &blockInfo}}
+
+	node, found := mon.Tree.NodeByHash[block.Hash]
+	if !found || !isNew {
+		return events
+	}
+
+	// The block is another child of its parent, or it adopted blocks which arrived before it
+	if node.Parent != nil && len(node.Parent.Children) >= 2 {
+		events = append(events, newSplitEvent(node.Parent, now))
+	}
+	if len(node.Children) >= 2 {
+		events = append(events, newSplitEvent(node, now))
+	}
+	return events
+}
+
+func newSplitEvent(parent *analysis.TreeNode, now time.Time) *Event {
+	split := SplitInfo{
+		Height:           parent.Block.Number + 1,
+		CommonParentHash: parent.Block.Hash,
+		BlockHashes:      []common.Hash{},
+	}
+	for _, child := range parent.Children {
+		split.BlockHashes = append(split.BlockHashes, child.Block.Hash)
+	}
+	return &Event{Type: EventSplitStarted, Time: now, Split: &split}
+}
+
+// checkConnections publishes an event for every node which lost or regained its subscription since the last check
+func (mon *ReorgMonitor) checkConnections() {
+	isSubscribedByNode := make(map[string]bool)
+	mon.lock.RLock()
+	for nodeUri, conn := range mon.connections {
+		isSubscribedByNode[nodeUri] = conn.Health().IsSubscribed
+	}
+	for nodeUri, conn := range mon.beaconConnections {
+		isSubscribedByNode[nodeUri] = conn.Health().IsSubscribed
+	}
+	mon.lock.RUnlock()
+
+	for nodeUri, isSubscribed := range isSubscribedByNode {
+		wasSubscribed, isKnown := mon.isSubscribedByNode[nodeUri]
+		mon.isSubscribedByNode[nodeUri] = isSubscribed
+		if isSubscribed && !wasSubscribed {
+			mon.Events.Publish(&Event{Type: EventNodeConnected, Time: time.Now().UTC(), NodeUri: nodeUri})
+		} else if !isSubscribed && isKnown && wasSubscribed {
+			mon.Events.Publish(&Event{Type: EventNodeDisconnected, Time: time.Now().UTC(), NodeUri: nodeUri})
+		}
+	}
+}
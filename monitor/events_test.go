@@ -0,0 +1,191 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/apitypes"
+	"github.com/gorilla/websocket"
+)
+
+func TestParseEventTypes(t *testing.T) {
+	types, err := ParseEventTypes(" new_block,reorg_finished ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 2 || !types[EventNewBlock] || !types[EventReorgFinished] {
+		t.Errorf("expected new_block and reorg_finished, got %v", types)
+	}
+	if types, err := ParseEventTypes(""); err != nil || len(types) != 0 {
+		t.Errorf("expected no types, got %v (%v)", types, err)
+	}
+	if _, err := ParseEventTypes("new_block,unknown"); err == nil {
+		t.Error("expected an error for an unknown type")
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	blockEvent := &Event{Type: EventNewBlock}
+	reorgEvent := &Event{Type: EventReorgFinished, Reorg: &apitypes.ReorgInfo{Depth: 2}}
+
+	if filter := (EventFilter{}); !filter.Matches(blockEvent) || !filter.Matches(reorgEvent) {
+		t.Error("expected an empty filter to match all events")
+	}
+	if filter := (EventFilter{Types: map[EventType]bool{EventReorgFinished: true}}); filter.Matches(blockEvent) || !filter.Matches(reorgEvent) {
+		t.Error("expected the filter to match only reorg events")
+	}
+	if filter := (EventFilter{MinDepth: 3}); !filter.Matches(blockEvent) || filter.Matches(reorgEvent) {
+		t.Error("expected min depth to filter only reorg events")
+	}
+}
+
+func TestEventHub(t *testing.T) {
+	hub := NewEventHub()
+	all := hub.Subscribe(EventFilter{})
+	reorgs := hub.Subscribe(EventFilter{Types: map[EventType]bool{EventReorgFinished: true}})
+	if hub.NumSubscribers() != 2 {
+		t.Fatalf("expected 2 subscribers, got %d", hub.NumSubscribers())
+	}
+
+	// Events are dropped for the full subscription only
+	for i := 0; i < eventBufferSize+5; i++ {
+		hub.Publish(&Event{Type: EventNewBlock})
+	}
+	hub.Publish(&Event{Type: EventReorgFinished, Reorg: &apitypes.ReorgInfo{}})
+	if all.NumDropped() != 6 || len(all.C) != eventBufferSize {
+		t.Errorf("expected 6 dropped and %d buffered events, got %d and %d", eventBufferSize, all.NumDropped(), len(all.C))
+	}
+	if reorgs.NumDropped() != 0 || len(reorgs.C) != 1 {
+		t.Errorf("expected one reorg event, got %d (%d dropped)", len(reorgs.C), reorgs.NumDropped())
+	}
+
+	reorgs.Close()
+	reorgs.Close()
+	if hub.NumSubscribers() != 1 {
+		t.Errorf("expected 1 subscriber, got %d", hub.NumSubscribers())
+	}
+
+	// Closing the hub closes the remaining and all later subscriptions
+	hub.Close()
+	for range all.C {
+	}
+	if _, ok := <-hub.Subscribe(EventFilter{}).C; ok || hub.NumSubscribers() != 0 {
+		t.Error("expected the subscriptions to be closed")
+	}
+	hub.Publish(&Event{Type: EventNewBlock})
+}
+
+func TestSplitEvents(t *testing.T) {
+	mon := NewReorgMonitor(nil, make(chan *analysis.Reorg, 10), false, 100)
+	sub := mon.Events.Subscribe(EventFilter{Types: map[EventType]bool{EventSplitStarted: true}})
+	defer sub.Close()
+
+	p := newTestEthBlock(100, nil, 0)
+	a1 := newTestEthBlock(101, p, 1)
+	b1 := newTestEthBlock(101, p, 2)
+	c1 := newTestEthBlock(101, p, 3)
+	ctx := context.Background()
+	mon.AddBlock(ctx, analysis.NewBlock(p, analysis.OriginSubscription, "ws://a", 0))
+	mon.AddBlock(ctx, analysis.NewBlock(a1, analysis.OriginSubscription, "ws://a", 0))
+	if len(sub.C) != 0 {
+		t.Fatalf("expected no split for the first child, got %d", len(sub.C))
+	}
+
+	// Every further child starts a split
+	for i, block := range []*analysis.Block{
+		analysis.NewBlock(b1, analysis.OriginSubscription, "ws://a", 0),
+		analysis.NewBlock(c1, analysis.OriginSubscription, "ws://a", 0),
+	} {
+		mon.AddBlock(ctx, block)
+		if len(sub.C) != 1 {
+			t.Fatalf("expected a split for child %d, got %d", i+2, len(sub.C))
+		}
+		event := <-sub.C
+		if event.Split.Height != 101 || event.Split.CommonParentHash != p.Hash() || len(event.Split.BlockHashes) != i+2 {
+			t.Errorf("expected a split of %d blocks at 101, got %+v", i+2, event.Split)
+		}
+	}
+}
+
+// publishWhenSubscribed publishes the event once the handler has subscribed
+func publishWhenSubscribed(t *testing.T, ws *MonitorWebserver, event *Event) {
+	t.Helper()
+	waitFor(t, "subscription", func() bool { return ws.Monitor.Events.NumSubscribers() == 1 })
+	ws.Monitor.Events.Publish(&Event{Type: EventNewBlock, Time: time.Now().UTC()}) // filtered out
+	ws.Monitor.Events.Publish(event)
+}
+
+func TestHandleStreamRequest(t *testing.T) {
+	ws := NewMonitorWebserver(NewReorgMonitor(nil, make(chan *analysis.Reorg), false, 100), "")
+	server := httptest.NewServer(ws.server.Handler)
+	defer server.Close()
+
+	if res, err := http.Get(server.URL + "/v1/stream?types=unknown"); err != nil || res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %v (%v)", res, err)
+	}
+
+	res, err := http.Get(server.URL + "/v1/stream?types=node_connected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	publishWhenSubscribed(t, ws, &Event{Type: EventNodeConnected, Time: time.Now().UTC(), NodeUri: "ws://a"})
+	reader := bufio.NewReader(res.Body)
+	eventLine, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataLine, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eventLine != "event: node_connected\n" || !strings.HasPrefix(dataLine, "data: ") || !strings.Contains(dataLine, `"NodeUri":"ws://a"`) {
+		t.Errorf("unexpected event: %q %q", eventLine, dataLine)
+	}
+}
+
+func TestHandleWebsocketRequest(t *testing.T) {
+	ws := NewMonitorWebserver(NewReorgMonitor(nil, make(chan *analysis.Reorg), false, 100), "")
+	server := httptest.NewServer(ws.server.Handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/ws"
+
+	if _, res, err := websocket.DefaultDialer.Dial(url+"?min_depth=x", nil); err == nil || res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?types=reorg_finished&min_depth=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	waitFor(t, "subscription", func() bool { return ws.Monitor.Events.NumSubscribers() == 1 })
+	ws.Monitor.Events.Publish(&Event{Type: EventReorgFinished, Reorg: &apitypes.ReorgInfo{Id: "shallow", Depth: 1}})
+	publishWhenSubscribed(t, ws, &Event{Type: EventReorgFinished, Reorg: &apitypes.ReorgInfo{Id: "deep", Depth: 2}})
+
+	var event Event
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventReorgFinished || event.Reorg == nil || event.Reorg.Id != "deep" {
+		t.Errorf("expected the deep reorg, got %+v", event)
+	}
+
+	// On shutdown the server closes the connection
+	ws.Monitor.Events.Close()
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected a going away close, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const fetchBlockTimeout = 5 * time.Second
+
+// FetchStats counts which node served the blocks the monitor had to download
+type FetchStats struct {
+	NumServed         uint64 // blocks downloaded from this node
+	NumServedFallback uint64 // blocks downloaded from this node after the announcing node failed
+	NumFailed         uint64 // failed downloads
+}
+
+// FetchBlockByHash downloads a block, trying the preferred node first, and then all other connected nodes. Sidechain
+// blocks are often only retained by the node that saw them, so it also returns which node served the block.
+func (mon *ReorgMonitor) FetchBlockByHash(ctx context.Context, hash common.Hash, preferredNodeUri string) (block *types.Block, nodeUri string, err error) {
+	for _, conn := range mon.connectionsByPreference(preferredNodeUri) {
+		fetchCtx, cancel := context.WithTimeout(ctx, fetchBlockTimeout)
+		block, err = conn.BlockByHash(fetchCtx, hash)
+		cancel()
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+
+		if err != nil {
+			mon.countFetch(conn.Name(), false, false)
+			log.Printf("[conn %s] BlockByHash error for %s: %v\n", conn.Name(), hash, err)
+			continue
+		}
+
+		mon.countFetch(conn.Name(), true, preferredNodeUri != "" && conn.Name() != preferredNodeUri)
+		return block, conn.Name(), nil
+	}
+
+	if err == nil {
+		err = fmt.Errorf("no connected nodes")
+	}
+	return nil, "", err
+}
+
+// FetchBlockByNumber downloads the canonical block at a height from the first connected node which has it
+func (mon *ReorgMonitor) FetchBlockByNumber(ctx context.Context, number uint64) (block *types.Block, nodeUri string, err error) {
+	for _, conn := range mon.connectionsByPreference("") {
+		fetchCtx, cancel := context.WithTimeout(ctx, fetchBlockTimeout)
+		block, err = conn.BlockByNumber(fetchCtx, new(big.Int).SetUint64(number))
+		cancel()
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+
+		if err != nil {
+			mon.countFetch(conn.Name(), false, false)
+			log.Printf("[conn %s] BlockByNumber error for %d: %v\n", conn.Name(), number, err)
+			continue
+		}
+
+		mon.countFetch(conn.Name(), true, false)
+		return block, conn.Name(), nil
+	}
+
+	if err == nil {
+		err = fmt.Errorf("no connected nodes")
+	}
+	return nil, "", err
+}
+
+// connectionsByPreference returns the preferred connection (if known), followed by all other connected ones
+func (mon *ReorgMonitor) connectionsByPreference(preferredNodeUri string) []BlockSource {
+	mon.lock.RLock()
+	defer mon.lock.RUnlock()
+
+	ret := []BlockSource{}
+	if conn, found := mon.connections[preferredNodeUri]; found {
+		ret = append(ret, conn)
+	}
+
+	others := []BlockSource{}
+	for nodeUri, conn := range mon.connections {
+		if nodeUri != preferredNodeUri && conn.Health().IsConnected {
+			others = append(others, conn)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool { return others[i].Name() < others[j].Name() })
+
+	return append(ret, others...)
+}
+
+func (mon *ReorgMonitor) countFetch(nodeUri string, isServed, isFallback bool) {
+	mon.lock.Lock()
+	defer mon.lock.Unlock()
+
+	stats, found := mon.FetchStatsByNode[nodeUri]
+	if !found {
+		stats = &FetchStats{}
+		mon.FetchStatsByNode[nodeUri] = stats
+	}
+
+	if !isServed {
+		stats.NumFailed += 1
+		return
+	}
+	stats.NumServed += 1
+	if isFallback {
+		stats.NumServedFallback += 1
+	}
+}
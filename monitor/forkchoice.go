@@ -0,0 +1,88 @@
+package monitor
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+)
+
+const (
+	ForkChoiceLongest         = "longest"
+	ForkChoiceTotalDifficulty = "total-difficulty"
+	ForkChoiceCanonical       = "canonical"
+)
+
+// SetForkChoice selects how the main chain is chosen between competing blocks. For the canonical fork choice, nodeUri
+// is the node which is asked for its canonical block. Must be called before any blocks are added.
+func (mon *ReorgMonitor) SetForkChoice(name, nodeUri string) error {
+	var forkChoice analysis.ForkChoice
+	var canonical *analysis.CanonicalByNode
+	switch name {
+	case "", ForkChoiceLongest:
+		forkChoice = analysis.LongestChain{}
+	case ForkChoiceTotalDifficulty:
+		forkChoice = analysis.TotalDifficulty{}
+	case ForkChoiceCanonical:
+		if nodeUri == "" {
+			if len(mon.gethNodeUris) == 0 {
+				return fmt.Errorf("fork choice %s needs a node", name)
+			}
+			nodeUri = mon.gethNodeUris[0]
+		}
+		if !mon.hasNodeUri(nodeUri) {
+			return fmt.Errorf("fork choice node %s is not one of the monitored nodes", nodeUri)
+		}
+		canonical = analysis.NewCanonicalByNode(nodeUri)
+		forkChoice = canonical
+	default:
+		return fmt.Errorf("unknown fork choice: %s", name)
+	}
+
+	mon.lock.Lock()
+	defer mon.lock.Unlock()
+	if len(mon.Tree.NodeByHash) > 0 {
+		return fmt.Errorf("fork choice must be set before adding blocks")
+	}
+
+	mon.Tree = analysis.NewBlockTreeWithForkChoice(forkChoice)
+	mon.canonical = canonical
+	return nil
+}
+
+func (mon *ReorgMonitor) hasNodeUri(nodeUri string) bool {
+	if _, found := mon.connections[nodeUri]; found {
+		return true
+	}
+	for _, uri := range mon.gethNodeUris {
+		if uri == nodeUri {
+			return true
+		}
+	}
+	return false
+}
+
+// updateCanonical tells the canonical fork choice about a new head of its node, or a block it served by number, and
+// re-evaluates the head of the tree under the blocks whose canonical status changed. Nothing is requested from the
+// node, so the lock is held only briefly.
+func (mon *ReorgMonitor) updateCanonical(block *analysis.Block) {
+	if mon.canonical == nil || block.NodeUri != mon.canonical.NodeUri {
+		return
+	}
+
+	mon.lock.Lock()
+	defer mon.lock.Unlock()
+	node, found := mon.Tree.NodeByHash[block.Hash]
+	if !found {
+		return
+	}
+
+	var changed []common.Hash
+	switch block.Origin {
+	case analysis.OriginSubscription, analysis.OriginPoll:
+		changed = mon.canonical.SetHead(node)
+	case analysis.OriginBackfill, analysis.OriginGapFill:
+		changed = mon.canonical.SetCanonical(block.Number, block.Hash)
+	}
+	mon.Tree.UpdateHeadUnder(changed)
+}
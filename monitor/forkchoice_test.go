@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flashbots/reorg-monitor/analysis"
+)
+
+func TestSetForkChoice(t *testing.T) {
+	for name, nodeUris := range map[string][]string{"unknown": {"ws://a"}, "canonical": nil} {
+		mon := NewReorgMonitor(nodeUris, make(chan *analysis.Reorg), false, 100)
+		if err := mon.SetForkChoice(name, ""); err == nil {
+			t.Errorf("expected error for fork choice %s with nodes %v", name, nodeUris)
+		}
+	}
+
+	mon := NewReorgMonitor([]string{"ws://a"}, make(chan *analysis.Reorg), false, 100)
+	if err := mon.SetForkChoice(ForkChoiceCanonical, "ws://b"); err == nil {
+		t.Error("expected error for a node which isn't monitored")
+	}
+	if err := mon.SetForkChoice(ForkChoiceCanonical, ""); err != nil || mon.Tree.ForkChoice.Name() != "canonical:ws://a" {
+		t.Fatalf("expected canonical fork choice of the first node, got %s (%v)", mon.Tree.ForkChoice.Name(), err)
+	}
+
+	mon.AddBlock(context.Background(), analysis.NewBlock(newTestEthBlock(1, nil, 0), analysis.OriginSubscription, "ws://a", 0))
+	if err := mon.SetForkChoice(ForkChoiceTotalDifficulty, ""); err == nil {
+		t.Error("expected error when setting the fork choice after adding blocks")
+	}
+}
+
+func TestCanonicalForkChoiceFollowsNode(t *testing.T) {
+	mon := NewReorgMonitor([]string{"ws://a", "ws://b"}, make(chan *analysis.Reorg, 10), false, 100)
+	if err := mon.SetForkChoice(ForkChoiceCanonical, "ws://a"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestEthBlock(100, nil, 0)
+	a1 := newTestEthBlock(101, p, 1)
+	b1 := newTestEthBlock(101, p, 2)
+	b2 := newTestEthBlock(102, b1, 2)
+
+	ctx := context.Background()
+	mon.AddBlock(ctx, analysis.NewBlock(p, analysis.OriginSubscription, "ws://b", 0))
+	mon.AddBlock(ctx, analysis.NewBlock(a1, analysis.OriginSubscription, "ws://a", 0))
+	mon.AddBlock(ctx, analysis.NewBlock(b1, analysis.OriginSubscription, "ws://b", 0))
+	mon.AddBlock(ctx, analysis.NewBlock(b2, analysis.OriginSubscription, "ws://b", 0))
+
+	// The head of node a wins over the longer chain of node b
+	if heads := mon.Tree.HeadNodes; len(heads) != 1 || heads[0].Block.Hash != a1.Hash() {
+		t.Fatalf("expected head a1 of the canonical node, got %v", heads)
+	}
+
+	// Until node a switches to the other chain, with a block already known from node b
+	mon.AddBlock(ctx, analysis.NewBlock(b2, analysis.OriginSubscription, "ws://a", 0))
+	if heads := mon.Tree.HeadNodes; len(heads) != 1 || heads[0].Block.Hash != b2.Hash() || !mon.Tree.NodeByHash[b1.Hash()].IsMainChain {
+		t.Fatalf("expected head b2 after node a switched, got %v", heads)
+	}
+}
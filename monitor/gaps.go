@@ -0,0 +1,60 @@
+package monitor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/flashbots/reorg-monitor/analysis"
+)
+
+// Max. number of missing heights downloaded per analysis, so that a long outage of all nodes doesn't stall the monitor
+const maxGapRepairBlocks = 64
+
+// findGaps returns the ranges of heights between start and end (inclusive) without any known block
+func (mon *ReorgMonitor) findGaps(start, end uint64) []analysis.BlockRange {
+	mon.lock.RLock()
+	defer mon.lock.RUnlock()
+	return mon.findGapsLocked(start, end)
+}
+
+func (mon *ReorgMonitor) findGapsLocked(start, end uint64) (gaps []analysis.BlockRange) {
+	for height := start; height <= end && end >= start; height++ {
+		if len(mon.BlocksByHeight[height]) > 0 {
+			continue
+		}
+
+		if len(gaps) > 0 && gaps[len(gaps)-1].End == height-1 {
+			gaps[len(gaps)-1].End = height
+		} else {
+			gaps = append(gaps, analysis.BlockRange{Start: height, End: height})
+		}
+	}
+	return gaps
+}
+
+// repairGaps downloads the canonical blocks of missing heights from any connected node. Each gap is filled from the
+// top, because adding a block also downloads its missing parents.
+func (mon *ReorgMonitor) repairGaps(ctx context.Context, gaps []analysis.BlockRange) {
+	numFetched := 0
+	for _, gap := range gaps {
+		log.Printf("repairing gap at heights %s\n", gap)
+		for height := gap.End; height >= gap.Start && height > 0; height-- {
+			if numFetched >= maxGapRepairBlocks || ctx.Err() != nil {
+				return
+			}
+
+			if len(mon.findGaps(height, height)) == 0 { // filled as parent of a repaired block
+				continue
+			}
+
+			numFetched += 1
+			ethBlock, servedBy, err := mon.FetchBlockByNumber(ctx, height)
+			if err != nil {
+				log.Printf("error repairing gap at height %d: %v\n", height, err)
+				continue
+			}
+			mon.AddBlock(ctx, analysis.NewBlock(ethBlock, analysis.OriginGapFill, servedBy, time.Now().UTC().UnixNano()))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/mocknode"
+)
+
+func TestAnalysisRange(t *testing.T) {
+	mon := NewReorgMonitor(nil, make(chan *analysis.Reorg), false, 100)
+	mon.EarliestBlockNumber, mon.LatestBlockNumber = 1, 5
+
+	// Fewer blocks than maxBlocks start at the earliest block
+	if start, end := mon.analysisRange(10, 2); start != 1 || end != 3 {
+		t.Errorf("expected range 1 - 3, got %d - %d", start, end)
+	}
+
+	mon.EarliestBlockNumber, mon.LatestBlockNumber = 100, 200
+	if start, end := mon.analysisRange(10, 2); start != 188 || end != 198 {
+		t.Errorf("expected range 188 - 198, got %d - %d", start, end)
+	}
+	if start, end := mon.analysisRange(0, 0); start != 100 || end != 200 {
+		t.Errorf("expected range 100 - 200, got %d - %d", start, end)
+	}
+}
+
+// newGapTestMonitor adds the blocks 100 - 110 of the node, except 104 - 107, without downloading parents
+func newGapTestMonitor(t *testing.T, node *mocknode.Node) (*ReorgMonitor, []*types.Block) {
+	t.Helper()
+	chain := []*types.Block{newTestEthBlock(100, nil, 0)}
+	for i := 1; i <= 10; i++ {
+		chain = append(chain, newTestEthBlock(uint64(100+i), chain[i-1], 0))
+	}
+	node.AddBlocks(chain...)
+	node.Announce(chain[10])
+
+	mon := NewReorgMonitor([]string{node.WsURL()}, make(chan *analysis.Reorg, 10), false, 100)
+	for i, block := range chain {
+		if i < 4 || i > 7 {
+			mon.AddBlock(context.Background(), analysis.NewBlock(block, analysis.OriginSubscription, node.WsURL(), 0))
+		}
+	}
+	return mon, chain
+}
+
+func TestAnalyzeTreeRepairsGaps(t *testing.T) {
+	node := mocknode.NewNode()
+	defer node.Close()
+	mon, chain := newGapTestMonitor(t, node)
+	if gaps := mon.findGaps(100, 110); len(gaps) != 1 || gaps[0] != (analysis.BlockRange{Start: 104, End: 107}) {
+		t.Fatalf("expected gap 104 - 107, got %v", gaps)
+	}
+
+	if mon.ConnectClients() != 1 {
+		t.Fatal("expected to connect to the mock node")
+	}
+	treeAnalysis, err := mon.AnalyzeTree(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(treeAnalysis.BlindSpots) != 0 {
+		t.Errorf("expected no blind spots after the repair, got %v", treeAnalysis.BlindSpots)
+	}
+
+	// The gap is filled from the top, the lower heights are downloaded as parents
+	if block := mon.BlockByHash[chain[7].Hash()]; block == nil || block.Origin != analysis.OriginGapFill {
+		t.Errorf("expected block 107 to fill the gap, got %v", block)
+	}
+	for _, block := range chain[4:7] {
+		if mon.BlockByHash[block.Hash()] == nil {
+			t.Errorf("expected block %d to be downloaded", block.NumberU64())
+		}
+	}
+	if len(mon.Tree.Roots) != 1 {
+		t.Errorf("expected one connected tree, got %d roots", len(mon.Tree.Roots))
+	}
+}
+
+func TestAnalyzeTreeReportsBlindSpots(t *testing.T) {
+	node := mocknode.NewNode()
+	defer node.Close()
+	mon, _ := newGapTestMonitor(t, node)
+
+	// Without connected nodes, the gap can't be repaired
+	treeAnalysis, err := mon.AnalyzeTree(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(treeAnalysis.BlindSpots) != 1 || treeAnalysis.BlindSpots[0] != (analysis.BlockRange{Start: 104, End: 107}) {
+		t.Fatalf("expected blind spot 104 - 107, got %v", treeAnalysis.BlindSpots)
+	}
+	if !treeAnalysis.OverlapsBlindSpot(102, 104) || treeAnalysis.OverlapsBlindSpot(108, 110) {
+		t.Error("expected only ranges including 104 - 107 to overlap the blind spot")
+	}
+}
+
+func TestAnalyzeTreeRepairedGapIsLive(t *testing.T) {
+	node := mocknode.NewNode()
+	defer node.Close()
+	mon, chain := newGapTestMonitor(t, node)
+	if mon.ConnectClients() != 1 {
+		t.Fatal("expected to connect to the mock node")
+	}
+	if _, err := mon.AnalyzeTree(context.Background(), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A side chain forking off 103, which replaced the blocks of the repaired gap
+	side := []*types.Block{chain[3]}
+	for i := 1; i <= 4; i++ {
+		side = append(side, newTestEthBlock(uint64(103+i), side[i-1], 1))
+		mon.AddBlock(context.Background(), analysis.NewBlock(side[i], analysis.OriginSubscription, node.WsURL(), 0))
+	}
+
+	treeAnalysis, err := mon.AnalyzeTree(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(treeAnalysis.Reorgs) != 1 {
+		t.Fatalf("expected 1 reorg, got %d", len(treeAnalysis.Reorgs))
+	}
+	for _, reorg := range treeAnalysis.Reorgs {
+		if _, isInvolved := reorg.BlocksInvolved[chain[7].Hash()]; !isInvolved || !reorg.IsFinished {
+			t.Fatalf("expected a finished reorg involving the repaired block 107, got %s", reorg)
+		}
+		if !reorg.SeenLive {
+			t.Errorf("expected the reorg to be seen live, got %s", reorg)
+		}
+	}
+}
@@ -5,19 +5,29 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/big"
+	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/flashbots/reorg-monitor/analysis"
 	"github.com/pkg/errors"
 )
 
+// GethConnection is subscribed to by its own goroutine, while the monitor and webserver read its status and use its
+// client concurrently. All fields below lock are guarded by it (use Health() to read them).
 type GethConnection struct {
 	NodeUri      string
 	Client       *ethclient.Client
 	NewBlockChan chan<- *analysis.Block
 
+	retryTimeoutUnit time.Duration // unit of NextRetryTimeoutSec (shortened by tests)
+
+	lock sync.RWMutex
+
 	IsConnected         bool
 	IsSubscribed        bool
 	NextRetryTimeoutSec int64 // Wait time before retry. Starts at 5 seconds and doubles after each unsuccessful retry (max: 3 min).
@@ -25,12 +35,14 @@ type GethConnection struct {
 	NumResubscribes int64
 	NumReconnects   int64
 	NumBlocks       uint64
+	HeadBlockNumber uint64
 }
 
 func NewGethConnection(nodeUri string, newBlockChan chan<- *analysis.Block) (*GethConnection, error) {
 	conn := GethConnection{
 		NodeUri:             nodeUri,
 		NewBlockChan:        newBlockChan,
+		retryTimeoutUnit:    time.Second,
 		NextRetryTimeoutSec: 5,
 	}
 
@@ -38,14 +50,71 @@ func NewGethConnection(nodeUri string, newBlockChan chan<- *analysis.Block) (*Ge
 	return &conn, err
 }
 
+func (conn *GethConnection) Name() string {
+	return conn.NodeUri
+}
+
+func (conn *GethConnection) Health() BlockSourceHealth {
+	conn.lock.RLock()
+	defer conn.lock.RUnlock()
+	return BlockSourceHealth{
+		IsConnected:         conn.IsConnected,
+		IsSubscribed:        conn.IsSubscribed,
+		NumBlocks:           conn.NumBlocks,
+		HeadBlockNumber:     conn.HeadBlockNumber,
+		NumReconnects:       conn.NumReconnects,
+		NumResubscribes:     conn.NumResubscribes,
+		NextRetryTimeoutSec: conn.NextRetryTimeoutSec,
+	}
+}
+
+func (conn *GethConnection) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	client := conn.getClient()
+	if client == nil {
+		return nil, fmt.Errorf("not connected to %s", conn.NodeUri)
+	}
+	return client.BlockByHash(ctx, hash)
+}
+
+func (conn *GethConnection) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	client := conn.getClient()
+	if client == nil {
+		return nil, fmt.Errorf("not connected to %s", conn.NodeUri)
+	}
+	return client.BlockByNumber(ctx, number)
+}
+
+func (conn *GethConnection) getClient() *ethclient.Client {
+	conn.lock.RLock()
+	defer conn.lock.RUnlock()
+	return conn.Client
+}
+
+func (conn *GethConnection) setClient(client *ethclient.Client, isConnected bool) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	conn.Client = client
+	conn.IsConnected = isConnected
+}
+
+func (conn *GethConnection) setSubscribed(isSubscribed bool) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	conn.IsSubscribed = isSubscribed
+	if isSubscribed {
+		conn.NextRetryTimeoutSec = 5
+	}
+}
+
 func (conn *GethConnection) Connect() (err error) {
 	fmt.Printf("[%25s] Connecting to geth node... ", conn.NodeUri)
-	conn.Client, err = ethclient.Dial(conn.NodeUri)
+	client, err := ethclient.Dial(conn.NodeUri)
 	if err != nil {
 		return err
 	}
+	conn.setClient(client, false)
 
-	syncProgress, err := conn.Client.SyncProgress(context.Background())
+	syncProgress, err := client.SyncProgress(context.Background())
 	if err != nil {
 		return errors.Wrap(err, "error at SyncProgress")
 	}
@@ -55,40 +124,60 @@ func (conn *GethConnection) Connect() (err error) {
 	}
 
 	fmt.Printf("ok\n")
-	conn.IsConnected = true
+	conn.setClient(client, true)
 	return nil
 }
 
-func (conn *GethConnection) Subscribe() error {
-	if !conn.IsConnected {
-		conn.ResubscribeAfterTimeout()
-		return nil
+// Subscribe sends new blocks to NewBlockChan, and resubscribes with increasing timeouts on errors. It returns once
+// ctx is cancelled.
+func (conn *GethConnection) Subscribe(ctx context.Context) error {
+	for ctx.Err() == nil {
+		if conn.Health().IsConnected {
+			err := conn.readNewHeads(ctx)
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("[conn %s] Subscription error: %+v\n", conn.NodeUri, err)
+		}
+		conn.ResubscribeAfterTimeout(ctx)
 	}
 
+	if client := conn.getClient(); client != nil {
+		client.Close()
+	}
+	conn.setClient(nil, false)
+	return nil
+}
+
+// readNewHeads subscribes to new block headers, and sends the full blocks to NewBlockChan until the subscription fails
+func (conn *GethConnection) readNewHeads(ctx context.Context) error {
+	client := conn.getClient()
 	headers := make(chan *types.Header)
-	sub, err := conn.Client.SubscribeNewHead(context.Background(), headers)
+	sub, err := client.SubscribeNewHead(ctx, headers)
 	if err != nil {
-		fmt.Printf("[conn %s] SubscribeNewHead error: %+v\n", conn.NodeUri, err)
-		conn.IsSubscribed = false
-		return err
+		conn.setSubscribed(false)
+		return errors.Wrap(err, "SubscribeNewHead error")
 	}
+	defer sub.Unsubscribe()
 
-	conn.IsSubscribed = true
-	conn.NextRetryTimeoutSec = 5
+	conn.setSubscribed(true)
+	defer conn.setSubscribed(false)
 
 	for {
 		select {
+		case <-ctx.Done():
+			return ctx.Err()
 		case err := <-sub.Err():
-			fmt.Printf("[conn %s] Subscription error: %+v\n", conn.NodeUri, err)
-			conn.IsSubscribed = false
-			conn.ResubscribeAfterTimeout()
 			return err
 		case header := <-headers:
 			observed := time.Now().UTC().UnixNano()
+			conn.lock.Lock()
 			conn.NumBlocks += 1
+			conn.HeadBlockNumber = header.Number.Uint64()
+			conn.lock.Unlock()
 
 			// Fetch full block information from same client
-			ethBlock, err := conn.Client.BlockByHash(context.Background(), header.Hash())
+			ethBlock, err := client.BlockByHash(ctx, header.Hash())
 			if err != nil {
 				log.Printf("[conn %s] BlockByHash error: %+v (header %d %s)\n", conn.NodeUri, err, header.Number.Uint64(), header.Hash())
 				continue
@@ -96,72 +185,75 @@ func (conn *GethConnection) Subscribe() error {
 
 			// Add the block
 			newBlock := analysis.NewBlock(ethBlock, analysis.OriginSubscription, conn.NodeUri, observed)
-			conn.NewBlockChan <- newBlock
+			select {
+			case conn.NewBlockChan <- newBlock:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
 	}
 }
 
-func (conn *GethConnection) ResubscribeAfterTimeout() {
-	var err error
-
+// ResubscribeAfterTimeout waits for the retry timeout, and then reconnects if the node doesn't answer or is syncing.
+// The connection is marked as connected if the node is ready for a new subscription.
+func (conn *GethConnection) ResubscribeAfterTimeout(ctx context.Context) {
+	conn.lock.Lock()
 	conn.NumResubscribes += 1
-	log.Printf("[conn %s] resubscribing in %d seconds...\n", conn.NodeUri, conn.NextRetryTimeoutSec)
-	time.Sleep(time.Duration(conn.NextRetryTimeoutSec) * time.Second)
-	log.Printf("[conn %s] resubscribing...\n", conn.NodeUri)
+	timeoutSec := conn.NextRetryTimeoutSec
 
 	// Now double time until next retry (max 3 min)
 	conn.NextRetryTimeoutSec *= 2
 	if conn.NextRetryTimeoutSec > 60*3 {
 		conn.NextRetryTimeoutSec = 60 * 3
 	}
+	conn.lock.Unlock()
 
-	// step 1: get sync status
-	if conn.Client == nil || !conn.IsConnected {
-		conn.NumReconnects += 1
-		conn.Client, err = ethclient.Dial(conn.NodeUri)
-		if err != nil {
-			log.Printf("[conn %s] err at ResubscribeAfterTimeout syncProgressCheck->reconnect: %v\n", conn.NodeUri, err)
-			conn.IsConnected = false
-			conn.ResubscribeAfterTimeout()
-			return
-		}
-		conn.IsConnected = true
+	log.Printf("[conn %s] resubscribing in %d seconds...\n", conn.NodeUri, timeoutSec)
+	select {
+	case <-time.After(time.Duration(timeoutSec) * conn.retryTimeoutUnit):
+	case <-ctx.Done():
+		return
 	}
+	log.Printf("[conn %s] resubscribing...\n", conn.NodeUri)
 
-	syncProgress, err := conn.Client.SyncProgress(context.Background())
-	if err != nil {
-		log.Printf("[conn %s] err at ResubscribeAfterTimeout syncProgressCheck: %v\n", conn.NodeUri, err)
+	// step 1: get sync status, reconnecting if there is no client or it doesn't answer
+	client := conn.getClient()
+	var syncProgress *ethereum.SyncProgress
+	var err error
+	if client != nil {
+		syncProgress, err = client.SyncProgress(ctx)
+	}
+
+	if client == nil || err != nil {
+		if err != nil {
+			log.Printf("[conn %s] err at ResubscribeAfterTimeout syncProgressCheck: %v\n", conn.NodeUri, err)
+		}
 
-		// Reconnect
+		conn.lock.Lock()
 		conn.NumReconnects += 1
-		conn.Client, err = ethclient.Dial(conn.NodeUri)
+		conn.lock.Unlock()
+
+		client, err = ethclient.DialContext(ctx, conn.NodeUri)
 		if err != nil {
 			log.Printf("[conn %s] err at ResubscribeAfterTimeout syncProgressCheck->reconnect: %v\n", conn.NodeUri, err)
-			conn.IsConnected = false
-			conn.ResubscribeAfterTimeout()
+			conn.setClient(nil, false)
 			return
 		}
+		conn.setClient(client, false)
 
-		conn.IsConnected = true
-		syncProgress, err = conn.Client.SyncProgress(context.Background())
+		syncProgress, err = client.SyncProgress(ctx)
 		if err != nil {
 			log.Printf("[conn %s] err at ResubscribeAfterTimeout syncProgressCheck: %v\n", conn.NodeUri, err)
-			conn.ResubscribeAfterTimeout()
 			return
 		}
 	}
 
 	if syncProgress != nil {
 		log.Printf("[conn %s] err at ResubscribeAfterTimeout syncProgressCheck: sync in progress\n", conn.NodeUri)
-		conn.ResubscribeAfterTimeout()
+		conn.setClient(client, false)
 		return
 	}
 
-	// step 2: subscribe
-	err = conn.Subscribe()
-	if err != nil {
-		log.Printf("[conn %s] err at ResubscribeAfterTimeout Subscribe: %v\n", conn.NodeUri, err)
-		conn.ResubscribeAfterTimeout()
-		return
-	}
+	// step 2: subscribe (in the Subscribe loop)
+	conn.setClient(client, true)
 }
@@ -0,0 +1,195 @@
+package monitor
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/mocknode"
+)
+
+func newTestEthBlock(number uint64, parent *types.Block, variant byte, txs ...*types.Transaction) *types.Block {
+	header := &types.Header{
+		Number:     new(big.Int).SetUint64(number),
+		Coinbase:   common.Address{variant},
+		Difficulty: big.NewInt(1),
+		GasLimit:   30_000_000,
+		Time:       number * 12,
+	}
+	if parent != nil {
+		header.ParentHash = parent.Hash()
+	}
+
+	// The clients only check whether the transaction root is empty, so any other root works for blocks with txs
+	header.TxHash, header.UncleHash = types.EmptyTxsHash, types.EmptyUncleHash
+	if len(txs) > 0 {
+		txHashes := []byte{}
+		for _, tx := range txs {
+			txHashes = append(txHashes, tx.Hash().Bytes()...)
+		}
+		header.TxHash = crypto.Keccak256Hash(txHashes)
+	}
+	return types.NewBlockWithHeader(header).WithBody(txs, nil)
+}
+
+// waitFor fails the test if cond doesn't become true within a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+	}
+}
+
+// runTestReorgOnMockNode runs a monitor on a mock node, which announces a reorg at height 101: 101a (with a tx) is
+// replaced by 101b (with the same tx), which is only served as the parent of 102. It returns the reorg and 101b.
+func runTestReorgOnMockNode(t *testing.T, recorder *Recorder) (*analysis.Reorg, *types.Block) {
+	t.Helper()
+	node := mocknode.NewNode()
+	defer node.Close()
+
+	tx := types.NewTx(&types.LegacyTx{Nonce: 1, Gas: 21000, GasPrice: big.NewInt(1)})
+	b100 := newTestEthBlock(100, nil, 0)
+	b101a := newTestEthBlock(101, b100, 1, tx)
+	b101b := newTestEthBlock(101, b100, 2, tx)
+	b102 := newTestEthBlock(102, b101b, 2)
+	b103 := newTestEthBlock(103, b102, 2)
+	b104 := newTestEthBlock(104, b103, 2)
+	b105 := newTestEthBlock(105, b104, 2)
+
+	reorgChan := make(chan *analysis.Reorg, 10)
+	mon := NewReorgMonitor([]string{node.WsURL()}, reorgChan, false, 100)
+	if recorder != nil {
+		if err := mon.SetRecorder(recorder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mon.ConnectClients() != 1 {
+		t.Fatal("expected to connect to the mock node")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		mon.SubscribeAndListen(ctx)
+	}()
+	defer wg.Wait()
+	defer cancel()
+
+	waitFor(t, "subscription", func() bool { return node.NumSubscriptions() == 1 })
+	delay := 10 * time.Millisecond
+	err := node.Play(ctx, []mocknode.Step{
+		{Announce: []*types.Block{b100, b101a}, Add: []*types.Block{b101b}, Wait: delay},
+		{Delay: &delay, Announce: []*types.Block{b102, b103, b104, b105}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case reorg := <-reorgChan:
+		return reorg, b101b
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for reorg")
+		return nil, nil
+	}
+}
+
+func TestGethConnectionReorgEndToEnd(t *testing.T) {
+	reorg, b101b := runTestReorgOnMockNode(t, nil)
+	if reorg.StartBlockHeight != 101 || reorg.EndBlockHeight != 101 || reorg.Depth != 1 || reorg.NumReplacedBlocks != 1 {
+		t.Errorf("unexpected reorg: %s", reorg)
+	}
+	if !reorg.SeenLive {
+		t.Errorf("expected reorg to be seen live: %s", reorg)
+	}
+	if _, found := reorg.BlocksInvolved[b101b.Hash()]; !found || reorg.BlocksInvolved[b101b.Hash()].Origin != analysis.OriginGetParent {
+		t.Errorf("expected 101b to be downloaded as parent: %s", reorg)
+	}
+	if reorg.TxDiff == nil || reorg.TxDiff.NumReincluded != 1 {
+		t.Errorf("expected the tx of 101a to be re-included: %+v", reorg.TxDiff)
+	}
+}
+
+func TestGethConnectionResubscribeAfterTimeout(t *testing.T) {
+	node := mocknode.NewNode()
+	defer node.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	yes, no := true, false
+	play := func(steps ...mocknode.Step) {
+		t.Helper()
+		if err := node.Play(ctx, steps); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A syncing node is not connected to
+	play(mocknode.Step{Syncing: &yes})
+	blockChan := make(chan *analysis.Block, 10)
+	conn, err := NewGethConnection(node.WsURL(), blockChan)
+	if err == nil || conn.Health().IsConnected {
+		t.Fatal("expected connection to a syncing node to fail")
+	}
+	conn.retryTimeoutUnit = time.Millisecond
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn.Subscribe(ctx)
+	}()
+
+	receive := func(expected *types.Block) {
+		t.Helper()
+		select {
+		case block := <-blockChan:
+			if block.Hash != expected.Hash() || block.NodeUri != node.WsURL() || block.Origin != analysis.OriginSubscription {
+				t.Errorf("expected block %d %s, got %s", expected.NumberU64(), expected.Hash(), block)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for block %d", expected.NumberU64())
+		}
+	}
+
+	// Subscribes once the sync is done
+	play(mocknode.Step{Wait: 50 * time.Millisecond})
+	if conn.Health().IsSubscribed {
+		t.Fatal("expected no subscription while syncing")
+	}
+	play(mocknode.Step{Syncing: &no})
+	waitFor(t, "subscription after sync", func() bool { return node.NumSubscriptions() == 1 })
+	b1 := newTestEthBlock(1, nil, 0)
+	play(mocknode.Step{Announce: []*types.Block{b1}})
+	receive(b1)
+
+	// Reconnects after a disconnect and downtime
+	play(mocknode.Step{Down: &yes, Disconnect: true})
+	waitFor(t, "failed reconnects", func() bool { return conn.Health().NumReconnects >= 2 })
+	if health := conn.Health(); health.IsConnected || health.IsSubscribed {
+		t.Fatalf("expected to be disconnected while the node is down: %+v", health)
+	}
+
+	play(mocknode.Step{Down: &no})
+	waitFor(t, "resubscription", func() bool { return node.NumSubscriptions() == 1 })
+	b2 := newTestEthBlock(2, b1, 0)
+	play(mocknode.Step{Announce: []*types.Block{b2}})
+	receive(b2)
+
+	health := conn.Health()
+	if !health.IsConnected || !health.IsSubscribed || health.NextRetryTimeoutSec != 5 || health.NumBlocks != 2 || health.HeadBlockNumber != 2 {
+		t.Errorf("unexpected health after resubscribing: %+v", health)
+	}
+}
@@ -0,0 +1,167 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const metricsNamespace = "reorgmonitor"
+
+// Metrics are served by the webserver at /metrics. Reorgs and fetches are counted as they happen, everything else is
+// read from a monitor snapshot on every scrape.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	reorgDepths          *prometheus.HistogramVec
+	replacedBlocks       *prometheus.CounterVec
+	ensureBlockDurations *prometheus.HistogramVec
+}
+
+func NewMetrics(mon *ReorgMonitor) *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+
+		// A histogram instead of a depth label, so that an unusually deep reorg doesn't add a new time series
+		reorgDepths: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "reorg_depth_blocks",
+			Help:      "Depth of finished reorgs, by whether they were seen live or only found through uncles.",
+			Buckets:   []float64{1, 2, 3, 4, 5, 7, 10, 15, 20, 30, 50},
+		}, []string{"source"}),
+
+		replacedBlocks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "replaced_blocks_total",
+			Help:      "Blocks which were replaced by finished reorgs.",
+		}, []string{"source"}),
+
+		ensureBlockDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "ensure_block_fetch_duration_seconds",
+			Help:      "Time to download a missing block (parent or uncle), including the fallback to other nodes.",
+			Buckets:   []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}, []string{"result"}),
+	}
+
+	m.Registry.MustRegister(
+		m.reorgDepths,
+		m.replacedBlocks,
+		m.ensureBlockDurations,
+		&snapshotCollector{mon: mon},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// reorgSource is the source label of the reorg metrics
+func reorgSource(reorg *analysis.Reorg) string {
+	if reorg.SeenLive {
+		return "live"
+	}
+	return "uncle"
+}
+
+// ObserveReorg counts a finished reorg
+func (m *Metrics) ObserveReorg(reorg *analysis.Reorg) {
+	source := reorgSource(reorg)
+	m.reorgDepths.WithLabelValues(source).Observe(float64(reorg.Depth))
+	m.replacedBlocks.WithLabelValues(source).Add(float64(reorg.NumReplacedBlocks))
+}
+
+// ObserveEnsureBlockFetch records the duration of a block download by EnsureBlock
+func (m *Metrics) ObserveEnsureBlockFetch(duration time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.ensureBlockDurations.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+var (
+	cacheBlocksDesc = prometheus.NewDesc(metricsNamespace+"_cache_blocks",
+		"Blocks in the monitor cache.", nil, nil)
+	cacheMaxBlocksDesc = prometheus.NewDesc(metricsNamespace+"_cache_max_blocks",
+		"Maximum number of blocks in the monitor cache.", nil, nil)
+	latestBlockNumberDesc = prometheus.NewDesc(metricsNamespace+"_latest_block_number",
+		"Best known block height.", nil, nil)
+
+	nodeConnectedDesc = prometheus.NewDesc(metricsNamespace+"_node_connected",
+		"1 if the node is connected.", []string{"node"}, nil)
+	nodeSubscribedDesc = prometheus.NewDesc(metricsNamespace+"_node_subscribed",
+		"1 if the node is subscribed to new blocks (or polling succeeds).", []string{"node"}, nil)
+	nodeBlocksDesc = prometheus.NewDesc(metricsNamespace+"_node_blocks_received_total",
+		"New blocks received from the node.", []string{"node"}, nil)
+	nodeReconnectsDesc = prometheus.NewDesc(metricsNamespace+"_node_reconnects_total",
+		"Reconnects to the node.", []string{"node"}, nil)
+	nodeResubscribesDesc = prometheus.NewDesc(metricsNamespace+"_node_resubscribes_total",
+		"Resubscribes to the node.", []string{"node"}, nil)
+	nodePollErrorsDesc = prometheus.NewDesc(metricsNamespace+"_node_poll_errors_total",
+		"Failed polls of the node (polled nodes only).", []string{"node"}, nil)
+	nodeHeadLagDesc = prometheus.NewDesc(metricsNamespace+"_node_head_lag_blocks",
+		"Blocks the latest head of the node is behind the best known height.", []string{"node"}, nil)
+	nodeFetchesDesc = prometheus.NewDesc(metricsNamespace+"_node_fetched_blocks_total",
+		"Missing blocks requested from the node, by result (served, fallback: served after the announcing node failed, failed).", []string{"node", "result"}, nil)
+)
+
+// snapshotCollector reads the cache and connection metrics from a monitor snapshot
+type snapshotCollector struct {
+	mon *ReorgMonitor
+}
+
+func (c *snapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheBlocksDesc
+	ch <- cacheMaxBlocksDesc
+	ch <- latestBlockNumberDesc
+	ch <- nodeConnectedDesc
+	ch <- nodeSubscribedDesc
+	ch <- nodeBlocksDesc
+	ch <- nodeReconnectsDesc
+	ch <- nodeResubscribesDesc
+	ch <- nodePollErrorsDesc
+	ch <- nodeHeadLagDesc
+	ch <- nodeFetchesDesc
+}
+
+func (c *snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	snapshot := c.mon.Snapshot()
+	ch <- prometheus.MustNewConstMetric(cacheBlocksDesc, prometheus.GaugeValue, float64(snapshot.NumBlocks))
+	ch <- prometheus.MustNewConstMetric(cacheMaxBlocksDesc, prometheus.GaugeValue, float64(snapshot.MaxBlocksInCache))
+
+	// Nodes can be ahead of the monitor while their blocks are still being processed
+	bestHeight := snapshot.LatestBlockNumber
+	for _, conn := range snapshot.Connections {
+		if conn.Health.HeadBlockNumber > bestHeight {
+			bestHeight = conn.Health.HeadBlockNumber
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(latestBlockNumberDesc, prometheus.GaugeValue, float64(bestHeight))
+
+	for _, conn := range snapshot.Connections {
+		health := conn.Health
+		ch <- prometheus.MustNewConstMetric(nodeConnectedDesc, prometheus.GaugeValue, boolToFloat(health.IsConnected), conn.NodeUri)
+		ch <- prometheus.MustNewConstMetric(nodeSubscribedDesc, prometheus.GaugeValue, boolToFloat(health.IsSubscribed), conn.NodeUri)
+		ch <- prometheus.MustNewConstMetric(nodeBlocksDesc, prometheus.CounterValue, float64(health.NumBlocks), conn.NodeUri)
+		ch <- prometheus.MustNewConstMetric(nodeReconnectsDesc, prometheus.CounterValue, float64(health.NumReconnects), conn.NodeUri)
+		ch <- prometheus.MustNewConstMetric(nodeResubscribesDesc, prometheus.CounterValue, float64(health.NumResubscribes), conn.NodeUri)
+		ch <- prometheus.MustNewConstMetric(nodePollErrorsDesc, prometheus.CounterValue, float64(health.NumPollErrors), conn.NodeUri)
+		ch <- prometheus.MustNewConstMetric(nodeFetchesDesc, prometheus.CounterValue, float64(conn.Fetch.NumServed-conn.Fetch.NumServedFallback), conn.NodeUri, "served")
+		ch <- prometheus.MustNewConstMetric(nodeFetchesDesc, prometheus.CounterValue, float64(conn.Fetch.NumServedFallback), conn.NodeUri, "fallback")
+		ch <- prometheus.MustNewConstMetric(nodeFetchesDesc, prometheus.CounterValue, float64(conn.Fetch.NumFailed), conn.NodeUri, "failed")
+
+		// Nodes without a head yet have no lag
+		if health.HeadBlockNumber > 0 {
+			ch <- prometheus.MustNewConstMetric(nodeHeadLagDesc, prometheus.GaugeValue, float64(bestHeight-health.HeadBlockNumber), conn.NodeUri)
+		}
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/mocknode"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gatherMetrics returns the metrics of a family by their label values
+func gatherMetrics(t *testing.T, m *Metrics, name string) map[string]*dto.Metric {
+	t.Helper()
+	families, err := m.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := make(map[string]*dto.Metric)
+	for _, family := range families {
+		if family.GetName() != metricsNamespace+"_"+name {
+			continue
+		}
+		for _, metric := range family.Metric {
+			key := ""
+			for _, label := range metric.Label {
+				key += label.GetValue() + ","
+			}
+			metrics[key] = metric
+		}
+	}
+	return metrics
+}
+
+func TestObserveReorg(t *testing.T) {
+	m := NewMetrics(NewReorgMonitor(nil, make(chan *analysis.Reorg), false, 100))
+	m.ObserveReorg(&analysis.Reorg{Depth: 1, SeenLive: true, NumReplacedBlocks: 1})
+	m.ObserveReorg(&analysis.Reorg{Depth: 400, SeenLive: true, NumReplacedBlocks: 400})
+	m.ObserveReorg(&analysis.Reorg{Depth: 2, NumReplacedBlocks: 1})
+
+	// Deep reorgs don't add time series
+	depths := gatherMetrics(t, m, "reorg_depth_blocks")
+	if len(depths) != 2 {
+		t.Fatalf("expected depths for live and uncle reorgs, got %v", depths)
+	}
+	live := depths["live,"].Histogram
+	if live.GetSampleCount() != 2 || live.GetSampleSum() != 401 || live.Bucket[0].GetCumulativeCount() != 1 {
+		t.Errorf("expected 2 live reorgs of depth 1 and 400, got %v", live)
+	}
+	if uncle := depths["uncle,"].Histogram; uncle.GetSampleCount() != 1 || uncle.GetSampleSum() != 2 {
+		t.Errorf("expected an uncle reorg of depth 2, got %v", uncle)
+	}
+
+	replaced := gatherMetrics(t, m, "replaced_blocks_total")
+	if replaced["live,"].Counter.GetValue() != 401 || replaced["uncle,"].Counter.GetValue() != 1 {
+		t.Errorf("expected 401 live and 1 uncle replaced blocks, got %v", replaced)
+	}
+}
+
+func TestSnapshotMetrics(t *testing.T) {
+	blocks := []*types.Block{newTestEthBlock(100, nil, 0)}
+	for i := 1; i <= 5; i++ {
+		blocks = append(blocks, newTestEthBlock(uint64(100+i), blocks[i-1], 0))
+	}
+
+	mon := NewReorgMonitor(nil, make(chan *analysis.Reorg), false, 100)
+	for _, block := range blocks[:4] {
+		mon.AddBlock(context.Background(), analysis.NewBlock(block, analysis.OriginSubscription, "ws://a", 0))
+	}
+
+	// One node is ahead of the monitor, the other one behind
+	nodeUris := make(map[uint64]string)
+	for _, head := range []*types.Block{blocks[5], blocks[2]} {
+		node := mocknode.NewNode()
+		defer node.Close()
+		node.Announce(head)
+
+		conn, err := NewPollingConnection(node.HttpURL(), make(chan *analysis.Block, 10), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.Poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		mon.AddBlockSource(conn)
+		nodeUris[head.NumberU64()] = conn.Name()
+	}
+
+	if latest := gatherMetrics(t, mon.Metrics, "latest_block_number"); latest[""].Gauge.GetValue() != 105 {
+		t.Errorf("expected the best known height 105, got %v", latest)
+	}
+	if cacheBlocks := gatherMetrics(t, mon.Metrics, "cache_blocks"); cacheBlocks[""].Gauge.GetValue() != 4 {
+		t.Errorf("expected 4 blocks in the cache, got %v", cacheBlocks)
+	}
+
+	lags := gatherMetrics(t, mon.Metrics, "node_head_lag_blocks")
+	if lags[nodeUris[105]+","].Gauge.GetValue() != 0 || lags[nodeUris[102]+","].Gauge.GetValue() != 3 {
+		t.Errorf("expected lags of 0 and 3 blocks, got %v", lags)
+	}
+	if received := gatherMetrics(t, mon.Metrics, "node_blocks_received_total"); received[nodeUris[102]+","].Counter.GetValue() != 1 {
+		t.Errorf("expected one received block, got %v", received)
+	}
+}
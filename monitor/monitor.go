@@ -4,118 +4,350 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/apitypes"
 	"github.com/pkg/errors"
 )
 
+// ReorgMonitor processes all blocks in the SubscribeAndListen goroutine, which is the only writer of its state. The
+// block caches, the tree, the connection maps and the fetch stats are only changed while holding lock, so other
+// goroutines can read them with lock held for reading, preferably through Snapshot().
 type ReorgMonitor struct {
 	maxBlocksInCache int
 
+	lock sync.RWMutex
+
 	gethNodeUris []string
-	connections  map[string]*GethConnection
+	connections  map[string]BlockSource
 	verbose      bool
 
-	NewBlockChan chan *analysis.Block
-	NewReorgChan chan<- *analysis.Reorg
+	PollInterval     time.Duration // for nodes which are polled instead of subscribed to (HTTP endpoints)
+	FetchStatsByNode map[string]*FetchStats
+
+	NewBlockChan    chan *analysis.Block
+	NewReorgChan    chan<- *analysis.Reorg
+	ShutdownTimeout time.Duration // how long a reorg finished during shutdown waits to be received (zero: not at all)
+
+	beaconConnections  map[string]*BeaconConnection
+	beaconReorgChan    chan *analysis.BeaconReorg // reorgs reported by the beacon connections
+	NewBeaconBlockChan chan *analysis.BeaconBlock
+	NewBeaconReorgChan chan<- *analysis.BeaconReorg
+	NewDiscrepancyChan chan<- *analysis.ReorgDiscrepancy
+
+	pendingReorgs       map[string]*pendingReorg // finished reorgs waiting for a matching beacon reorg
+	pendingBeaconReorgs map[string]*pendingBeaconReorg
+
+	BeaconBlockByRoot          map[common.Hash]*analysis.BeaconBlock
+	BeaconBlockByExecutionHash map[common.Hash]*analysis.BeaconBlock
 
 	BlockByHash    map[common.Hash]*analysis.Block
 	BlocksByHeight map[uint64]map[common.Hash]*analysis.Block
+	Tree           *analysis.BlockTree // kept in sync with BlockByHash, updated incrementally on every added block
 
 	EarliestBlockNumber uint64
 	LatestBlockNumber   uint64
+	lastAnalyzedHeight  uint64
 
 	KnownReorgs map[string]uint64 // key: reorgId, value: endBlockNumber
+	History     *ReorgHistory     // recent reorgs for the API (has its own lock)
+	Events      *EventHub         // live events for the API (has its own lock)
+	Metrics     *Metrics
+
+	isSubscribedByNode map[string]bool // last known subscription status, only used by the monitor goroutine
+
+	recorder  *Recorder                 // records processed and downloaded blocks, if set
+	canonical *analysis.CanonicalByNode // fork choice which needs to know the heads of its node, if set
 }
 
 func NewReorgMonitor(gethNodeUris []string, reorgChan chan<- *analysis.Reorg, verbose bool, maxBlocks int) *ReorgMonitor {
-	return &ReorgMonitor{
+	mon := &ReorgMonitor{
 		verbose:          verbose,
 		maxBlocksInCache: maxBlocks,
 
 		gethNodeUris: gethNodeUris,
-		connections:  make(map[string]*GethConnection),
+		connections:  make(map[string]BlockSource),
+		PollInterval: DefaultPollInterval,
+
+		FetchStatsByNode: make(map[string]*FetchStats),
 
 		NewBlockChan: make(chan *analysis.Block, 100),
 		NewReorgChan: reorgChan,
 
+		beaconConnections:  make(map[string]*BeaconConnection),
+		beaconReorgChan:    make(chan *analysis.BeaconReorg, 100),
+		NewBeaconBlockChan: make(chan *analysis.BeaconBlock, 100),
+
+		pendingReorgs:       make(map[string]*pendingReorg),
+		pendingBeaconReorgs: make(map[string]*pendingBeaconReorg),
+
+		BeaconBlockByRoot:          make(map[common.Hash]*analysis.BeaconBlock),
+		BeaconBlockByExecutionHash: make(map[common.Hash]*analysis.BeaconBlock),
+
 		BlockByHash:    make(map[common.Hash]*analysis.Block),
 		BlocksByHeight: make(map[uint64]map[common.Hash]*analysis.Block),
+		Tree:           analysis.NewBlockTree(),
 		KnownReorgs:    make(map[string]uint64),
+		History:        NewReorgHistory(DefaultReorgHistorySize),
+		Events:         NewEventHub(),
+
+		isSubscribedByNode: make(map[string]bool),
 	}
+	mon.Metrics = NewMetrics(mon)
+	return mon
 }
 
 func (mon *ReorgMonitor) String() string {
+	mon.lock.RLock()
+	defer mon.lock.RUnlock()
+	return mon.string()
+}
+
+func (mon *ReorgMonitor) string() string {
 	return fmt.Sprintf("ReorgMonitor: %d - %d, %d / %d blocks, %d reorgcache", mon.EarliestBlockNumber, mon.LatestBlockNumber, len(mon.BlockByHash), len(mon.BlocksByHeight), len(mon.KnownReorgs))
 }
 
 func (mon *ReorgMonitor) ConnectClients() (connectedClients int) {
 	for _, nodeUri := range mon.gethNodeUris {
-		gethConn, err := NewGethConnection(nodeUri, mon.NewBlockChan)
+		source, err := NewBlockSource(nodeUri, mon.NewBlockChan, mon.PollInterval)
 		if err != nil { // in case of an error, just print it but still continue to add it
 			fmt.Println(err)
 		} else {
 			connectedClients += 1
 		}
-		mon.connections[nodeUri] = gethConn
+		mon.AddBlockSource(source)
 	}
 
 	return connectedClients
 }
 
-// SubscribeAndListen is the main monitor loop: subscribes to new blocks from all geth connections, and waits for new blocks to process.
+// AddBlockSource adds a source of blocks to the monitor. It needs to send new blocks to mon.NewBlockChan.
+func (mon *ReorgMonitor) AddBlockSource(source BlockSource) {
+	mon.lock.Lock()
+	defer mon.lock.Unlock()
+	if mon.recorder != nil {
+		source = &recordingSource{BlockSource: source, recorder: mon.recorder}
+	}
+	mon.connections[source.Name()] = source
+}
+
+// SetRecorder records all blocks the monitor processes or backfills, and all blocks served by its block sources. It
+// returns an error if block sources were already added, because their blocks wouldn't be recorded.
+func (mon *ReorgMonitor) SetRecorder(recorder *Recorder) error {
+	mon.lock.Lock()
+	defer mon.lock.Unlock()
+	if len(mon.connections) > 0 {
+		return fmt.Errorf("recorder must be set before adding block sources (%d added)", len(mon.connections))
+	}
+	mon.recorder = recorder
+	return nil
+}
+
+// recordBlock records a block if a recorder is set
+func (mon *ReorgMonitor) recordBlock(recordType RecordType, block *analysis.Block) {
+	if mon.recorder == nil {
+		return
+	}
+	if err := mon.recorder.RecordBlock(recordType, block); err != nil {
+		log.Println("error recording block:", err)
+	}
+}
+
+// ConnectBeaconClients connects to beacon nodes. Their blocks are fed into the same block tree (via the execution
+// layer block of each slot), and their chain_reorg events are sent to beaconReorgChan. Reorgs seen on only one of
+// the layers are sent to discrepancyChan.
+func (mon *ReorgMonitor) ConnectBeaconClients(beaconNodeUris []string, beaconReorgChan chan<- *analysis.BeaconReorg, discrepancyChan chan<- *analysis.ReorgDiscrepancy) (connectedClients int) {
+	mon.NewBeaconReorgChan = beaconReorgChan
+	mon.NewDiscrepancyChan = discrepancyChan
+	for _, nodeUri := range beaconNodeUris {
+		beaconConn, err := NewBeaconConnection(nodeUri, mon.NewBeaconBlockChan, mon.beaconReorgChan)
+		if err != nil { // in case of an error, just print it but still continue to add it
+			fmt.Println(err)
+		} else {
+			connectedClients += 1
+		}
+		mon.lock.Lock()
+		mon.beaconConnections[beaconConn.NodeUri] = beaconConn
+		mon.lock.Unlock()
+	}
+
+	return connectedClients
+}
+
+// SubscribeAndListen is the main monitor loop: subscribes to new blocks from all block sources, and waits for new blocks to process.
 // After adding a new block, a reorg check takes place. If a new completed reorg is detected, it is sent to the channel.
-func (mon *ReorgMonitor) SubscribeAndListen() {
+// Cancelling ctx stops the subscriptions, and SubscribeAndListen returns once they have all ended.
+func (mon *ReorgMonitor) SubscribeAndListen(ctx context.Context) {
+	var subscriptions sync.WaitGroup
+	defer subscriptions.Wait()
+
 	// Subscribe to new blocks from all clients
+	mon.lock.RLock()
 	for _, conn := range mon.connections {
-		go conn.Subscribe()
+		subscriptions.Add(1)
+		go func(conn BlockSource) {
+			defer subscriptions.Done()
+			conn.Subscribe(ctx)
+		}(conn)
+	}
+	for _, conn := range mon.beaconConnections {
+		subscriptions.Add(1)
+		go func(conn *BeaconConnection) {
+			defer subscriptions.Done()
+			conn.Subscribe(ctx)
+		}(conn)
 	}
+	mon.lock.RUnlock()
+
+	connectionCheckTicker := time.NewTicker(connectionCheckInterval)
+	defer connectionCheckTicker.Stop()
+	correlationCheckTicker := time.NewTicker(correlationCheckInterval)
+	defer correlationCheckTicker.Stop()
 
 	// Wait for new blocks and process them (blocking)
-	lastBlockHeight := uint64(0)
-	for block := range mon.NewBlockChan {
-		mon.AddBlock(block)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("monitor stopped:", ctx.Err())
+			return
+		case <-connectionCheckTicker.C:
+			mon.checkConnections()
+		case now := <-correlationCheckTicker.C:
+			mon.expireCorrelations(ctx, now)
+		case block := <-mon.NewBlockChan:
+			mon.processBlock(ctx, block)
+		case beaconBlock := <-mon.NewBeaconBlockChan:
+			mon.processBeaconBlock(ctx, beaconBlock)
+		case beaconReorg := <-mon.beaconReorgChan:
+			log.Println(beaconReorg.String())
+			mon.correlateBeaconReorg(beaconReorg)
+			if mon.NewBeaconReorgChan != nil {
+				select {
+				case mon.NewBeaconReorgChan <- beaconReorg:
+				case <-ctx.Done():
+				}
+			}
+		}
+	}
+}
+
+// processBlock adds a block, and runs a reorg check once a new height has been reached
+func (mon *ReorgMonitor) processBlock(ctx context.Context, block *analysis.Block) {
+	mon.recordBlock(RecordNewBlock, block)
+	mon.AddBlock(ctx, block)
 
-		// Do nothing if block is at previous height
-		if block.Number == lastBlockHeight {
+	// Do nothing if block is at previous height
+	if block.Number == mon.lastAnalyzedHeight {
+		return
+	}
+
+	if len(mon.BlocksByHeight) < 3 {
+		return
+	}
+
+	// Analyze blocks once a new height has been reached
+	mon.lastAnalyzedHeight = block.Number
+	treeAnalysis, err := mon.AnalyzeTree(ctx, 0, 2)
+	if err != nil {
+		log.Println("error in SubscribeAndListen->AnalyzeTree", err)
+		return
+	}
+
+	for _, reorg := range treeAnalysis.SortedReorgs() {
+		if !reorg.IsFinished { // don't care about unfinished reorgs
 			continue
 		}
 
-		if len(mon.BlocksByHeight) < 3 {
+		// Nested reorgs are sent once the reorgs they are nested in are finished, which are sent before them
+		if !parentsFinished(reorg) {
 			continue
 		}
 
-		// Analyze blocks once a new height has been reached
-		lastBlockHeight = block.Number
-		analysis, err := mon.AnalyzeTree(0, 2)
-		if err != nil {
-			log.Println("error in SubscribeAndListen->AnalyzeTree", err)
+		// Chains ending next to a blind spot might continue in it, so the reorg isn't known to be finished
+		if treeAnalysis.OverlapsBlindSpot(reorg.StartBlockHeight, reorg.EndBlockHeight+1) {
 			continue
 		}
 
-		for _, reorg := range analysis.Reorgs {
-			if !reorg.IsFinished { // don't care about unfinished reorgs
-				continue
-			}
+		// Send new finished reorgs to channel
+		if _, isKnownReorg := mon.KnownReorgs[reorg.Id()]; !isKnownReorg {
+			mon.lock.Lock()
+			mon.KnownReorgs[reorg.Id()] = reorg.EndBlockHeight
+			mon.lock.Unlock()
+
+			// Only computed once, for the reorgs which are sent
+			reorg.TxDiff = analysis.NewTxDiff(reorg)
+			mon.correlateReorg(reorg)
+			mon.Metrics.ObserveReorg(reorg)
+			reorgInfo := apitypes.NewReorgInfo(reorg, time.Now().UTC())
+			mon.History.Add(reorgInfo)
+			mon.Events.Publish(&Event{Type: EventReorgFinished, Time: reorgInfo.ObservedAt, Reorg: reorgInfo})
+			mon.sendReorg(ctx, reorg)
+		}
+	}
+}
 
-			// Send new finished reorgs to channel
-			if _, isKnownReorg := mon.KnownReorgs[reorg.Id()]; !isKnownReorg {
-				mon.KnownReorgs[reorg.Id()] = reorg.EndBlockHeight
-				mon.NewReorgChan <- reorg
-			}
+// sendReorg sends a finished reorg to NewReorgChan. During shutdown, the reorg is still sent if it is received
+// within ShutdownTimeout.
+func (mon *ReorgMonitor) sendReorg(ctx context.Context, reorg *analysis.Reorg) {
+	select {
+	case mon.NewReorgChan <- reorg:
+		return
+	case <-ctx.Done():
+	}
+
+	timeout := time.NewTimer(mon.ShutdownTimeout)
+	defer timeout.Stop()
+	select {
+	case mon.NewReorgChan <- reorg:
+	case <-timeout.C:
+		log.Println("shutdown timeout, dropped reorg", reorg.Id())
+	}
+}
+
+func parentsFinished(reorg *analysis.Reorg) bool {
+	for parent := reorg.Parent; parent != nil; parent = parent.Parent {
+		if !parent.IsFinished {
+			return false
 		}
 	}
+	return true
+}
+
+// processBeaconBlock remembers a beacon block, and adds the execution layer block of the slot to the tree
+func (mon *ReorgMonitor) processBeaconBlock(ctx context.Context, beaconBlock *analysis.BeaconBlock) {
+	if beaconBlock.ExecutionBlockHash == (common.Hash{}) { // pre-merge
+		return
+	}
+
+	mon.lock.Lock()
+	mon.BeaconBlockByRoot[beaconBlock.Root] = beaconBlock
+	mon.BeaconBlockByExecutionHash[beaconBlock.ExecutionBlockHash] = beaconBlock
+	mon.lock.Unlock()
+
+	if _, isKnown := mon.BlockByHash[beaconBlock.ExecutionBlockHash]; isKnown {
+		return
+	}
+
+	ethBlock, servedBy, err := mon.FetchBlockByHash(ctx, beaconBlock.ExecutionBlockHash, "")
+	if err != nil {
+		log.Printf("error fetching execution block of beacon block %d: %+v\n", beaconBlock.Slot, err)
+		return
+	}
+
+	mon.processBlock(ctx, analysis.NewBlock(ethBlock, analysis.OriginBeacon, servedBy, beaconBlock.ObservedUnixTimestamp))
 }
 
 // AddBlock adds a block to history if it hasn't been seen before, and download unknown referenced blocks (parent, uncles).
-func (mon *ReorgMonitor) AddBlock(block *analysis.Block) bool {
+func (mon *ReorgMonitor) AddBlock(ctx context.Context, block *analysis.Block) bool {
 	defer mon.TrimCache()
 
 	// If known, then only overwrite if known was by uncle
 	knownBlock, isKnown := mon.BlockByHash[block.Hash]
 	if isKnown && knownBlock.Origin != analysis.OriginUncle {
+		mon.updateCanonical(block) // the fork choice node might announce a block first seen from another node
 		return false
 	}
 
@@ -128,6 +360,9 @@ func (mon *ReorgMonitor) AddBlock(block *analysis.Block) bool {
 	blockInfo := fmt.Sprintf("[%25s] Add%s \t %-12s \t %s", block.NodeUri, block.String(), block.Origin, mon)
 	log.Println(blockInfo)
 
+	// Referenced blocks are downloaded without holding the lock, so only the cache and tree updates are locked
+	mon.lock.Lock()
+
 	// Add for access by hash
 	mon.BlockByHash[block.Hash] = block
 
@@ -139,6 +374,13 @@ func (mon *ReorgMonitor) AddBlock(block *analysis.Block) bool {
 	// Add to map of blocks at this height
 	mon.BlocksByHeight[block.Number][block.Hash] = block
 
+	// Add to the tree
+	err := mon.Tree.AddBlock(block)
+	if err != nil {
+		log.Println("error in AddBlock->tree.AddBlock", err)
+	}
+	events := mon.blockEvents(block, !isKnown)
+
 	// Set earliest block
 	if mon.EarliestBlockNumber == 0 || block.Number < mon.EarliestBlockNumber {
 		mon.EarliestBlockNumber = block.Number
@@ -148,19 +390,29 @@ func (mon *ReorgMonitor) AddBlock(block *analysis.Block) bool {
 	if block.Number > mon.LatestBlockNumber {
 		mon.LatestBlockNumber = block.Number
 	}
+	mon.lock.Unlock()
+
+	for _, event := range events {
+		mon.Events.Publish(event)
+	}
 
 	// Check if further blocks can be downloaded from this one
 	if block.Number > mon.EarliestBlockNumber { // check backhistory only if we are past the earliest block
-		err := mon.CheckBlockForReferences(block)
+		err := mon.CheckBlockForReferences(ctx, block)
 		if err != nil {
 			log.Println(err)
 		}
 	}
 
+	// After the references, so the canonical fork choice sees the ancestors of a new head
+	mon.updateCanonical(block)
 	return true
 }
 
 func (mon *ReorgMonitor) TrimCache() {
+	mon.lock.Lock()
+	defer mon.lock.Unlock()
+
 	// Trim reorg history
 	for reorgId, reorgEndBlockheight := range mon.KnownReorgs {
 		if reorgEndBlockheight < mon.EarliestBlockNumber {
@@ -186,17 +438,26 @@ func (mon *ReorgMonitor) TrimCache() {
 		for hash := range blocks {
 			delete(mon.BlocksByHeight[currentHeight], hash)
 			delete(mon.BlockByHash, hash)
+			mon.Tree.RemoveBlock(hash)
+
+			if beaconBlock, found := mon.BeaconBlockByExecutionHash[hash]; found {
+				delete(mon.BeaconBlockByExecutionHash, hash)
+				delete(mon.BeaconBlockByRoot, beaconBlock.Root)
+			}
 		}
 		delete(mon.BlocksByHeight, currentHeight)
+		if mon.canonical != nil {
+			mon.canonical.Forget(currentHeight + 1)
+		}
 	}
 }
 
-func (mon *ReorgMonitor) CheckBlockForReferences(block *analysis.Block) error {
+func (mon *ReorgMonitor) CheckBlockForReferences(ctx context.Context, block *analysis.Block) error {
 	// Check parent
 	_, found := mon.BlockByHash[block.ParentHash]
 	if !found {
 		// fmt.Printf("- parent of %d %s not found (%s), downloading...\n", block.Number, block.Hash, block.ParentHash)
-		_, _, err := mon.EnsureBlock(block.ParentHash, analysis.OriginGetParent, block.NodeUri)
+		_, _, err := mon.EnsureBlock(ctx, block.ParentHash, analysis.OriginGetParent, block.NodeUri)
 		if err != nil {
 			return errors.Wrap(err, "get-parent error")
 		}
@@ -205,7 +466,7 @@ func (mon *ReorgMonitor) CheckBlockForReferences(block *analysis.Block) error {
 	// Check uncles
 	for _, uncleHeader := range block.Block.Uncles() {
 		// fmt.Printf("- block %d %s has uncle: %s\n", block.Number, block.Hash, uncleHeader.Hash())
-		_, _, err := mon.EnsureBlock(uncleHeader.Hash(), analysis.OriginUncle, block.NodeUri)
+		_, _, err := mon.EnsureBlock(ctx, uncleHeader.Hash(), analysis.OriginUncle, block.NodeUri)
 		if err != nil {
 			return errors.Wrap(err, "get-uncle error")
 		}
@@ -215,7 +476,7 @@ func (mon *ReorgMonitor) CheckBlockForReferences(block *analysis.Block) error {
 	return nil
 }
 
-func (mon *ReorgMonitor) EnsureBlock(blockHash common.Hash, origin analysis.BlockOrigin, nodeUri string) (block *analysis.Block, alreadyExisted bool, err error) {
+func (mon *ReorgMonitor) EnsureBlock(ctx context.Context, blockHash common.Hash, origin analysis.BlockOrigin, nodeUri string) (block *analysis.Block, alreadyExisted bool, err error) {
 	// Check and potentially download block
 	var found bool
 	block, found = mon.BlockByHash[blockHash]
@@ -224,59 +485,65 @@ func (mon *ReorgMonitor) EnsureBlock(blockHash common.Hash, origin analysis.Bloc
 	}
 
 	fmt.Printf("- block %s (%s) not found, downloading from %s...\n", blockHash, origin, nodeUri)
-	conn := mon.connections[nodeUri]
-	ethBlock, err := conn.Client.BlockByHash(context.Background(), blockHash)
+	timeStarted := time.Now()
+	ethBlock, servedBy, err := mon.FetchBlockByHash(ctx, blockHash, nodeUri)
+	mon.Metrics.ObserveEnsureBlockFetch(time.Since(timeStarted), err)
 	if err != nil {
-		fmt.Println("- err block not found:", blockHash, err) // todo: try other clients
+		fmt.Println("- err block not found on any node:", blockHash, err)
 		msg := fmt.Sprintf("EnsureBlock error for hash %s", blockHash)
 		return nil, false, errors.Wrap(err, msg)
 	}
 
-	block = analysis.NewBlock(ethBlock, origin, nodeUri, time.Now().UTC().UnixNano())
+	if servedBy != nodeUri {
+		fmt.Printf("- block %s served by %s\n", blockHash, servedBy)
+	}
+
+	block = analysis.NewBlock(ethBlock, origin, servedBy, time.Now().UTC().UnixNano())
 
 	// Add a new block without sending to channel, because that makes reorg.AddBlock() asynchronous, but we want reorg.AddBlock() to wait until all references are added.
-	mon.AddBlock(block)
+	mon.AddBlock(ctx, block)
 	return block, false, nil
 }
 
-func (mon *ReorgMonitor) AnalyzeTree(maxBlocks, distanceToLastBlockHeight uint64) (*analysis.TreeAnalysis, error) {
-	// Set end height of search
-	endBlockNumber := mon.LatestBlockNumber - distanceToLastBlockHeight
+// AnalyzeTree looks for reorgs in the last maxBlocks (0: all) blocks, up to distanceToLastBlockHeight before the
+// latest block. Missing heights in this range are downloaded first. Heights which can't be repaired are reported as
+// blind spots of the analysis.
+func (mon *ReorgMonitor) AnalyzeTree(ctx context.Context, maxBlocks, distanceToLastBlockHeight uint64) (*analysis.TreeAnalysis, error) {
+	startBlockNumber, endBlockNumber := mon.analysisRange(maxBlocks, distanceToLastBlockHeight)
+	if gaps := mon.findGaps(startBlockNumber, endBlockNumber); len(gaps) > 0 {
+		mon.repairGaps(ctx, gaps)
 
-	// Set start height of search
-	startBlockNumber := mon.EarliestBlockNumber
-	if maxBlocks > 0 && endBlockNumber-maxBlocks > mon.EarliestBlockNumber {
-		startBlockNumber = endBlockNumber - maxBlocks
+		// Repaired blocks can trim the cache
+		startBlockNumber, endBlockNumber = mon.analysisRange(maxBlocks, distanceToLastBlockHeight)
 	}
 
-	// Build tree datastructure
-	tree := analysis.NewBlockTree()
-	for height := startBlockNumber; height <= endBlockNumber; height++ {
-		numBlocksAtHeight := len(mon.BlocksByHeight[height])
-		if numBlocksAtHeight == 0 {
-			err := fmt.Errorf("error in monitor.AnalyzeTree: no blocks at height %d", height)
-			return nil, err
-		}
-
-		// Start tree only when 1 block at this height. If more blocks then skip.
-		if tree.FirstNode == nil && numBlocksAtHeight > 1 {
-			continue
-		}
-
-		// Add all blocks at this height to the tree
-		for _, currentBlock := range mon.BlocksByHeight[height] {
-			err := tree.AddBlock(currentBlock)
-			if err != nil {
-				return nil, errors.Wrap(err, "monitor.AnalyzeTree->tree.AddBlock error")
-			}
-		}
-	}
+	mon.lock.RLock()
+	defer mon.lock.RUnlock()
 
-	// Get analysis of tree
-	analysis, err := analysis.NewTreeAnalysis(tree)
+	// Get analysis of the range in the tree
+	analysis, err := analysis.NewTreeAnalysis(mon.Tree, startBlockNumber, endBlockNumber)
 	if err != nil {
 		return nil, errors.Wrap(err, "monitor.AnalyzeTree->NewTreeAnalysis error")
 	}
 
+	analysis.BlindSpots = mon.findGapsLocked(startBlockNumber, endBlockNumber)
 	return analysis, nil
 }
+
+func (mon *ReorgMonitor) analysisRange(maxBlocks, distanceToLastBlockHeight uint64) (startBlockNumber, endBlockNumber uint64) {
+	mon.lock.RLock()
+	defer mon.lock.RUnlock()
+
+	// Set end height of search
+	if mon.LatestBlockNumber < distanceToLastBlockHeight {
+		return 1, 0 // empty range
+	}
+	endBlockNumber = mon.LatestBlockNumber - distanceToLastBlockHeight
+
+	// Set start height of search
+	startBlockNumber = mon.EarliestBlockNumber
+	if maxBlocks > 0 && endBlockNumber > maxBlocks && endBlockNumber-maxBlocks > mon.EarliestBlockNumber {
+		startBlockNumber = endBlockNumber - maxBlocks
+	}
+	return startBlockNumber, endBlockNumber
+}
@@ -0,0 +1,33 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flashbots/reorg-monitor/analysis"
+)
+
+func TestSendReorgDuringShutdown(t *testing.T) {
+	reorgChan := make(chan *analysis.Reorg)
+	mon := NewReorgMonitor(nil, reorgChan, false, 100)
+	mon.ShutdownTimeout = 5 * time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The handler is still busy when the monitor is stopped
+	reorg := &analysis.Reorg{}
+	received := make(chan *analysis.Reorg, 1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		received <- <-reorgChan
+	}()
+	mon.sendReorg(ctx, reorg)
+	if <-received != reorg {
+		t.Error("expected the reorg to be received after the shutdown")
+	}
+
+	// Without a receiver, the reorg is dropped after the timeout
+	mon.ShutdownTimeout = 10 * time.Millisecond
+	mon.sendReorg(ctx, reorg)
+}
@@ -0,0 +1,269 @@
+// PollingConnection is a block source for nodes which only offer HTTP (no eth_subscribe): it regularly asks for the
+// latest block, and walks back through the parents to catch up on heads it skipped between polls.
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultPollInterval = 2 * time.Second
+
+	pollRequestTimeout = 10 * time.Second
+	pollMaxErrors      = 3   // consecutive failed polls after which the node is reconnected
+	pollMaxCatchUp     = 64  // max number of parents fetched after a single poll
+	pollKnownBlocks    = 256 // number of recently seen block hashes to remember
+)
+
+// PollingConnection polls from its own goroutine. The fields below PollInterval are guarded by lock (use Health() to
+// read them).
+type PollingConnection struct {
+	NodeUri      string
+	Client       *ethclient.Client
+	NewBlockChan chan<- *analysis.Block
+	PollInterval time.Duration
+
+	lock sync.RWMutex
+
+	IsConnected  bool
+	IsSubscribed bool // true while polling succeeds
+
+	NumReconnects   int64
+	NumPollErrors   int64
+	NumBlocks       uint64
+	HeadBlockNumber uint64
+
+	numFailedPolls int // consecutive failed polls, only used by the polling goroutine
+
+	knownBlocks map[common.Hash]uint64 // recently delivered blocks (value: block number), only used by the polling goroutine
+	lastHead    *types.Block
+}
+
+// IsPollingUri returns true for node URIs that need polling (HTTP endpoints)
+func IsPollingUri(nodeUri string) bool {
+	return strings.HasPrefix(nodeUri, "http://") || strings.HasPrefix(nodeUri, "https://")
+}
+
+func NewPollingConnection(nodeUri string, newBlockChan chan<- *analysis.Block, pollInterval time.Duration) (*PollingConnection, error) {
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+
+	conn := PollingConnection{
+		NodeUri:      nodeUri,
+		NewBlockChan: newBlockChan,
+		PollInterval: pollInterval,
+		knownBlocks:  make(map[common.Hash]uint64),
+	}
+
+	err := conn.Connect()
+	return &conn, err
+}
+
+func (conn *PollingConnection) Name() string {
+	return conn.NodeUri
+}
+
+func (conn *PollingConnection) Health() BlockSourceHealth {
+	conn.lock.RLock()
+	defer conn.lock.RUnlock()
+	return BlockSourceHealth{
+		IsConnected:     conn.IsConnected,
+		IsSubscribed:    conn.IsSubscribed,
+		NumBlocks:       conn.NumBlocks,
+		HeadBlockNumber: conn.HeadBlockNumber,
+		NumReconnects:   conn.NumReconnects,
+		NumPollErrors:   conn.NumPollErrors,
+	}
+}
+
+func (conn *PollingConnection) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	client := conn.getClient()
+	if client == nil {
+		return nil, fmt.Errorf("not connected to %s", conn.NodeUri)
+	}
+	return client.BlockByHash(ctx, hash)
+}
+
+func (conn *PollingConnection) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	client := conn.getClient()
+	if client == nil {
+		return nil, fmt.Errorf("not connected to %s", conn.NodeUri)
+	}
+	return client.BlockByNumber(ctx, number)
+}
+
+func (conn *PollingConnection) getClient() *ethclient.Client {
+	conn.lock.RLock()
+	defer conn.lock.RUnlock()
+	return conn.Client
+}
+
+func (conn *PollingConnection) setClient(client *ethclient.Client, isConnected bool) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	conn.Client = client
+	conn.IsConnected = isConnected
+}
+
+func (conn *PollingConnection) Connect() (err error) {
+	fmt.Printf("[%25s] Connecting to node (polling every %s)... ", conn.NodeUri, conn.PollInterval)
+	client, err := ethclient.Dial(conn.NodeUri)
+	if err != nil {
+		conn.setClient(nil, false)
+		return err
+	}
+	conn.setClient(client, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), pollRequestTimeout)
+	defer cancel()
+	syncProgress, err := client.SyncProgress(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error at SyncProgress")
+	}
+
+	if syncProgress != nil {
+		return fmt.Errorf("error: sync in progress")
+	}
+
+	fmt.Printf("ok\n")
+	conn.setClient(client, true)
+	return nil
+}
+
+// Subscribe polls for the latest block until ctx is cancelled
+func (conn *PollingConnection) Subscribe(ctx context.Context) error {
+	ticker := time.NewTicker(conn.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if !conn.Health().IsConnected {
+			conn.lock.Lock()
+			conn.NumReconnects += 1
+			conn.lock.Unlock()
+			if err := conn.Connect(); err != nil {
+				log.Printf("[conn %s] reconnect error: %+v\n", conn.NodeUri, err)
+			}
+		}
+
+		if conn.Health().IsConnected {
+			err := conn.Poll(ctx)
+			if ctx.Err() != nil {
+				break
+			}
+			conn.lock.Lock()
+			conn.IsSubscribed = err == nil
+			if err != nil {
+				log.Printf("[conn %s] poll error: %+v\n", conn.NodeUri, err)
+				conn.NumPollErrors += 1
+			}
+			conn.lock.Unlock()
+
+			if err != nil {
+				conn.numFailedPolls += 1
+			} else {
+				conn.numFailedPolls = 0
+			}
+			if conn.numFailedPolls >= pollMaxErrors {
+				conn.numFailedPolls = 0
+				log.Printf("[conn %s] %d polls failed, reconnecting\n", conn.NodeUri, pollMaxErrors)
+				conn.disconnect()
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	conn.disconnect()
+	return nil
+}
+
+func (conn *PollingConnection) disconnect() {
+	if client := conn.getClient(); client != nil {
+		client.Close()
+	}
+	conn.setClient(nil, false)
+}
+
+// Poll fetches the latest block, and all its unknown ancestors (up to pollMaxCatchUp), and sends them to the
+// block channel from earliest to latest. The ancestors weren't seen as head, so they are sent as downloaded parents.
+func (conn *PollingConnection) Poll(ctx context.Context) error {
+	reqCtx, cancel := context.WithTimeout(ctx, pollRequestTimeout)
+	defer cancel()
+
+	client := conn.getClient()
+	if client == nil {
+		return fmt.Errorf("not connected to %s", conn.NodeUri)
+	}
+
+	latest, err := client.BlockByNumber(reqCtx, nil)
+	if err != nil {
+		return errors.Wrap(err, "error at BlockByNumber(latest)")
+	}
+	observed := time.Now().UTC().UnixNano()
+
+	if _, isKnown := conn.knownBlocks[latest.Hash()]; isKnown {
+		return nil
+	}
+
+	// Walk back until reaching a known block (only the latest block on the first poll)
+	newBlocks := []*analysis.Block{analysis.NewBlock(latest, analysis.OriginPoll, conn.NodeUri, observed)}
+	for conn.lastHead != nil && len(newBlocks) < pollMaxCatchUp {
+		earliest := newBlocks[len(newBlocks)-1]
+		if _, isKnown := conn.knownBlocks[earliest.ParentHash]; isKnown || earliest.Number == 0 {
+			break
+		}
+
+		parent, err := client.BlockByHash(reqCtx, earliest.ParentHash)
+		if err != nil {
+			log.Printf("[conn %s] BlockByHash error catching up at %d %s: %+v\n", conn.NodeUri, earliest.Number-1, earliest.ParentHash, err)
+			break
+		}
+		newBlocks = append(newBlocks, analysis.NewBlock(parent, analysis.OriginGetParent, conn.NodeUri, time.Now().UTC().UnixNano()))
+	}
+
+	for i := len(newBlocks) - 1; i >= 0; i-- {
+		conn.lock.Lock()
+		conn.NumBlocks += 1
+		conn.lock.Unlock()
+		conn.knownBlocks[newBlocks[i].Hash] = newBlocks[i].Number
+		select {
+		case conn.NewBlockChan <- newBlocks[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	conn.lastHead = latest
+	conn.lock.Lock()
+	conn.HeadBlockNumber = latest.NumberU64()
+	conn.lock.Unlock()
+	conn.trimKnownBlocks()
+	return nil
+}
+
+func (conn *PollingConnection) trimKnownBlocks() {
+	latestNumber := conn.lastHead.NumberU64()
+	for hash, number := range conn.knownBlocks {
+		if number+pollKnownBlocks < latestNumber {
+			delete(conn.knownBlocks, hash)
+		}
+	}
+}
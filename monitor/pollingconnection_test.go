@@ -0,0 +1,101 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/mocknode"
+)
+
+func TestPollingConnectionCatchesUp(t *testing.T) {
+	node := mocknode.NewNode()
+	defer node.Close()
+
+	b100 := newTestEthBlock(100, nil, 0)
+	b101 := newTestEthBlock(101, b100, 0)
+	b102 := newTestEthBlock(102, b101, 0)
+	b103 := newTestEthBlock(103, b102, 0)
+	node.AddBlocks(b101, b102)
+	node.Announce(b100)
+
+	blockChan := make(chan *analysis.Block, 10)
+	conn, err := NewPollingConnection(node.HttpURL(), blockChan, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := conn.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if block := <-blockChan; block.Hash != b100.Hash() || block.Origin != analysis.OriginPoll {
+		t.Fatalf("expected head 100 from the first poll, got %s", block)
+	}
+
+	// Polling the same head again sends nothing
+	if err := conn.Poll(ctx); err != nil || len(blockChan) != 0 {
+		t.Fatalf("expected no blocks for a known head, got %d (%v)", len(blockChan), err)
+	}
+
+	// The skipped heads are downloaded as parents, and sent before the new head
+	node.Announce(b103)
+	if err := conn.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(blockChan) != 3 {
+		t.Fatalf("expected 3 blocks, got %d", len(blockChan))
+	}
+	p101, p102, head := <-blockChan, <-blockChan, <-blockChan
+	if p101.Hash != b101.Hash() || p102.Hash != b102.Hash() || head.Hash != b103.Hash() {
+		t.Fatalf("expected blocks 101, 102 and 103, got %s, %s and %s", p101, p102, head)
+	}
+	if p101.Origin != analysis.OriginGetParent || p102.Origin != analysis.OriginGetParent || head.Origin != analysis.OriginPoll {
+		t.Errorf("expected the parents to be downloaded and the head polled, got %s, %s and %s", p101.Origin, p102.Origin, head.Origin)
+	}
+	if p101.ObservedUnixTimestamp <= head.ObservedUnixTimestamp {
+		t.Error("expected the parents to be observed when they were downloaded, after the head")
+	}
+
+	health := conn.Health()
+	if health.NumBlocks != 4 || health.HeadBlockNumber != 103 || health.NumResubscribes != 0 || health.NextRetryTimeoutSec != 0 {
+		t.Errorf("unexpected health: %+v", health)
+	}
+}
+
+func TestPollingConnectionReconnects(t *testing.T) {
+	node := mocknode.NewNode()
+	defer node.Close()
+	node.Announce(newTestEthBlock(100, nil, 0))
+
+	conn, err := NewPollingConnection(node.HttpURL(), make(chan *analysis.Block, 10), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		conn.Subscribe(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitFor(t, "first poll", func() bool { return conn.Health().IsSubscribed })
+
+	// Failing polls mark the node as disconnected
+	node.SetDown(true)
+	waitFor(t, "disconnect", func() bool { return !conn.Health().IsConnected })
+	if health := conn.Health(); health.IsSubscribed || health.NumPollErrors < pollMaxErrors {
+		t.Errorf("expected at least %d poll errors, got %+v", pollMaxErrors, health)
+	}
+
+	node.SetDown(false)
+	waitFor(t, "reconnect", func() bool { return conn.Health().IsSubscribed })
+	if health := conn.Health(); !health.IsConnected || health.NumReconnects == 0 {
+		t.Errorf("expected a reconnect, got %+v", health)
+	}
+}
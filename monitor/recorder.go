@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/flashbots/reorg-monitor/analysis"
+)
+
+type RecordType string
+
+const (
+	RecordNewBlock      RecordType = "NewBlock"      // block processed by the monitor (from NewBlockChan or a beacon node)
+	RecordBackfill      RecordType = "Backfill"      // block added by Backfill, without a reorg check
+	RecordBlockByHash   RecordType = "BlockByHash"   // block served by a block source, eg. a parent or uncle
+	RecordBlockByNumber RecordType = "BlockByNumber" // block served by a block source, eg. for backfill or gap repair
+)
+
+// RecordEntry is a line of a recording (JSONL)
+type RecordEntry struct {
+	Type                  RecordType
+	Origin                analysis.BlockOrigin `json:",omitempty"` // only NewBlock and Backfill
+	NodeUri               string
+	ObservedUnixTimestamp int64
+	Number                *uint64       `json:",omitempty"` // requested height of BlockByNumber (nil: latest)
+	Block                 hexutil.Bytes // RLP encoded types.Block
+}
+
+func NewRecordEntry(recordType RecordType, ethBlock *types.Block, nodeUri string, observedUnix int64) (*RecordEntry, error) {
+	data, err := rlp.EncodeToBytes(ethBlock)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordEntry{Type: recordType, NodeUri: nodeUri, ObservedUnixTimestamp: observedUnix, Block: data}, nil
+}
+
+func (entry *RecordEntry) EthBlock() (*types.Block, error) {
+	ethBlock := new(types.Block)
+	err := rlp.DecodeBytes(entry.Block, ethBlock)
+	return ethBlock, err
+}
+
+// Recorder appends the blocks a monitor processes and downloads to a file, which can be replayed with Replay
+type Recorder struct {
+	lock    sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+
+	NumEntries uint64
+}
+
+// NewRecorder opens a recording, and appends to it if it exists
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (r *Recorder) Record(entry *RecordEntry) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.NumEntries += 1
+	return r.encoder.Encode(entry)
+}
+
+// RecordBlock records a block processed (RecordNewBlock) or backfilled (RecordBackfill) by the monitor
+func (r *Recorder) RecordBlock(recordType RecordType, block *analysis.Block) error {
+	entry, err := NewRecordEntry(recordType, block.Block, block.NodeUri, block.ObservedUnixTimestamp)
+	if err != nil {
+		return err
+	}
+	entry.Origin = block.Origin
+	return r.Record(entry)
+}
+
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.file.Close()
+}
+
+// recordingSource records all blocks served by a block source
+type recordingSource struct {
+	BlockSource
+	recorder *Recorder
+}
+
+func (s *recordingSource) record(recordType RecordType, ethBlock *types.Block, number *big.Int) {
+	entry, err := NewRecordEntry(recordType, ethBlock, s.Name(), time.Now().UTC().UnixNano())
+	if err == nil {
+		if number != nil {
+			n := number.Uint64()
+			entry.Number = &n
+		}
+		err = s.recorder.Record(entry)
+	}
+	if err != nil {
+		log.Printf("[conn %s] error recording block %s: %v\n", s.Name(), ethBlock.Hash(), err)
+	}
+}
+
+func (s *recordingSource) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	ethBlock, err := s.BlockSource.BlockByHash(ctx, hash)
+	if err == nil {
+		s.record(RecordBlockByHash, ethBlock, nil)
+	}
+	return ethBlock, err
+}
+
+func (s *recordingSource) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	ethBlock, err := s.BlockSource.BlockByNumber(ctx, number)
+	if err == nil {
+		s.record(RecordBlockByNumber, ethBlock, number)
+	}
+	return ethBlock, err
+}
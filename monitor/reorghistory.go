@@ -0,0 +1,168 @@
+package monitor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/apitypes"
+)
+
+const DefaultReorgHistorySize = 1000
+
+// ReorgFilter selects reorgs for the API. Zero values don't filter.
+type ReorgFilter struct {
+	MinDepth int
+	MaxDepth int
+	SeenLive *bool
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+func (f ReorgFilter) Matches(info *apitypes.ReorgInfo) bool {
+	if f.MinDepth > 0 && info.Depth < f.MinDepth {
+		return false
+	}
+	if f.MaxDepth > 0 && info.Depth > f.MaxDepth {
+		return false
+	}
+	if f.SeenLive != nil && info.SeenLive != *f.SeenLive {
+		return false
+	}
+	if !f.Since.IsZero() && info.ObservedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && info.ObservedAt.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// ReorgHistory is a ring buffer of the most recent reorgs found by the monitor. The monitor adds to it, while the
+// webserver reads from it.
+type ReorgHistory struct {
+	lock   sync.RWMutex
+	reorgs []*apitypes.ReorgInfo // ring buffer, next is the position of the oldest entry once it's full
+	next   int
+}
+
+func NewReorgHistory(size int) *ReorgHistory {
+	if size <= 0 {
+		size = DefaultReorgHistorySize
+	}
+	return &ReorgHistory{
+		reorgs: make([]*apitypes.ReorgInfo, 0, size),
+	}
+}
+
+// Add stores a reorg, replacing the oldest one if the history is full. The reorg must not be changed afterwards.
+func (h *ReorgHistory) Add(info *apitypes.ReorgInfo) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if len(h.reorgs) < cap(h.reorgs) {
+		h.reorgs = append(h.reorgs, info)
+		return
+	}
+	h.reorgs[h.next] = info
+	h.next = (h.next + 1) % len(h.reorgs)
+}
+
+// AddBeaconReorg links a beacon reorg to the latest reorg with this id. The entry is replaced by a copy, because
+// readers might still use the old one.
+func (h *ReorgHistory) AddBeaconReorg(id string, beaconReorgId string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for i := 0; i < len(h.reorgs); i++ {
+		idx := h.newestIndex(i)
+		if h.reorgs[idx].Id != id {
+			continue
+		}
+		for _, knownId := range h.reorgs[idx].BeaconReorgIds {
+			if knownId == beaconReorgId {
+				return
+			}
+		}
+
+		info := *h.reorgs[idx]
+		info.BeaconReorgIds = append(append([]string{}, info.BeaconReorgIds...), beaconReorgId)
+		h.reorgs[idx] = &info
+		return
+	}
+}
+
+// Len returns the number of reorgs in the history
+func (h *ReorgHistory) Len() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.reorgs)
+}
+
+// newestFirst iterates from the latest to the oldest reorg until f returns false. The caller must hold the lock.
+func (h *ReorgHistory) newestFirst(f func(info *apitypes.ReorgInfo) bool) {
+	for i := 0; i < len(h.reorgs); i++ {
+		if !f(h.reorgs[h.newestIndex(i)]) {
+			return
+		}
+	}
+}
+
+// newestIndex returns the position of the i-th latest reorg in the ring buffer
+func (h *ReorgHistory) newestIndex(i int) int {
+	return (h.next - 1 - i + 2*len(h.reorgs)) % len(h.reorgs)
+}
+
+// Reorg returns the latest reorg with this id, or nil if it isn't in the history
+func (h *ReorgHistory) Reorg(id string) (ret *apitypes.ReorgInfo) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	h.newestFirst(func(info *apitypes.ReorgInfo) bool {
+		if info.Id == id {
+			ret = info
+		}
+		return ret == nil
+	})
+	return ret
+}
+
+// Reorgs returns the reorgs matching the filter, latest first
+func (h *ReorgHistory) Reorgs(filter ReorgFilter) []*apitypes.ReorgInfo {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	ret := []*apitypes.ReorgInfo{}
+	h.newestFirst(func(info *apitypes.ReorgInfo) bool {
+		if filter.Matches(info) {
+			ret = append(ret, info)
+		}
+		return filter.Limit <= 0 || len(ret) < filter.Limit
+	})
+	return ret
+}
+
+// Block returns a block which was part of a reorg in the history, and the ids of all reorgs it was part of
+func (h *ReorgHistory) Block(hash common.Hash) (ret *apitypes.BlockInfo) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	h.newestFirst(func(info *apitypes.ReorgInfo) bool {
+		for _, chain := range info.Chains {
+			for _, block := range chain.Blocks {
+				if block.Hash != hash {
+					continue
+				}
+				if ret == nil {
+					blockInfo := block
+					blockInfo.ReorgIds = []string{}
+					ret = &blockInfo
+				}
+				ret.ReorgIds = append(ret.ReorgIds, info.Id)
+			}
+		}
+		return true
+	})
+	return ret
+}
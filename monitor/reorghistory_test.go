@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/apitypes"
+)
+
+func newTestReorgInfo(id string, height uint64, depth int) *apitypes.ReorgInfo {
+	return &apitypes.ReorgInfo{
+		Id:               id,
+		ObservedAt:       time.Unix(int64(height), 0).UTC(),
+		StartBlockHeight: height,
+		EndBlockHeight:   height + uint64(depth) - 1,
+		Depth:            depth,
+		SeenLive:         depth == 1,
+		BeaconReorgIds:   []string{},
+		Chains:           []apitypes.ChainInfo{{Blocks: []apitypes.BlockInfo{{Number: height, Hash: common.Hash{byte(height)}}}}},
+	}
+}
+
+func TestReorgHistoryRingBuffer(t *testing.T) {
+	h := NewReorgHistory(3)
+	for i := uint64(1); i <= 5; i++ {
+		h.Add(newTestReorgInfo(string(rune('a'+i-1)), 100+i, 1))
+	}
+
+	// The oldest reorgs a and b were replaced
+	if h.Len() != 3 || h.Reorg("a") != nil || h.Reorg("b") != nil || h.Reorg("c") == nil {
+		t.Fatalf("expected reorgs c - e, got %d reorgs", h.Len())
+	}
+	reorgs := h.Reorgs(ReorgFilter{})
+	if len(reorgs) != 3 || reorgs[0].Id != "e" || reorgs[1].Id != "d" || reorgs[2].Id != "c" {
+		t.Errorf("expected reorgs e, d, c, got %v", reorgs)
+	}
+
+	if reorgs := h.Reorgs(ReorgFilter{Limit: 2}); len(reorgs) != 2 || reorgs[1].Id != "d" {
+		t.Errorf("expected reorgs e and d, got %v", reorgs)
+	}
+	if reorgs := h.Reorgs(ReorgFilter{Since: time.Unix(104, 0), Until: time.Unix(104, 0)}); len(reorgs) != 1 || reorgs[0].Id != "d" {
+		t.Errorf("expected reorg d, got %v", reorgs)
+	}
+
+	if block := h.Block(common.Hash{104}); block == nil || len(block.ReorgIds) != 1 || block.ReorgIds[0] != "d" {
+		t.Errorf("expected block 104 of reorg d, got %v", block)
+	}
+	if block := h.Block(common.Hash{101}); block != nil {
+		t.Errorf("expected block 101 to be gone with reorg a, got %v", block)
+	}
+}
+
+func TestReorgFilter(t *testing.T) {
+	live, uncle := newTestReorgInfo("live", 100, 1), newTestReorgInfo("uncle", 100, 2)
+	seenLive := true
+	filters := map[string]ReorgFilter{
+		"min_depth": {MinDepth: 2},
+		"max_depth": {MaxDepth: 1},
+		"live":      {SeenLive: &seenLive},
+	}
+	for name, filter := range filters {
+		if filter.Matches(live) == filter.Matches(uncle) {
+			t.Errorf("filter %s: expected only one of the reorgs to match", name)
+		}
+	}
+}
+
+func TestReorgHistoryAddBeaconReorg(t *testing.T) {
+	h := NewReorgHistory(10)
+	h.Add(newTestReorgInfo("a", 100, 1))
+	before := h.Reorg("a")
+
+	h.AddBeaconReorg("a", "slot1")
+	h.AddBeaconReorg("a", "slot1")
+	h.AddBeaconReorg("unknown", "slot2")
+	if info := h.Reorg("a"); len(info.BeaconReorgIds) != 1 || info.BeaconReorgIds[0] != "slot1" {
+		t.Errorf("expected beacon reorg slot1, got %v", info.BeaconReorgIds)
+	}
+	if len(before.BeaconReorgIds) != 0 {
+		t.Error("expected the entry returned earlier to be unchanged")
+	}
+}
@@ -0,0 +1,225 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/reorg-monitor/analysis"
+)
+
+// Recording is a recorded block stream (see Recorder)
+type Recording struct {
+	Entries []*RecordEntry
+}
+
+func LoadRecording(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	recording := &Recording{Entries: []*RecordEntry{}}
+	decoder := json.NewDecoder(file)
+	for {
+		entry := new(RecordEntry)
+		err := decoder.Decode(entry)
+		if err == io.EOF {
+			return recording, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s, entry %d: %w", path, len(recording.Entries)+1, err)
+		}
+		recording.Entries = append(recording.Entries, entry)
+	}
+}
+
+// NumBlocks returns the number of blocks processed or backfilled by the monitor during the recording
+func (r *Recording) NumBlocks() (numBlocks int) {
+	for _, entry := range r.Entries {
+		if entry.Type == RecordNewBlock || entry.Type == RecordBackfill {
+			numBlocks += 1
+		}
+	}
+	return numBlocks
+}
+
+// NodeUris returns the URIs of all nodes in the recording, sorted
+func (r *Recording) NodeUris() []string {
+	isKnown := make(map[string]bool)
+	nodeUris := []string{}
+	for _, entry := range r.Entries {
+		if !isKnown[entry.NodeUri] {
+			isKnown[entry.NodeUri] = true
+			nodeUris = append(nodeUris, entry.NodeUri)
+		}
+	}
+	sort.Strings(nodeUris)
+	return nodeUris
+}
+
+// Sources returns a replay source for every node of the recording
+func (r *Recording) Sources() ([]*ReplaySource, error) {
+	sources := []*ReplaySource{}
+	sourceByNodeUri := make(map[string]*ReplaySource)
+	for _, nodeUri := range r.NodeUris() {
+		source := NewReplaySource(nodeUri)
+		sources = append(sources, source)
+		sourceByNodeUri[nodeUri] = source
+	}
+
+	for i, entry := range r.Entries {
+		if entry.Type == RecordNewBlock || entry.Type == RecordBackfill {
+			continue
+		}
+
+		ethBlock, err := entry.EthBlock()
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		source := sourceByNodeUri[entry.NodeUri]
+		source.byHash[ethBlock.Hash()] = ethBlock
+		if entry.Type == RecordBlockByNumber {
+			key := int64(replayLatest)
+			if entry.Number != nil {
+				key = int64(*entry.Number)
+			}
+			source.byNumber[key] = append(source.byNumber[key], ethBlock)
+		}
+	}
+	return sources, nil
+}
+
+const replayLatest = -1
+
+// ReplaySource is a block source serving the blocks a node served during a recording. If a height was requested
+// several times, the recorded answers are served in order (the last one repeatedly), because the canonical block
+// at a height can change.
+type ReplaySource struct {
+	NodeUri string
+
+	lock        sync.Mutex
+	byHash      map[common.Hash]*types.Block
+	byNumber    map[int64][]*types.Block
+	numByNumber map[int64]int // number of served answers
+}
+
+func NewReplaySource(nodeUri string) *ReplaySource {
+	return &ReplaySource{
+		NodeUri:     nodeUri,
+		byHash:      make(map[common.Hash]*types.Block),
+		byNumber:    make(map[int64][]*types.Block),
+		numByNumber: make(map[int64]int),
+	}
+}
+
+func (s *ReplaySource) Name() string {
+	return s.NodeUri
+}
+
+// Subscribe does nothing, because the recorded blocks are processed by Replay
+func (s *ReplaySource) Subscribe(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (s *ReplaySource) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if ethBlock, found := s.byHash[hash]; found {
+		return ethBlock, nil
+	}
+	return nil, fmt.Errorf("block %s not served by %s in the recording", hash, s.NodeUri)
+}
+
+func (s *ReplaySource) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := int64(replayLatest)
+	if number != nil {
+		key = number.Int64()
+	}
+	answers := s.byNumber[key]
+	if len(answers) == 0 {
+		return nil, fmt.Errorf("block %d not served by %s in the recording", key, s.NodeUri)
+	}
+
+	i := s.numByNumber[key]
+	if i >= len(answers) {
+		i = len(answers) - 1
+	}
+	s.numByNumber[key] += 1
+	return answers[i], nil
+}
+
+func (s *ReplaySource) Health() BlockSourceHealth {
+	return BlockSourceHealth{IsConnected: true, IsSubscribed: true}
+}
+
+// AddReplaySources adds the sources of a recording to the monitor, which serve the blocks it downloads during Replay
+func (mon *ReorgMonitor) AddReplaySources(recording *Recording) error {
+	sources, err := recording.Sources()
+	if err != nil {
+		return err
+	}
+	for _, source := range sources {
+		mon.AddBlockSource(source)
+	}
+	return nil
+}
+
+// Replay processes the recorded blocks like SubscribeAndListen, but from the calling goroutine, so the monitor sees
+// the blocks in the same order as during the recording. Backfilled blocks are added again like Backfill does, without
+// waiting. The time between the other blocks is divided by speed (0: no waiting). The execution blocks of beacon
+// blocks are replayed, but not the beacon blocks (slots) and chain_reorg events, which are not part of recordings.
+func (mon *ReorgMonitor) Replay(ctx context.Context, recording *Recording, speed float64) error {
+	var lastObserved int64
+	for i, entry := range recording.Entries {
+		if entry.Type == RecordBackfill {
+			ethBlock, err := entry.EthBlock()
+			if err != nil {
+				return fmt.Errorf("entry %d: %w", i+1, err)
+			}
+			mon.addBackfillBlock(ctx, analysis.NewBlock(ethBlock, entry.Origin, entry.NodeUri, entry.ObservedUnixTimestamp))
+			continue
+		}
+		if entry.Type != RecordNewBlock {
+			continue
+		}
+
+		if speed > 0 && lastObserved > 0 && entry.ObservedUnixTimestamp > lastObserved {
+			wait := time.Duration(float64(entry.ObservedUnixTimestamp-lastObserved) / speed)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		lastObserved = entry.ObservedUnixTimestamp
+
+		ethBlock, err := entry.EthBlock()
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		mon.processBlock(ctx, analysis.NewBlock(ethBlock, entry.Origin, entry.NodeUri, entry.ObservedUnixTimestamp))
+		mon.expireCorrelations(ctx, time.Now())
+	}
+
+	log.Printf("replayed %d blocks, %s\n", recording.NumBlocks(), mon)
+	return nil
+}
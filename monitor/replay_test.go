@@ -0,0 +1,185 @@
+package monitor
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/mocknode"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.jsonl")
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordedReorg, b101b := runTestReorgOnMockNode(t, recorder)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recording, err := LoadRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The reorg is found at block 104, 105 might not have been processed yet
+	if recording.NumBlocks() < 5 || len(recording.NodeUris()) != 1 {
+		t.Fatalf("expected at least 5 blocks from 1 node, got %d from %v", recording.NumBlocks(), recording.NodeUris())
+	}
+	var lastObserved int64
+	numDownloaded := 0
+	for _, entry := range recording.Entries {
+		switch entry.Type {
+		case RecordNewBlock:
+			if entry.Origin != analysis.OriginSubscription || entry.ObservedUnixTimestamp < lastObserved {
+				t.Errorf("unexpected new block: %+v", entry)
+			}
+			lastObserved = entry.ObservedUnixTimestamp
+		case RecordBlockByHash:
+			numDownloaded += 1
+			if ethBlock, err := entry.EthBlock(); err != nil || ethBlock.Hash() != b101b.Hash() {
+				t.Errorf("expected 101b to be downloaded, got %+v (%v)", entry, err)
+			}
+		}
+	}
+	if numDownloaded != 1 {
+		t.Errorf("expected 1 downloaded block, got %d", numDownloaded)
+	}
+
+	// Replay without a node, at 10x speed (the recorded blocks are about 10ms apart)
+	reorgChan := make(chan *analysis.Reorg, 10)
+	mon := NewReorgMonitor(recording.NodeUris(), reorgChan, false, 100)
+	if err := mon.AddReplaySources(recording); err != nil {
+		t.Fatal(err)
+	}
+	if err := mon.Replay(context.Background(), recording, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case reorg := <-reorgChan:
+		if reorg.Id() != recordedReorg.Id() || len(reorg.BlocksInvolved) != len(recordedReorg.BlocksInvolved) {
+			t.Errorf("replayed reorg %s differs from recorded %s", reorg, recordedReorg)
+		}
+		for hash, block := range recordedReorg.BlocksInvolved {
+			replayed, found := reorg.BlocksInvolved[hash]
+			if !found || replayed.Origin != block.Origin || replayed.NodeUri != block.NodeUri {
+				t.Errorf("block %s: recorded %+v, replayed %+v", hash, block, replayed)
+			}
+			if block.Origin == analysis.OriginSubscription && replayed.ObservedUnixTimestamp != block.ObservedUnixTimestamp {
+				t.Errorf("block %s: expected recorded timestamp", hash)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reorg in replay")
+	}
+	if len(reorgChan) != 0 {
+		t.Errorf("expected one reorg in replay, got %d more", len(reorgChan))
+	}
+}
+
+func TestRecordAndReplayBackfill(t *testing.T) {
+	node := mocknode.NewNode()
+	defer node.Close()
+	chain := []*types.Block{newTestEthBlock(100, nil, 0)}
+	for i := 1; i <= 5; i++ {
+		chain = append(chain, newTestEthBlock(uint64(100+i), chain[i-1], 0))
+	}
+	node.AddBlocks(chain...)
+	node.Announce(chain[5])
+
+	path := filepath.Join(t.TempDir(), "blocks.jsonl")
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon := NewReorgMonitor([]string{node.HttpURL()}, make(chan *analysis.Reorg, 10), false, 100)
+	if err := mon.SetRecorder(recorder); err != nil {
+		t.Fatal(err)
+	}
+	if mon.ConnectClients() != 1 {
+		t.Fatal("expected to connect to the mock node")
+	}
+	mon.Backfill(context.Background(), 5)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recording, err := LoadRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recording.NumBlocks() != 5 {
+		t.Fatalf("expected 5 backfilled blocks, got %d", recording.NumBlocks())
+	}
+
+	// The replay adds the backfilled blocks again, without a node
+	replayed := NewReorgMonitor(recording.NodeUris(), make(chan *analysis.Reorg, 10), false, 100)
+	if err := replayed.AddReplaySources(recording); err != nil {
+		t.Fatal(err)
+	}
+	if err := replayed.Replay(context.Background(), recording, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, ethBlock := range chain[1:] {
+		block, found := replayed.BlockByHash[ethBlock.Hash()]
+		if !found || block.Origin != analysis.OriginBackfill || block.NodeUri != node.HttpURL() {
+			t.Errorf("expected block %d to be backfilled in the replay, got %v", ethBlock.NumberU64(), block)
+		}
+	}
+	if len(replayed.BlockByHash) != len(mon.BlockByHash) {
+		t.Errorf("expected %d blocks in the replay, got %d", len(mon.BlockByHash), len(replayed.BlockByHash))
+	}
+}
+
+func TestSetRecorderAfterSources(t *testing.T) {
+	recorder, err := NewRecorder(filepath.Join(t.TempDir(), "blocks.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+
+	mon := NewReorgMonitor(nil, make(chan *analysis.Reorg), false, 100)
+	mon.AddBlockSource(NewReplaySource("ws://node"))
+	if err := mon.SetRecorder(recorder); err == nil {
+		t.Error("expected an error when setting the recorder after adding a block source")
+	}
+}
+
+func TestReplaySourceByNumber(t *testing.T) {
+	a, b := newTestEthBlock(5, nil, 1), newTestEthBlock(5, nil, 2)
+	recording := &Recording{}
+	for _, ethBlock := range []*types.Block{a, b} {
+		entry, err := NewRecordEntry(RecordBlockByNumber, ethBlock, "ws://node", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		number := uint64(5)
+		entry.Number = &number
+		recording.Entries = append(recording.Entries, entry)
+	}
+
+	sources, err := recording.Sources()
+	if err != nil || len(sources) != 1 {
+		t.Fatalf("expected 1 source, got %d (%v)", len(sources), err)
+	}
+
+	// The canonical block changed between the requests, and the last answer is repeated
+	for i, expected := range []*types.Block{a, b, b} {
+		ethBlock, err := sources[0].BlockByNumber(context.Background(), big.NewInt(5))
+		if err != nil || ethBlock.Hash() != expected.Hash() {
+			t.Errorf("request %d: expected %s, got %v (%v)", i, expected.Hash(), ethBlock, err)
+		}
+	}
+	if _, err := sources[0].BlockByNumber(context.Background(), nil); err == nil {
+		t.Error("expected error for latest block, which wasn't recorded")
+	}
+	if _, err := sources[0].BlockByHash(context.Background(), a.Hash()); err != nil {
+		t.Errorf("expected block by hash: %v", err)
+	}
+}
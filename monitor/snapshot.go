@@ -0,0 +1,77 @@
+package monitor
+
+import (
+	"sort"
+	"time"
+)
+
+// MonitorSnapshot is a copy of the monitor state at one point in time. It shares no memory with the monitor, so it
+// can be read and kept by any goroutine.
+type MonitorSnapshot struct {
+	Time time.Time
+	Id   string
+
+	NumBlocks           int
+	MaxBlocksInCache    int
+	NumHeights          int
+	NumKnownReorgs      int
+	EarliestBlockNumber uint64
+	LatestBlockNumber   uint64
+
+	Connections       []ConnectionSnapshot // sorted by NodeUri
+	BeaconConnections []BeaconConnectionSnapshot
+}
+
+type ConnectionSnapshot struct {
+	NodeUri string
+	Health  BlockSourceHealth
+	Fetch   FetchStats
+}
+
+type BeaconConnectionSnapshot struct {
+	NodeUri string
+	Health  BeaconConnectionHealth
+}
+
+// Snapshot returns a consistent copy of the monitor and connection state
+func (mon *ReorgMonitor) Snapshot() MonitorSnapshot {
+	mon.lock.RLock()
+	defer mon.lock.RUnlock()
+
+	snapshot := MonitorSnapshot{
+		Time:                time.Now().UTC(),
+		Id:                  mon.string(),
+		NumBlocks:           len(mon.BlockByHash),
+		MaxBlocksInCache:    mon.maxBlocksInCache,
+		NumHeights:          len(mon.BlocksByHeight),
+		NumKnownReorgs:      len(mon.KnownReorgs),
+		EarliestBlockNumber: mon.EarliestBlockNumber,
+		LatestBlockNumber:   mon.LatestBlockNumber,
+		Connections:         make([]ConnectionSnapshot, 0, len(mon.connections)),
+		BeaconConnections:   make([]BeaconConnectionSnapshot, 0, len(mon.beaconConnections)),
+	}
+
+	for nodeUri, conn := range mon.connections {
+		connSnapshot := ConnectionSnapshot{
+			NodeUri: nodeUri,
+			Health:  conn.Health(),
+		}
+		if stats, found := mon.FetchStatsByNode[nodeUri]; found {
+			connSnapshot.Fetch = *stats
+		}
+		snapshot.Connections = append(snapshot.Connections, connSnapshot)
+	}
+	sort.Slice(snapshot.Connections, func(i, j int) bool { return snapshot.Connections[i].NodeUri < snapshot.Connections[j].NodeUri })
+
+	for nodeUri, conn := range mon.beaconConnections {
+		snapshot.BeaconConnections = append(snapshot.BeaconConnections, BeaconConnectionSnapshot{
+			NodeUri: nodeUri,
+			Health:  conn.Health(),
+		})
+	}
+	sort.Slice(snapshot.BeaconConnections, func(i, j int) bool {
+		return snapshot.BeaconConnections[i].NodeUri < snapshot.BeaconConnections[j].NodeUri
+	})
+
+	return snapshot
+}
@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/mocknode"
+)
+
+// TestSnapshotWhileAddingBlocks takes snapshots while blocks are added, their parents downloaded and the cache
+// trimmed. Run with -race.
+func TestSnapshotWhileAddingBlocks(t *testing.T) {
+	node := mocknode.NewNode()
+	defer node.Close()
+
+	blocks := []*types.Block{newTestEthBlock(100, nil, 0)}
+	for i := 1; i < 60; i++ {
+		blocks = append(blocks, newTestEthBlock(uint64(100+i), blocks[i-1], 0))
+	}
+	node.AddBlocks(blocks...)
+
+	mon := NewReorgMonitor([]string{node.WsURL()}, make(chan *analysis.Reorg, 10), false, 20)
+	if mon.ConnectClients() != 1 {
+		t.Fatal("expected to connect to the mock node")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Every other block is announced, so the ones in between are downloaded as parents
+		for i := 0; i < len(blocks); i += 2 {
+			mon.AddBlock(context.Background(), analysis.NewBlock(blocks[i], analysis.OriginSubscription, node.WsURL(), 0))
+		}
+	}()
+
+	for isDone := false; !isDone; {
+		select {
+		case <-done:
+			isDone = true
+		default:
+		}
+
+		// AddBlock trims the cache after adding a block and its parent
+		snapshot := mon.Snapshot()
+		if snapshot.NumBlocks > snapshot.MaxBlocksInCache+2 {
+			t.Fatalf("expected at most %d blocks in the cache, got %d", snapshot.MaxBlocksInCache+2, snapshot.NumBlocks)
+		}
+		if _, err := mon.Metrics.Registry.Gather(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot := mon.Snapshot()
+	if snapshot.LatestBlockNumber != 158 || len(snapshot.Connections) != 1 || snapshot.Connections[0].Fetch.NumServed != 29 {
+		t.Errorf("expected latest block 158 and 29 downloaded parents, got %+v", snapshot)
+	}
+}
@@ -0,0 +1,141 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	streamKeepaliveInterval = 15 * time.Second
+	wsWriteTimeout          = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true }, // read-only public data
+}
+
+// parseEventFilter reads the subscription from the query parameters: types (comma separated event types, default:
+// all) and min_depth (for reorg events)
+func parseEventFilter(r *http.Request) (filter EventFilter, err error) {
+	query := r.URL.Query()
+	if filter.Types, err = ParseEventTypes(query.Get("types")); err != nil {
+		return filter, err
+	}
+	if value := query.Get("min_depth"); value != "" {
+		if filter.MinDepth, err = strconv.Atoi(value); err != nil {
+			return filter, fmt.Errorf("invalid min_depth: %s", value)
+		}
+	}
+	return filter, nil
+}
+
+// HandleStreamRequest sends the monitor events as server-sent events (/v1/stream). The SSE event name is the event
+// type, and the data is the JSON encoded Event.
+func (ws *MonitorWebserver) HandleStreamRequest(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "streaming not supported"})
+		return
+	}
+
+	sub := ws.Monitor.Events.Subscribe(filter)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(streamKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.C:
+			if !ok { // shutdown
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Println("error encoding event:", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// HandleWebsocketRequest sends the monitor events as JSON messages over a websocket (/v1/ws). Messages from the
+// client are ignored.
+func (ws *MonitorWebserver) HandleWebsocketRequest(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader already sent an error response
+	}
+	defer conn.Close()
+
+	sub := ws.Monitor.Events.Subscribe(filter)
+	defer sub.Close()
+
+	// Read until the client closes the connection (this also handles ping and close messages)
+	clientGone := make(chan struct{})
+	go func() {
+		defer close(clientGone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(streamKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-clientGone:
+			return
+		case <-keepalive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case event, ok := <-sub.C:
+			if !ok { // shutdown
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutdown"), time.Now().Add(wsWriteTimeout))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
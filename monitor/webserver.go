@@ -1,22 +1,30 @@
 package monitor
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
-	_ "net/http/pprof"
+	"net/http/pprof"
 	"time"
+
+	"github.com/flashbots/reorg-monitor/database"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type MonitorWebserver struct {
 	Monitor     *ReorgMonitor
 	Addr        string
 	TimeStarted time.Time
+	DB          database.Store // optional, serves reorgs which are no longer in the monitor history
+
+	server *http.Server
 }
 
 // API response
 type StatusResponse struct {
-	Monitor     MonitorInfo
-	Connections []ConnectionInfo
+	Monitor           MonitorInfo
+	Connections       []ConnectionInfo
+	BeaconConnections []BeaconConnectionInfo
 }
 
 type MonitorInfo struct {
@@ -35,47 +43,98 @@ type ConnectionInfo struct {
 	NumReconnects   int64
 	NumResubscribes int64
 	NextTimeout     int64
+	NumPollErrors   int64
+
+	NumBlocksServed         uint64 // blocks downloaded from this node (parents, uncles, ...)
+	NumBlocksServedFallback uint64 // blocks downloaded from this node after the announcing node failed
+	NumFetchErrors          uint64
+}
+
+type BeaconConnectionInfo struct {
+	NodeUri         string
+	IsConnected     bool
+	IsSubscribed    bool
+	NumBlocks       uint64
+	NumReorgs       uint64
+	NumResubscribes int64
+	NextTimeout     int64
+	HeadSlot        uint64
 }
 
 func NewMonitorWebserver(monitor *ReorgMonitor, listenAddr string) *MonitorWebserver {
-	return &MonitorWebserver{
+	ws := &MonitorWebserver{
 		Monitor:     monitor,
 		Addr:        listenAddr,
 		TimeStarted: time.Now().UTC(),
 	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ws.HandleStatusRequest)
+	ws.registerApiHandlers(mux)
+	mux.Handle("/metrics", promhttp.HandlerFor(monitor.Metrics.Registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	ws.server = &http.Server{Addr: listenAddr, Handler: mux}
+	return ws
 }
 
 func (ws *MonitorWebserver) HandleStatusRequest(w http.ResponseWriter, r *http.Request) {
-	// fmt.Fprintf(w, "Monitor: %s\n", ws.Monitor.String())
+	snapshot := ws.Monitor.Snapshot()
 	res := StatusResponse{
 		Monitor: MonitorInfo{
-			Id:                  ws.Monitor.String(),
-			NumBlocks:           len(ws.Monitor.BlockByHash),
-			EarliestBlockNumber: ws.Monitor.EarliestBlockNumber,
-			LatestBlockNumber:   ws.Monitor.LatestBlockNumber,
+			Id:                  snapshot.Id,
+			NumBlocks:           snapshot.NumBlocks,
+			EarliestBlockNumber: snapshot.EarliestBlockNumber,
+			LatestBlockNumber:   snapshot.LatestBlockNumber,
 			TimeStarted:         ws.TimeStarted.String(),
 		},
-		Connections: make([]ConnectionInfo, 0),
+		Connections:       make([]ConnectionInfo, 0, len(snapshot.Connections)),
+		BeaconConnections: make([]BeaconConnectionInfo, 0, len(snapshot.BeaconConnections)),
 	}
 
-	for _, c := range ws.Monitor.connections {
-		connInfo := ConnectionInfo{
+	for _, c := range snapshot.Connections {
+		res.Connections = append(res.Connections, ConnectionInfo{
+			NodeUri:                 c.NodeUri,
+			IsConnected:             c.Health.IsConnected,
+			IsSubscribed:            c.Health.IsSubscribed,
+			NumBlocks:               c.Health.NumBlocks,
+			NumReconnects:           c.Health.NumReconnects,
+			NumResubscribes:         c.Health.NumResubscribes,
+			NextTimeout:             c.Health.NextRetryTimeoutSec,
+			NumPollErrors:           c.Health.NumPollErrors,
+			NumBlocksServed:         c.Fetch.NumServed,
+			NumBlocksServedFallback: c.Fetch.NumServedFallback,
+			NumFetchErrors:          c.Fetch.NumFailed,
+		})
+	}
+
+	for _, c := range snapshot.BeaconConnections {
+		res.BeaconConnections = append(res.BeaconConnections, BeaconConnectionInfo{
 			NodeUri:         c.NodeUri,
-			IsConnected:     c.IsConnected,
-			IsSubscribed:    c.IsSubscribed,
-			NumBlocks:       c.NumBlocks,
-			NumReconnects:   c.NumReconnects,
-			NumResubscribes: c.NumResubscribes,
-			NextTimeout:     c.NextRetryTimeoutSec,
-		}
-		res.Connections = append(res.Connections, connInfo)
+			IsConnected:     c.Health.IsConnected,
+			IsSubscribed:    c.Health.IsSubscribed,
+			NumBlocks:       c.Health.NumBlocks,
+			NumReorgs:       c.Health.NumReorgs,
+			NumResubscribes: c.Health.NumResubscribes,
+			NextTimeout:     c.Health.NextRetryTimeoutSec,
+			HeadSlot:        c.Health.HeadSlot,
+		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
 
+// ListenAndServe blocks until the server fails or is shut down (then it returns http.ErrServerClosed)
 func (ws *MonitorWebserver) ListenAndServe() error {
-	http.HandleFunc("/", ws.HandleStatusRequest)
-	return http.ListenAndServe(ws.Addr, nil)
+	return ws.server.ListenAndServe()
+}
+
+// Shutdown stops accepting connections, ends the event streams and waits for active requests until ctx is done
+func (ws *MonitorWebserver) Shutdown(ctx context.Context) error {
+	ws.Monitor.Events.Close()
+	return ws.server.Shutdown(ctx)
 }
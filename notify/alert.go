@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flashbots/reorg-monitor/apitypes"
+	"github.com/flashbots/reorg-monitor/reorgutils"
+)
+
+// Number of blocks listed per chain in chat alerts, the rest is summarized
+const alertMaxBlocksPerChain = 5
+
+// Alert is a notification for one webhook, as passed to the formatters
+type Alert struct {
+	*ReorgNotification
+	ExplorerURL   string
+	NumSuppressed int // reorgs not sent to this webhook since its last alert, because of the rate limit
+}
+
+// markup renders alert text for a chat platform
+type markup struct {
+	bold   func(s string) string
+	link   func(text, url string) string
+	escape func(s string) string
+}
+
+func (a *Alert) title() string {
+	reorg := a.Reorg
+	replaced := "blocks"
+	if reorg.NumReplacedBlocks == 1 {
+		replaced = "block"
+	}
+	return fmt.Sprintf("Reorg at block %d: depth %d, %d replaced %s", reorg.StartBlockHeight, reorg.Depth, reorg.NumReplacedBlocks, replaced)
+}
+
+// text describes the reorg in a few lines: summary, the blocks of each chain with their miner and value, and the
+// value lost. Node URIs are left out, because they often contain API keys.
+func (a *Alert) text(m markup) string {
+	reorg := a.Reorg
+	info := apitypes.NewReorgInfo(reorg, a.ObservedAt) // for the sorted chains
+
+	seenLive := "yes"
+	if !reorg.SeenLive {
+		seenLive = "no (uncles)"
+	}
+	lines := []string{
+		fmt.Sprintf("%s %d · %s %d - %d · %s %d · %s %s", m.bold("Depth:"), reorg.Depth, m.bold("Blocks:"), reorg.StartBlockHeight, reorg.EndBlockHeight,
+			m.bold("Chains:"), len(reorg.Chains), m.bold("Seen live:"), seenLive),
+	}
+
+	numSideChains := 0
+	for _, chain := range info.Chains {
+		if chain.IsMainChain {
+			lines = append(lines, m.bold("Main chain"))
+		} else {
+			numSideChains += 1
+			lines = append(lines, m.bold(fmt.Sprintf("Side chain %d", numSideChains)))
+		}
+
+		for i, block := range chain.Blocks {
+			if i == alertMaxBlocksPerChain {
+				lines = append(lines, m.escape(fmt.Sprintf("… %d more blocks", len(chain.Blocks)-i)))
+				break
+			}
+
+			line := fmt.Sprintf("%d %s miner %s", block.Number,
+				m.link(shortHex(block.Hash.Hex()), a.ExplorerURL+"/block/"+block.Hash.Hex()),
+				m.link(shortHex(block.CoinbaseAddress.Hex()), a.ExplorerURL+"/address/"+block.CoinbaseAddress.Hex()))
+			if value, found := a.BlockValues[block.Hash]; found && value.CoinbaseDiff != nil {
+				line += m.escape(" · " + ethString(value.CoinbaseDiff))
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	if valueLost := a.ValueLost(); valueLost != nil {
+		lines = append(lines, m.bold("Value lost:")+" "+m.escape(ethString(valueLost)))
+	}
+
+	if a.NumSuppressed > 0 {
+		lines = append(lines, m.escape(fmt.Sprintf("(%d more reorgs were not sent because of the rate limit)", a.NumSuppressed)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// shortHex shortens hashes and addresses to 0x1234…abcd
+func shortHex(s string) string {
+	if len(s) <= 12 {
+		return s
+	}
+	return s[:6] + "…" + s[len(s)-4:]
+}
+
+func ethString(wei *big.Int) string {
+	return reorgutils.WeiToEth(wei).Text('f', 4) + " ETH"
+}
+
+// rateLimiter allows up to limit alerts in a sliding window of one minute (0: unlimited)
+type rateLimiter struct {
+	lock          sync.Mutex
+	limit         int
+	sent          []time.Time
+	numSuppressed int
+}
+
+// allow returns whether an alert can be sent now. If so, it also returns the number of alerts suppressed since the
+// last allowed one.
+func (l *rateLimiter) allow(now time.Time) (isAllowed bool, numSuppressed int) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for len(l.sent) > 0 && now.Sub(l.sent[0]) >= time.Minute {
+		l.sent = l.sent[1:]
+	}
+	if len(l.sent) >= l.limit {
+		l.numSuppressed += 1
+		return false, 0
+	}
+
+	l.sent = append(l.sent, now)
+	numSuppressed = l.numSuppressed
+	l.numSuppressed = 0
+	return true, numSuppressed
+}
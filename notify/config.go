@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+const (
+	DefaultMaxAttempts    = 5
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = time.Minute
+	DefaultRequestTimeout = 10 * time.Second
+	DefaultExplorerURL    = "https://etherscan.io"
+)
+
+// Config is read from the --webhook-config file (YAML, JSON or TOML), eg.:
+//
+//	webhooks:
+//	  - url: https://example.com/reorgs
+//	    secret: some-secret
+//	    rules:
+//	      - min-depth: 2
+//	      - seen-live: false
+//	        min-replaced-blocks: 1
+//	  - url: https://hooks.slack.com/services/...
+//	    format: slack
+//	    rate-limit: 5
+//	dead-letter-file: /var/log/reorg-monitor/dead-letters.jsonl
+type Config struct {
+	Webhooks []WebhookConfig `mapstructure:"webhooks"`
+
+	MaxAttempts    int           `mapstructure:"max-attempts"`    // per webhook and reorg, including the first one
+	InitialBackoff time.Duration `mapstructure:"initial-backoff"` // doubles after each failed attempt
+	MaxBackoff     time.Duration `mapstructure:"max-backoff"`
+	RequestTimeout time.Duration `mapstructure:"request-timeout"`
+	DeadLetterFile string        `mapstructure:"dead-letter-file"` // failed and rate limited deliveries are appended as JSON lines (default: only logged)
+	ExplorerURL    string        `mapstructure:"explorer-url"`     // block and address links in chat alerts
+}
+
+type WebhookConfig struct {
+	URL    string `mapstructure:"url"`
+	Secret string `mapstructure:"secret"` // key to sign the requests with (HMAC-SHA256), optional
+	Rules  []Rule `mapstructure:"rules"`  // a reorg is sent if any rule matches (all reorgs if there are no rules)
+
+	Format         string `mapstructure:"format"`           // json (default), slack, discord or telegram
+	TelegramChatId string `mapstructure:"telegram-chat-id"` // required for the telegram format
+	RateLimit      int    `mapstructure:"rate-limit"`       // max alerts per minute (default: 10 for chat formats, unlimited for json)
+}
+
+// LoadConfig reads a config file, and sets the defaults for missing settings
+func LoadConfig(path string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, errors.Wrap(err, "error reading webhook config")
+	}
+
+	config := new(Config)
+	if err := v.Unmarshal(config); err != nil {
+		return nil, errors.Wrap(err, "error parsing webhook config")
+	}
+	return config, config.setDefaults()
+}
+
+func (c *Config) setDefaults() error {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = DefaultInitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+	if c.RequestTimeout <= 0 {
+		c.RequestTimeout = DefaultRequestTimeout
+	}
+
+	if c.ExplorerURL == "" {
+		c.ExplorerURL = DefaultExplorerURL
+	}
+	c.ExplorerURL = strings.TrimSuffix(c.ExplorerURL, "/")
+
+	for i := range c.Webhooks {
+		webhook := &c.Webhooks[i]
+		u, err := url.Parse(webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook url: %s", webhook.URL)
+		}
+
+		if webhook.Format == "" {
+			webhook.Format = FormatJSON
+		}
+		if _, isKnown := formatters[webhook.Format]; !isKnown {
+			return fmt.Errorf("unknown webhook format: %s", webhook.Format)
+		}
+		if webhook.Format == FormatTelegram && webhook.TelegramChatId == "" {
+			return fmt.Errorf("telegram webhook %s needs a telegram-chat-id", redactURL(webhook.URL))
+		}
+		if webhook.RateLimit == 0 && webhook.Format != FormatJSON {
+			webhook.RateLimit = DefaultChatRateLimit
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+// Webhook formats: the generic JSON payload, or a chat message in the payload shape of the platform
+const (
+	FormatJSON     = "json"
+	FormatSlack    = "slack"    // incoming webhook URL
+	FormatDiscord  = "discord"  // webhook URL
+	FormatTelegram = "telegram" // https://api.telegram.org/bot<token>/sendMessage, needs telegram-chat-id
+)
+
+// Alerts per minute for chat formats, if the webhook has no rate limit
+const DefaultChatRateLimit = 10
+
+// formatter builds the request body for a webhook
+type formatter func(webhook WebhookConfig, alert *Alert) ([]byte, error)
+
+var formatters = map[string]formatter{
+	FormatJSON:     formatJSON,
+	FormatSlack:    formatSlack,
+	FormatDiscord:  formatDiscord,
+	FormatTelegram: formatTelegram,
+}
+
+func formatJSON(webhook WebhookConfig, alert *Alert) ([]byte, error) {
+	return json.Marshal(NewWebhookPayload(alert.ReorgNotification))
+}
+
+// Slack: https://api.slack.com/messaging/webhooks (section text is limited to 3000 characters)
+type slackPayload struct {
+	Text   string       `json:"text"` // shown in notifications
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type string    `json:"type"`
+	Text slackText `json:"text"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func formatSlack(webhook WebhookConfig, alert *Alert) ([]byte, error) {
+	text := alert.text(markup{
+		bold:   func(s string) string { return "*" + slackEscaper.Replace(s) + "*" },
+		link:   func(text, url string) string { return fmt.Sprintf("<%s|%s>", url, slackEscaper.Replace(text)) },
+		escape: slackEscaper.Replace,
+	})
+
+	title := alert.title()
+	return json.Marshal(slackPayload{
+		Text: title,
+		Blocks: []slackBlock{
+			{Type: "header", Text: slackText{Type: "plain_text", Text: title}},
+			{Type: "section", Text: slackText{Type: "mrkdwn", Text: truncate(text, 3000, false)}},
+		},
+	})
+}
+
+// Discord: https://discord.com/developers/docs/resources/webhook#execute-webhook (embed descriptions are limited to
+// 4096 characters)
+type discordPayload struct {
+	Username string         `json:"username"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Color       int    `json:"color"`
+	Timestamp   string `json:"timestamp"`
+}
+
+var discordEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "[", `\[`, "]", `\]`)
+
+func formatDiscord(webhook WebhookConfig, alert *Alert) ([]byte, error) {
+	text := alert.text(markup{
+		bold:   func(s string) string { return "**" + discordEscaper.Replace(s) + "**" },
+		link:   func(text, url string) string { return fmt.Sprintf("[%s](%s)", discordEscaper.Replace(text), url) },
+		escape: discordEscaper.Replace,
+	})
+
+	color := 0xf2c94c // yellow, red for reorgs not seen live or deeper than one block
+	if !alert.Reorg.SeenLive || alert.Reorg.Depth > 1 {
+		color = 0xeb5757
+	}
+	return json.Marshal(discordPayload{
+		Username: "reorg-monitor",
+		Embeds: []discordEmbed{{
+			Title:       alert.title(),
+			Description: truncate(text, 4096, false),
+			Color:       color,
+			Timestamp:   alert.ObservedAt.Format(time.RFC3339),
+		}},
+	})
+}
+
+// Telegram: https://core.telegram.org/bots/api#sendmessage (messages are limited to 4096 characters)
+type telegramPayload struct {
+	ChatId                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	ParseMode             string `json:"parse_mode"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+}
+
+func formatTelegram(webhook WebhookConfig, alert *Alert) ([]byte, error) {
+	text := alert.text(markup{
+		bold: func(s string) string { return "<b>" + html.EscapeString(s) + "</b>" },
+		link: func(text, url string) string {
+			return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(url), html.EscapeString(text))
+		},
+		escape: html.EscapeString,
+	})
+
+	return json.Marshal(telegramPayload{
+		ChatId:                webhook.TelegramChatId,
+		Text:                  truncate("<b>"+html.EscapeString(alert.title())+"</b>\n"+text, 4096, true),
+		ParseMode:             "HTML",
+		DisableWebPagePreview: true,
+	})
+}
+
+// truncate cuts a message after the last complete line which fits into the limit (in characters). Lines are complete
+// markup. If not even the first line fits, it is cut outside of tags (<...>) and entities (&...;), and for HTML also
+// outside of elements, so that the platform can still parse the message.
+func truncate(text string, limit int, isHTML bool) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+
+	const suffix = "\n…"
+	cut := runes[:limit-len([]rune(suffix))]
+	for i := len(cut) - 1; i > 0; i-- {
+		if cut[i] == '\n' {
+			return string(cut[:i]) + suffix
+		}
+	}
+	return string(cut[:lastMarkupBoundary(cut, isHTML)]) + suffix
+}
+
+// lastMarkupBoundary returns the length of the longest prefix of the text which doesn't end inside of markup
+func lastMarkupBoundary(text []rune, isHTML bool) int {
+	boundary, depth, tagStart, isInEntity := 0, 0, -1, false
+	for i, r := range text {
+		switch {
+		case tagStart >= 0:
+			if r == '>' {
+				if isHTML && tagStart+1 < i && text[tagStart+1] == '/' {
+					depth -= 1
+				} else if isHTML {
+					depth += 1
+				}
+				tagStart = -1
+			}
+		case isInEntity:
+			isInEntity = r != ';'
+		case r == '<':
+			tagStart = i
+		case r == '&':
+			isInEntity = true
+		}
+
+		if tagStart < 0 && !isInEntity && depth == 0 {
+			boundary = i + 1
+		}
+	}
+	return boundary
+}
@@ -0,0 +1,183 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+)
+
+func newTestAlert(t *testing.T) (alert *Alert, replacedHash common.Hash) {
+	t.Helper()
+	reorg, replacedHash := newTestReorg(t, analysis.OriginSubscription)
+	notification := NewReorgNotification(reorg, map[common.Hash]*BlockValue{
+		replacedHash: {CoinbaseDiff: big.NewInt(25e16)}, // 0.25 ETH
+	})
+	return &Alert{ReorgNotification: notification, ExplorerURL: DefaultExplorerURL, NumSuppressed: 2}, replacedHash
+}
+
+// checkAlertText checks that a chat message has the reorg details in the link syntax of the platform
+func checkAlertText(t *testing.T, format, text, replacedBlockLink string) {
+	t.Helper()
+	for _, expected := range []string{"Depth", "101 - 101", "Main chain", "Side chain 1", replacedBlockLink, "0.2500 ETH", "2 more reorgs"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("%s: expected %q in message:\n%s", format, expected, text)
+		}
+	}
+	if strings.Contains(text, testNode1) {
+		t.Errorf("%s: message must not contain node URIs:\n%s", format, text)
+	}
+}
+
+func TestFormatSlack(t *testing.T) {
+	alert, replacedHash := newTestAlert(t)
+	body, err := formatSlack(WebhookConfig{}, alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payload slackPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(payload.Text, "Reorg at block 101: depth 1, 1 replaced block") || len(payload.Blocks) != 2 {
+		t.Fatalf("unexpected payload: %s", body)
+	}
+	if payload.Blocks[1].Text.Type != "mrkdwn" {
+		t.Errorf("expected mrkdwn section, got %s", payload.Blocks[1].Text.Type)
+	}
+	link := "<https://etherscan.io/block/" + replacedHash.Hex() + "|" + shortHex(replacedHash.Hex()) + ">"
+	checkAlertText(t, FormatSlack, payload.Blocks[1].Text.Text, link)
+}
+
+func TestFormatDiscord(t *testing.T) {
+	alert, replacedHash := newTestAlert(t)
+	body, err := formatDiscord(WebhookConfig{}, alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payload discordPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Embeds) != 1 || payload.Embeds[0].Title == "" || payload.Embeds[0].Timestamp == "" {
+		t.Fatalf("unexpected payload: %s", body)
+	}
+	link := "[" + shortHex(replacedHash.Hex()) + "](https://etherscan.io/block/" + replacedHash.Hex() + ")"
+	checkAlertText(t, FormatDiscord, payload.Embeds[0].Description, link)
+}
+
+func TestFormatTelegram(t *testing.T) {
+	alert, replacedHash := newTestAlert(t)
+	body, err := formatTelegram(WebhookConfig{TelegramChatId: "-1001234"}, alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payload telegramPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.ChatId != "-1001234" || payload.ParseMode != "HTML" || !strings.HasPrefix(payload.Text, "<b>Reorg at block 101") {
+		t.Fatalf("unexpected payload: %s", body)
+	}
+	link := `<a href="https://etherscan.io/block/` + replacedHash.Hex() + `">` + shortHex(replacedHash.Hex()) + "</a>"
+	checkAlertText(t, FormatTelegram, payload.Text, link)
+}
+
+func TestTruncate(t *testing.T) {
+	text := strings.Repeat("line\n", 10) + "last"
+	if truncate(text, 100, false) != text {
+		t.Error("expected short text to be unchanged")
+	}
+	if truncated := truncate(text, 13, false); truncated != "line\nline\n…" {
+		t.Errorf("expected truncation at line end, got %q", truncated)
+	}
+
+	// A single line is cut outside of tags, entities and HTML elements
+	for _, c := range []struct {
+		text     string
+		limit    int
+		isHTML   bool
+		expected string
+	}{
+		{"a <https://x|link> b c d", 9, false, "a \n…"},
+		{"a <https://x|link> b c d", 22, false, "a <https://x|link> b\n…"},
+		{"a &amp; b c d", 7, false, "a \n…"},
+		{`a <a href="https://x">link</a> b c d`, 20, true, "a \n…"},
+		{`a <b>bold</b> b c d`, 11, true, "a \n…"},
+		{`a <b>bold</b> b c d`, 17, true, "a <b>bold</b> b\n…"},
+	} {
+		if truncated := truncate(c.text, c.limit, c.isHTML); truncated != c.expected {
+			t.Errorf("%q (%d): expected %q, got %q", c.text, c.limit, c.expected, truncated)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := &rateLimiter{limit: 2}
+	now := time.Now()
+
+	for i, expected := range []bool{true, true, false, false} {
+		if isAllowed, _ := limiter.allow(now.Add(time.Duration(i) * time.Second)); isAllowed != expected {
+			t.Fatalf("alert %d: expected allowed=%v", i, expected)
+		}
+	}
+
+	// The first alert leaves the window after a minute
+	isAllowed, numSuppressed := limiter.allow(now.Add(time.Minute))
+	if !isAllowed || numSuppressed != 2 {
+		t.Errorf("expected alert with 2 suppressed, got allowed=%v suppressed=%d", isAllowed, numSuppressed)
+	}
+	if isAllowed, _ := limiter.allow(now.Add(time.Minute)); isAllowed {
+		t.Error("expected alert to be suppressed")
+	}
+
+	unlimited := &rateLimiter{}
+	for i := 0; i < 100; i++ {
+		if isAllowed, _ := unlimited.allow(now); !isAllowed {
+			t.Fatal("expected no rate limit")
+		}
+	}
+}
+
+func TestNotifyChatRateLimit(t *testing.T) {
+	reorg, _ := newTestReorg(t, analysis.OriginSubscription)
+	srv, requests := newWebhookTestServer(t)
+	notifier := newTestNotifier(t, WebhookConfig{URL: srv.URL, Format: FormatSlack})
+	if notifier.Config.Webhooks[0].RateLimit != DefaultChatRateLimit {
+		t.Fatalf("expected default rate limit for chat webhook, got %d", notifier.Config.Webhooks[0].RateLimit)
+	}
+
+	for i := 0; i < DefaultChatRateLimit+5; i++ {
+		notifier.Notify(context.Background(), NewReorgNotification(reorg, nil))
+	}
+	notifier.Wait()
+
+	if received := requests(); len(received) != DefaultChatRateLimit {
+		t.Errorf("expected %d messages, got %d", DefaultChatRateLimit, len(received))
+	}
+}
+
+func TestConfigFormats(t *testing.T) {
+	if _, err := NewNotifier(Config{Webhooks: []WebhookConfig{{URL: "https://example.com", Format: "irc"}}}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if _, err := NewNotifier(Config{Webhooks: []WebhookConfig{{URL: "https://api.telegram.org/botX/sendMessage", Format: FormatTelegram}}}); err == nil {
+		t.Error("expected error for telegram webhook without chat id")
+	}
+
+	notifier, err := NewNotifier(Config{Webhooks: []WebhookConfig{{URL: "https://example.com"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook := notifier.Config.Webhooks[0]; webhook.Format != FormatJSON || webhook.RateLimit != 0 {
+		t.Errorf("expected unlimited json webhook by default, got %+v", webhook)
+	}
+}
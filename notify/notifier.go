@@ -0,0 +1,308 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/apitypes"
+	"github.com/pkg/errors"
+)
+
+const (
+	EventReorgFinished = "reorg_finished"
+
+	HeaderEvent     = "X-Reorg-Monitor-Event"
+	HeaderDelivery  = "X-Reorg-Monitor-Delivery" // the reorg id, the same for all attempts
+	HeaderTimestamp = "X-Reorg-Monitor-Timestamp"
+	HeaderSignature = "X-Reorg-Monitor-Signature" // only set if the webhook has a secret
+)
+
+// WebhookPayload is the JSON body sent to the webhooks. The reorg has the same format as in the /v1/reorgs/{id} API.
+type WebhookPayload struct {
+	Event        string
+	Reorg        *apitypes.ReorgInfo
+	ValueLostWei string                         `json:",omitempty"` // only if blocks are simulated
+	BlockValues  map[common.Hash]BlockValueInfo `json:",omitempty"`
+}
+
+// BlockValueInfo is the simulated value of a block in wei (as strings, to keep their precision in JSON)
+type BlockValueInfo struct {
+	CoinbaseDiffWei      string
+	GasFeesWei           string
+	EthSentToCoinbaseWei string
+}
+
+func NewWebhookPayload(n *ReorgNotification) *WebhookPayload {
+	payload := WebhookPayload{
+		Event: EventReorgFinished,
+		Reorg: apitypes.NewReorgInfo(n.Reorg, n.ObservedAt),
+	}
+
+	if valueLost := n.ValueLost(); valueLost != nil {
+		payload.ValueLostWei = valueLost.String()
+		payload.BlockValues = make(map[common.Hash]BlockValueInfo)
+		for hash, value := range n.BlockValues {
+			payload.BlockValues[hash] = BlockValueInfo{
+				CoinbaseDiffWei:      bigIntString(value.CoinbaseDiff),
+				GasFeesWei:           bigIntString(value.GasFees),
+				EthSentToCoinbaseWei: bigIntString(value.EthSentToCoinbase),
+			}
+		}
+	}
+	return &payload
+}
+
+// DeadLetter is a delivery which failed after all attempts, or which was not attempted because of the rate limit of the
+// webhook (Attempts is 0). They are appended to the dead letter file as JSON lines.
+type DeadLetter struct {
+	Time     time.Time
+	URL      string
+	Delivery string
+	Attempts int
+	Error    string
+	Payload  json.RawMessage
+}
+
+// Notifier sends finished reorgs to the webhooks with a matching rule, formatted for the webhook and limited by its
+// rate limit. Each delivery runs in its own goroutine, and is retried with exponential backoff. Deliveries which fail
+// after all attempts, and reorgs over the rate limit, are written to the dead letter log.
+type Notifier struct {
+	Config Config
+	Client *http.Client
+
+	limiters       []*rateLimiter // by webhook index
+	inflight       sync.WaitGroup
+	deadLetterLock sync.Mutex
+}
+
+func NewNotifier(config Config) (*Notifier, error) {
+	config.Webhooks = append([]WebhookConfig{}, config.Webhooks...) // the defaults are set on a copy
+	if err := config.setDefaults(); err != nil {
+		return nil, err
+	}
+
+	n := &Notifier{
+		Config: config,
+		Client: &http.Client{Timeout: config.RequestTimeout},
+	}
+	for _, webhook := range config.Webhooks {
+		n.limiters = append(n.limiters, &rateLimiter{limit: webhook.RateLimit})
+	}
+	return n, nil
+}
+
+// Notify starts the delivery to all matching webhooks, and returns without waiting for it. Cancelling ctx stops the
+// retries, and the undelivered reorgs go to the dead letter log.
+func (n *Notifier) Notify(ctx context.Context, notification *ReorgNotification) {
+	for i, webhook := range n.Config.Webhooks {
+		if !webhook.Matches(notification) {
+			continue
+		}
+
+		isAllowed, numSuppressed := n.limiters[i].allow(time.Now())
+		alert := &Alert{
+			ReorgNotification: notification,
+			ExplorerURL:       n.Config.ExplorerURL,
+			NumSuppressed:     numSuppressed,
+		}
+		body, err := formatters[webhook.Format](webhook, alert)
+		if err != nil {
+			log.Printf("[webhook %s] error formatting reorg %s: %v\n", redactURL(webhook.URL), notification.Reorg.Id(), err)
+			continue
+		}
+
+		// Suppressed reorgs are counted in the next alert, and kept in the dead letter log
+		if !isAllowed {
+			n.deadLetter(DeadLetter{
+				Time:     time.Now().UTC(),
+				URL:      webhook.URL,
+				Delivery: notification.Reorg.Id(),
+				Error:    "rate limit reached",
+				Payload:  body,
+			})
+			continue
+		}
+
+		n.inflight.Add(1)
+		go func(webhook WebhookConfig) {
+			defer n.inflight.Done()
+			n.deliver(ctx, webhook, notification.Reorg.Id(), body)
+		}(webhook)
+	}
+}
+
+// Wait blocks until all started deliveries have succeeded or failed
+func (n *Notifier) Wait() {
+	n.inflight.Wait()
+}
+
+// Matches returns true if any rule matches, or if there are no rules
+func (webhook WebhookConfig) Matches(notification *ReorgNotification) bool {
+	for _, rule := range webhook.Rules {
+		if rule.Matches(notification) {
+			return true
+		}
+	}
+	return len(webhook.Rules) == 0
+}
+
+func (n *Notifier) deliver(ctx context.Context, webhook WebhookConfig, deliveryId string, body []byte) {
+	backoff := n.Config.InitialBackoff
+	attempts := 0
+	var err error
+	for attempts < n.Config.MaxAttempts {
+		attempts += 1
+		var retryAfter time.Duration
+		var isRetryable bool
+		retryAfter, isRetryable, err = n.post(ctx, webhook, deliveryId, body)
+		if err == nil {
+			return
+		}
+		log.Printf("[webhook %s] attempt %d for reorg %s failed: %v\n", redactURL(webhook.URL), attempts, deliveryId, err)
+		if !isRetryable || attempts == n.Config.MaxAttempts {
+			break
+		}
+
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > n.Config.MaxBackoff {
+			wait = n.Config.MaxBackoff
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		backoff *= 2
+		if backoff > n.Config.MaxBackoff {
+			backoff = n.Config.MaxBackoff
+		}
+	}
+
+	n.deadLetter(DeadLetter{
+		Time:     time.Now().UTC(),
+		URL:      webhook.URL,
+		Delivery: deliveryId,
+		Attempts: attempts,
+		Error:    err.Error(),
+		Payload:  body,
+	})
+}
+
+// post sends a single request. On failure, it returns whether to try again, and how long the server asked to wait.
+func (n *Notifier) post(ctx context.Context, webhook WebhookConfig, deliveryId string, body []byte) (retryAfter time.Duration, isRetryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "reorg-monitor")
+	req.Header.Set(HeaderEvent, EventReorgFinished)
+	req.Header.Set(HeaderDelivery, deliveryId)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if webhook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return 0, true, errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16)) // allows reusing the connection
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, false, nil
+	}
+
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		if seconds, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return retryAfter, true, err
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500:
+		return 0, true, err
+	default: // other client errors won't go away by retrying
+		return 0, false, err
+	}
+}
+
+// Sign returns the signature header value for a request body: "sha256=" followed by the hex encoded
+// HMAC-SHA256 of "<timestamp>.<body>". Receivers should also check that the timestamp is recent.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Notifier) deadLetter(entry DeadLetter) {
+	if entry.Attempts == 0 {
+		log.Printf("[webhook %s] not sending reorg %s: %s\n", redactURL(entry.URL), entry.Delivery, entry.Error)
+	} else {
+		log.Printf("[webhook %s] giving up on reorg %s after %d attempts: %s\n", redactURL(entry.URL), entry.Delivery, entry.Attempts, entry.Error)
+	}
+	if n.Config.DeadLetterFile == "" {
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("error encoding dead letter:", err)
+		return
+	}
+
+	n.deadLetterLock.Lock()
+	defer n.deadLetterLock.Unlock()
+
+	f, err := os.OpenFile(n.Config.DeadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Println("error opening dead letter file:", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Println("error writing dead letter file:", err)
+	}
+}
+
+// redactURL only keeps scheme and host for logging, because webhook URLs often contain tokens
+func redactURL(webhookURL string) string {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "invalid url"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func bigIntString(i *big.Int) string {
+	if i == nil {
+		return ""
+	}
+	return i.String()
+}
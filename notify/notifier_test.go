@@ -0,0 +1,348 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/reorgtest"
+)
+
+const (
+	testNode1 = "ws://node1"
+	testNode2 = "ws://node2"
+)
+
+// newTestReorg builds a finished reorg of depth 1 at height 101: block A1 (node1) was replaced by block B1 (node2),
+// whose child B2 ends the reorg
+func newTestReorg(t *testing.T, b1Origin analysis.BlockOrigin) (reorg *analysis.Reorg, replacedHash common.Hash) {
+	t.Helper()
+	blocks := reorgtest.NewBlocks(t, "A1 B2", nil)
+	blocks["P"].NodeUri, blocks["A1"].NodeUri = testNode1, testNode1
+	blocks["B1"].NodeUri, blocks["B2"].NodeUri = testNode2, testNode2
+	blocks["B1"].Origin = b1Origin
+
+	reorg = reorgtest.NewReorg(t, blocks)
+	if !reorg.IsFinished || reorg.Depth != 1 || reorg.NumReplacedBlocks != 1 {
+		t.Fatalf("unexpected test reorg: %s", reorg)
+	}
+	return reorg, blocks["A1"].Hash
+}
+
+type testRequest struct {
+	Header http.Header
+	Body   []byte
+}
+
+// newWebhookTestServer is a stand-in for a webhook receiver, which answers with the given status codes in order
+// (and 200 once they are used up)
+func newWebhookTestServer(t *testing.T, statusCodes ...int) (srv *httptest.Server, requests func() []testRequest) {
+	t.Helper()
+
+	var lock sync.Mutex
+	received := []testRequest{}
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		received = append(received, testRequest{Header: r.Header.Clone(), Body: body})
+		status := http.StatusOK
+		if len(received) <= len(statusCodes) {
+			status = statusCodes[len(received)-1]
+		}
+		lock.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []testRequest {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]testRequest{}, received...)
+	}
+}
+
+func newTestNotifier(t *testing.T, webhooks ...WebhookConfig) *Notifier {
+	t.Helper()
+	notifier, err := NewNotifier(Config{
+		Webhooks:       webhooks,
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		DeadLetterFile: filepath.Join(t.TempDir(), "dead-letters.jsonl"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notifier
+}
+
+func readDeadLetters(t *testing.T, path string) []DeadLetter {
+	t.Helper()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ret := []DeadLetter{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, entry)
+	}
+	return ret
+}
+
+func TestRuleMatches(t *testing.T) {
+	reorg, replacedHash := newTestReorg(t, analysis.OriginSubscription)
+	notSimulated := NewReorgNotification(reorg, nil)
+	simulated := NewReorgNotification(reorg, map[common.Hash]*BlockValue{
+		replacedHash: {CoinbaseDiff: big.NewInt(5e17)}, // 0.5 ETH
+	})
+	isTrue, isFalse := true, false
+
+	testCases := []struct {
+		name         string
+		rule         Rule
+		notification *ReorgNotification
+		expected     bool
+	}{
+		{"empty rule", Rule{}, notSimulated, true},
+		{"min depth", Rule{MinDepth: 1}, notSimulated, true},
+		{"min depth too high", Rule{MinDepth: 2}, notSimulated, false},
+		{"min replaced blocks", Rule{MinReplacedBlocks: 1}, notSimulated, true},
+		{"min replaced blocks too high", Rule{MinReplacedBlocks: 2}, notSimulated, false},
+		{"seen live", Rule{SeenLive: &isTrue}, notSimulated, true},
+		{"not seen live", Rule{SeenLive: &isFalse}, notSimulated, false},
+		{"involved node", Rule{Nodes: []string{"ws://other", testNode2}}, notSimulated, true},
+		{"uninvolved node", Rule{Nodes: []string{"ws://other"}}, notSimulated, false},
+		{"value lost", Rule{MinValueLostEth: 0.4}, simulated, true},
+		{"value lost too high", Rule{MinValueLostEth: 0.6}, simulated, false},
+		{"value lost without simulation", Rule{MinValueLostEth: 0.4}, notSimulated, false},
+		{"all conditions", Rule{MinDepth: 1, SeenLive: &isTrue, Nodes: []string{testNode1}, MinValueLostEth: 0.1}, simulated, true},
+	}
+
+	for _, tc := range testCases {
+		if actual := tc.rule.Matches(tc.notification); actual != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, actual)
+		}
+	}
+
+	uncleReorg, _ := newTestReorg(t, analysis.OriginUncle)
+	if !(Rule{SeenLive: &isFalse}).Matches(NewReorgNotification(uncleReorg, nil)) {
+		t.Error("expected rule for reorgs not seen live to match uncle reorg")
+	}
+}
+
+func TestNotifyDelivery(t *testing.T) {
+	reorg, replacedHash := newTestReorg(t, analysis.OriginSubscription)
+	srv, requests := newWebhookTestServer(t)
+	notifier := newTestNotifier(t, WebhookConfig{URL: srv.URL, Secret: "test-secret"})
+
+	notifier.Notify(context.Background(), NewReorgNotification(reorg, map[common.Hash]*BlockValue{
+		replacedHash: {CoinbaseDiff: big.NewInt(123), GasFees: big.NewInt(100), EthSentToCoinbase: big.NewInt(23)},
+	}))
+	notifier.Wait()
+
+	received := requests()
+	if len(received) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(received))
+	}
+	req := received[0]
+
+	if req.Header.Get(HeaderEvent) != EventReorgFinished || req.Header.Get(HeaderDelivery) != reorg.Id() {
+		t.Errorf("unexpected headers: %v", req.Header)
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature := req.Header.Get(HeaderSignature); signature != Sign("test-secret", timestamp, req.Body) {
+		t.Errorf("invalid signature %s", signature)
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(req.Body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Reorg.Id != reorg.Id() || payload.Reorg.Depth != 1 || len(payload.Reorg.Chains) != 2 {
+		t.Errorf("unexpected reorg in payload: %+v", payload.Reorg)
+	}
+	if payload.ValueLostWei != "123" || payload.BlockValues[replacedHash].GasFeesWei != "100" {
+		t.Errorf("unexpected values in payload: %s %+v", payload.ValueLostWei, payload.BlockValues)
+	}
+
+	if deadLetters := readDeadLetters(t, notifier.Config.DeadLetterFile); len(deadLetters) != 0 {
+		t.Errorf("expected no dead letters, got %d", len(deadLetters))
+	}
+}
+
+func TestNotifyOnlyMatchingWebhooks(t *testing.T) {
+	reorg, _ := newTestReorg(t, analysis.OriginSubscription)
+	deepSrv, deepRequests := newWebhookTestServer(t)
+	allSrv, allRequests := newWebhookTestServer(t)
+	notifier := newTestNotifier(t,
+		WebhookConfig{URL: deepSrv.URL, Rules: []Rule{{MinDepth: 3}, {MinReplacedBlocks: 2}}},
+		WebhookConfig{URL: allSrv.URL},
+	)
+
+	notifier.Notify(context.Background(), NewReorgNotification(reorg, nil))
+	notifier.Wait()
+
+	if len(deepRequests()) != 0 {
+		t.Error("expected no request to the webhook without matching rule")
+	}
+	if len(allRequests()) != 1 {
+		t.Error("expected a request to the webhook without rules")
+	}
+}
+
+func TestNotifyRetries(t *testing.T) {
+	reorg, _ := newTestReorg(t, analysis.OriginSubscription)
+	srv, requests := newWebhookTestServer(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	notifier := newTestNotifier(t, WebhookConfig{URL: srv.URL})
+
+	notifier.Notify(context.Background(), NewReorgNotification(reorg, nil))
+	notifier.Wait()
+
+	if received := requests(); len(received) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(received))
+	}
+	if deadLetters := readDeadLetters(t, notifier.Config.DeadLetterFile); len(deadLetters) != 0 {
+		t.Errorf("expected no dead letters, got %d", len(deadLetters))
+	}
+}
+
+func TestNotifyDeadLetter(t *testing.T) {
+	reorg, _ := newTestReorg(t, analysis.OriginSubscription)
+
+	t.Run("all attempts failed", func(t *testing.T) {
+		srv, requests := newWebhookTestServer(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+		notifier := newTestNotifier(t, WebhookConfig{URL: srv.URL})
+		notifier.Notify(context.Background(), NewReorgNotification(reorg, nil))
+		notifier.Wait()
+
+		if received := requests(); len(received) != 3 {
+			t.Fatalf("expected 3 attempts, got %d", len(received))
+		}
+		deadLetters := readDeadLetters(t, notifier.Config.DeadLetterFile)
+		if len(deadLetters) != 1 {
+			t.Fatalf("expected 1 dead letter, got %d", len(deadLetters))
+		}
+		entry := deadLetters[0]
+		if entry.URL != srv.URL || entry.Delivery != reorg.Id() || entry.Attempts != 3 || entry.Error == "" {
+			t.Errorf("unexpected dead letter: %+v", entry)
+		}
+		var payload WebhookPayload
+		if err := json.Unmarshal(entry.Payload, &payload); err != nil || payload.Reorg.Id != reorg.Id() {
+			t.Errorf("dead letter without payload: %s", entry.Payload)
+		}
+	})
+
+	t.Run("client error is not retried", func(t *testing.T) {
+		srv, requests := newWebhookTestServer(t, http.StatusBadRequest)
+		notifier := newTestNotifier(t, WebhookConfig{URL: srv.URL})
+		notifier.Notify(context.Background(), NewReorgNotification(reorg, nil))
+		notifier.Wait()
+
+		if received := requests(); len(received) != 1 {
+			t.Fatalf("expected 1 attempt, got %d", len(received))
+		}
+		if deadLetters := readDeadLetters(t, notifier.Config.DeadLetterFile); len(deadLetters) != 1 || deadLetters[0].Attempts != 1 {
+			t.Errorf("expected 1 dead letter after 1 attempt, got %+v", deadLetters)
+		}
+	})
+
+	t.Run("over the rate limit", func(t *testing.T) {
+		srv, requests := newWebhookTestServer(t)
+		notifier := newTestNotifier(t, WebhookConfig{URL: srv.URL, RateLimit: 1})
+		for i := 0; i < 3; i++ {
+			notifier.Notify(context.Background(), NewReorgNotification(reorg, nil))
+		}
+		notifier.Wait()
+
+		if received := requests(); len(received) != 1 {
+			t.Fatalf("expected 1 request, got %d", len(received))
+		}
+		deadLetters := readDeadLetters(t, notifier.Config.DeadLetterFile)
+		if len(deadLetters) != 2 || deadLetters[0].Attempts != 0 || deadLetters[0].Delivery != reorg.Id() || len(deadLetters[0].Payload) == 0 {
+			t.Errorf("expected 2 dead letters without attempts, got %+v", deadLetters)
+		}
+	})
+
+	t.Run("cancelled during backoff", func(t *testing.T) {
+		srv, requests := newWebhookTestServer(t, http.StatusServiceUnavailable)
+		notifier := newTestNotifier(t, WebhookConfig{URL: srv.URL})
+		notifier.Config.InitialBackoff = time.Minute
+
+		ctx, cancel := context.WithCancel(context.Background())
+		notifier.Notify(ctx, NewReorgNotification(reorg, nil))
+		for len(requests()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+		notifier.Wait()
+
+		if deadLetters := readDeadLetters(t, notifier.Config.DeadLetterFile); len(deadLetters) != 1 || deadLetters[0].Error != context.Canceled.Error() {
+			t.Errorf("expected 1 dead letter for the cancelled delivery, got %+v", deadLetters)
+		}
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.yaml")
+	config := `
+webhooks:
+  - url: https://example.com/reorgs
+    secret: s3cret
+    rules:
+      - min-depth: 2
+      - seen-live: false
+        nodes: [ws://node1]
+        min-value-lost-eth: 0.5
+max-attempts: 7
+initial-backoff: 2s
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Webhooks) != 1 || conf.Webhooks[0].Secret != "s3cret" || len(conf.Webhooks[0].Rules) != 2 {
+		t.Fatalf("unexpected webhooks: %+v", conf.Webhooks)
+	}
+	rule := conf.Webhooks[0].Rules[1]
+	if rule.SeenLive == nil || *rule.SeenLive || len(rule.Nodes) != 1 || rule.MinValueLostEth != 0.5 {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+	if conf.MaxAttempts != 7 || conf.InitialBackoff != 2*time.Second || conf.MaxBackoff != DefaultMaxBackoff {
+		t.Errorf("unexpected retry settings: %+v", conf)
+	}
+
+	if err := os.WriteFile(path, []byte("webhooks:\n  - url: not-a-url\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected error for invalid webhook url")
+	}
+}
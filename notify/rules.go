@@ -0,0 +1,108 @@
+// Package notify sends finished reorgs to webhooks
+package notify
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/reorgutils"
+	"github.com/metachris/flashbotsrpc"
+)
+
+// BlockValue is the result of simulating a block with mev-geth
+type BlockValue struct {
+	CoinbaseDiff      *big.Int
+	GasFees           *big.Int
+	EthSentToCoinbase *big.Int
+}
+
+func NewBlockValue(res flashbotsrpc.FlashbotsCallBundleResponse) *BlockValue {
+	value := BlockValue{
+		CoinbaseDiff:      new(big.Int),
+		GasFees:           new(big.Int),
+		EthSentToCoinbase: new(big.Int),
+	}
+	value.CoinbaseDiff.SetString(res.CoinbaseDiff, 10)
+	value.GasFees.SetString(res.GasFees, 10)
+	value.EthSentToCoinbase.SetString(res.EthSentToCoinbase, 10)
+	return &value
+}
+
+// ReorgNotification is a finished reorg, with the simulated value of its blocks if simulation is enabled
+type ReorgNotification struct {
+	Reorg       *analysis.Reorg
+	ObservedAt  time.Time
+	BlockValues map[common.Hash]*BlockValue // nil if blocks are not simulated
+}
+
+func NewReorgNotification(reorg *analysis.Reorg, blockValues map[common.Hash]*BlockValue) *ReorgNotification {
+	return &ReorgNotification{
+		Reorg:       reorg,
+		ObservedAt:  time.Now().UTC(),
+		BlockValues: blockValues,
+	}
+}
+
+// ValueLost returns the summed coinbase difference of the replaced blocks (those not on the main chain), or nil if
+// blocks are not simulated. Blocks without a simulation result (eg. without transactions) count as zero.
+func (n *ReorgNotification) ValueLost() *big.Int {
+	if n.BlockValues == nil {
+		return nil
+	}
+
+	ret := new(big.Int)
+	for hash := range n.Reorg.BlocksInvolved {
+		if _, isMainChain := n.Reorg.MainChainBlocks[hash]; isMainChain {
+			continue
+		}
+		if value, found := n.BlockValues[hash]; found && value.CoinbaseDiff != nil {
+			ret.Add(ret, value.CoinbaseDiff)
+		}
+	}
+	return ret
+}
+
+// Rule selects the reorgs sent to a webhook. All set conditions need to match, zero values don't filter.
+type Rule struct {
+	MinDepth          int      `mapstructure:"min-depth"`
+	MinReplacedBlocks int      `mapstructure:"min-replaced-blocks"`
+	SeenLive          *bool    `mapstructure:"seen-live"`
+	Nodes             []string `mapstructure:"nodes"`              // at least one of these nodes saw a block of the reorg
+	MinValueLostEth   float64  `mapstructure:"min-value-lost-eth"` // never matches if blocks are not simulated
+}
+
+func (r Rule) Matches(n *ReorgNotification) bool {
+	reorg := n.Reorg
+	if reorg.Depth < r.MinDepth {
+		return false
+	}
+	if reorg.NumReplacedBlocks < r.MinReplacedBlocks {
+		return false
+	}
+	if r.SeenLive != nil && reorg.SeenLive != *r.SeenLive {
+		return false
+	}
+
+	if len(r.Nodes) > 0 {
+		isInvolved := false
+		for _, nodeUri := range r.Nodes {
+			isInvolved = isInvolved || reorg.EthNodesInvolved[nodeUri]
+		}
+		if !isInvolved {
+			return false
+		}
+	}
+
+	if r.MinValueLostEth > 0 {
+		valueLost := n.ValueLost()
+		if valueLost == nil {
+			return false
+		}
+		if valueLostEth, _ := reorgutils.WeiToEth(valueLost).Float64(); valueLostEth < r.MinValueLostEth {
+			return false
+		}
+	}
+	return true
+}
@@ -11,7 +11,6 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 func Perror(err error) {
@@ -60,7 +59,18 @@ func ColorPrintf(color, format string, a ...interface{}) {
 	fmt.Printf(string(color), str)
 }
 
-func GetBlocks(blockChan chan<- *types.Block, client *ethclient.Client, startBlock, endBlock int64, concurrency int) {
+// BlockByNumberFetcher is implemented by ethclient.Client and the monitor's block sources
+type BlockByNumberFetcher interface {
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+}
+
+func GetBlocks(blockChan chan<- *types.Block, client BlockByNumberFetcher, startBlock, endBlock int64, concurrency int) {
+	GetBlocksWithContext(context.Background(), blockChan, client, startBlock, endBlock, concurrency)
+}
+
+// GetBlocksWithContext downloads the blocks from startBlock to endBlock (inclusive) with concurrent workers, and
+// sends them to blockChan in no particular order. It stops early if ctx is cancelled.
+func GetBlocksWithContext(ctx context.Context, blockChan chan<- *types.Block, client BlockByNumberFetcher, startBlock, endBlock int64, concurrency int) {
 	var blockWorkerWg sync.WaitGroup
 	blockHeightChan := make(chan int64, 100) // blockHeight to fetch with receipts
 
@@ -73,7 +83,7 @@ func GetBlocks(blockChan chan<- *types.Block, client *ethclient.Client, startBlo
 			defer blockWorkerWg.Done()
 			for blockHeight := range blockHeightChan {
 				// fmt.Println(blockHeight)
-				block, err := client.BlockByNumber(context.Background(), big.NewInt(blockHeight))
+				block, err := client.BlockByNumber(ctx, big.NewInt(blockHeight))
 				if err != nil {
 					log.Println("Error getting block:", blockHeight, err)
 					continue
@@ -84,7 +94,7 @@ func GetBlocks(blockChan chan<- *types.Block, client *ethclient.Client, startBlo
 	}
 
 	// Push blocks into channel, for workers to pick up
-	for currentBlockNumber := startBlock; currentBlockNumber <= endBlock; currentBlockNumber++ {
+	for currentBlockNumber := startBlock; currentBlockNumber <= endBlock && ctx.Err() == nil; currentBlockNumber++ {
 		blockHeightChan <- currentBlockNumber
 	}
 
@@ -0,0 +1,55 @@
+package testutils
+
+import (
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/chaingen"
+)
+
+// ChainNodeUri is the node URI of generated blocks
+const ChainNodeUri = "chaingen"
+
+// NewBlocksFromChain returns the blocks of a generated chain by height, as received by a subscription
+func NewBlocksFromChain(chain *chaingen.Chain) []*analysis.Block {
+	blocks := make([]*analysis.Block, len(chain.Blocks))
+	for i, ethBlock := range chain.Blocks {
+		blocks[i] = analysis.NewBlock(ethBlock, analysis.OriginSubscription, ChainNodeUri, 0)
+	}
+	return blocks
+}
+
+// NewBlockTreeFromChain returns a tree (with the default fork choice) of all blocks of a generated chain
+func NewBlockTreeFromChain(chain *chaingen.Chain) (*analysis.BlockTree, error) {
+	tree := analysis.NewBlockTree()
+	for _, block := range NewBlocksFromChain(chain) {
+		if err := tree.AddBlock(block); err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+// NewFixtureFromChain returns a fixture announcing the blocks of a generated chain by height, except the hidden ones
+// (labels), which the monitor needs to download as parents or uncles. The common parent is always announced.
+func NewFixtureFromChain(name string, chain *chaingen.Chain, expected ReorgTestResult, hidden ...string) (*Fixture, error) {
+	isHidden := make(map[string]bool)
+	for _, label := range hidden {
+		if label != chaingen.ParentLabel {
+			isHidden[label] = true
+		}
+	}
+
+	fixture := &Fixture{
+		Name:           name,
+		Description:    "Generated from: " + chain.Spec.String(),
+		ExpectedResult: expected,
+	}
+	for _, ethBlock := range chain.Blocks {
+		isAnnounced := !isHidden[chain.Label(ethBlock.Hash())]
+		fixtureBlock, err := NewFixtureBlock(ethBlock, isAnnounced, chain.IsMainChain(ethBlock.Hash()))
+		if err != nil {
+			return nil, err
+		}
+		fixture.Blocks = append(fixture.Blocks, fixtureBlock)
+	}
+	return fixture, nil
+}
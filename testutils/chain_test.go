@@ -0,0 +1,283 @@
+package testutils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/chaingen"
+)
+
+// expectedFlatReorg returns the reorg of branches which all fork off the common parent. The first longest branch is
+// the main chain, and the reorg is only finished if it is longer than all others.
+func expectedFlatReorg(spec chaingen.Spec) (expected ReorgTestResult, isFinished bool) {
+	mainChain := 0
+	for i, branch := range spec.Branches {
+		if branch.Length > spec.Branches[mainChain].Length {
+			mainChain = i
+		}
+	}
+
+	numSideChainBlocks := 0
+	for i, branch := range spec.Branches {
+		if i == mainChain {
+			continue
+		}
+		numSideChainBlocks += branch.Length
+		if branch.Length > expected.Depth {
+			expected.Depth = branch.Length
+		}
+	}
+
+	expected.StartBlock = spec.ParentNumber + 1
+	expected.EndBlock = spec.ParentNumber + uint64(expected.Depth)
+	expected.NumBlocks = numSideChainBlocks + expected.Depth
+	expected.NumReplacedBlocks = numSideChainBlocks
+	return expected, spec.Branches[mainChain].Length > expected.Depth
+}
+
+func checkFlatReorg(t *testing.T, spec chaingen.Spec) {
+	t.Helper()
+	chain, err := spec.Build()
+	if err != nil {
+		t.Fatalf("%s: %v", spec, err)
+	}
+	tree, err := NewBlockTreeFromChain(chain)
+	if err != nil {
+		t.Fatalf("%s: %v", spec, err)
+	}
+	treeAnalysis, err := analysis.NewTreeAnalysis(tree, spec.ParentNumber, chain.Tip().NumberU64())
+	if err != nil {
+		t.Fatalf("%s: %v", spec, err)
+	}
+
+	expected, isFinished := expectedFlatReorg(spec)
+	if len(spec.Branches) == 1 {
+		if len(treeAnalysis.Reorgs) != 0 {
+			t.Errorf("%s: expected no reorg, got %d", spec, len(treeAnalysis.Reorgs))
+		}
+		return
+	}
+	if !isFinished {
+		for _, reorg := range treeAnalysis.Reorgs {
+			if reorg.IsFinished || reorg.Depth != expected.Depth {
+				t.Errorf("%s: expected unfinished reorg of depth %d, got %s", spec, expected.Depth, reorg)
+			}
+		}
+		return
+	}
+
+	if err := expected.Check(treeAnalysis); err != nil {
+		t.Errorf("%s: %v", spec, err)
+	}
+	for _, reorg := range treeAnalysis.Reorgs {
+		if len(reorg.Chains) != len(spec.Branches) || !reorg.IsFinished || reorg.FirstBlockAfterReorg == nil {
+			t.Errorf("%s: unexpected reorg %s", spec, reorg)
+		}
+		if tip := reorg.FirstBlockAfterReorg; tip.Number != expected.EndBlock+1 || !chain.IsMainChain(tip.Hash) {
+			t.Errorf("%s: unexpected first block after reorg %s", spec, tip)
+		}
+	}
+}
+
+// checkReorg checks the properties of a reorg of a generated chain which don't depend on its shape or fork choice
+func checkReorg(t *testing.T, spec chaingen.Spec, chain *chaingen.Chain, reorg *analysis.Reorg) {
+	t.Helper()
+	if reorg.Depth < 1 || reorg.StartBlockHeight != reorg.CommonParent.Number+1 || len(reorg.Chains) < 2 {
+		t.Errorf("%s: unexpected reorg %s", spec, reorg)
+	}
+	if reorg.IsFinished {
+		if reorg.EndBlockHeight != reorg.CommonParent.Number+uint64(reorg.Depth) || len(reorg.MainChainBlocks) != reorg.Depth || reorg.NumReplacedBlocks < 1 {
+			t.Errorf("%s: unexpected finished reorg %s", spec, reorg)
+		}
+		if reorg.FirstBlockAfterReorg.Number != reorg.EndBlockHeight+1 {
+			t.Errorf("%s: unexpected first block after reorg %s", spec, reorg.FirstBlockAfterReorg)
+		}
+	}
+
+	// The chains are linear, and each one starts at the common parent or at the last block of another chain
+	lastBlocks := map[common.Hash]bool{reorg.CommonParent.Hash: true}
+	for _, blocks := range reorg.Chains {
+		lastBlocks[blocks[len(blocks)-1].Hash] = true
+	}
+	for key, blocks := range reorg.Chains {
+		if !lastBlocks[blocks[0].ParentHash] {
+			t.Errorf("%s: chain %s of %s doesn't start at a fork", spec, chain.Label(key), reorg.Id())
+		}
+		for i, block := range blocks {
+			if chain.Label(block.Hash) == "" || (i > 0 && block.ParentHash != blocks[i-1].Hash) {
+				t.Errorf("%s: chain %s of %s isn't linear", spec, chain.Label(key), reorg.Id())
+			}
+		}
+	}
+}
+
+// FuzzSpec checks the reorgs of random chaingen descriptions (see package chaingen), with the longest chain and the
+// total difficulty fork choice. Descriptions which don't parse or build are skipped. Reorgs of branches which all fork
+// off the common parent without uncles are also compared with the expected result.
+func FuzzSpec(f *testing.F) {
+	for _, seed := range []string{"A1 B1", "A2 B1", "A3 B2 C1", "A4 B3 C1@B1", "A5 B3 C2@A2 D1@B1", "A4 B2 C1 A2~B1 A3~C1", "A3:d1 B2:d5:t1", "A7"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, description string) {
+		spec, err := chaingen.ParseSpec(1000, description)
+		if err != nil || len(spec.Branches) > 8 {
+			t.Skip()
+		}
+		isFlat, numBlocks := len(spec.Uncles) == 0, 0
+		for _, branch := range spec.Branches {
+			numBlocks += branch.Length
+			if branch.ForkFrom != "" && branch.ForkFrom != chaingen.ParentLabel {
+				isFlat = false
+			}
+		}
+		if numBlocks > 48 {
+			t.Skip()
+		}
+		chain, err := spec.Build()
+		if err != nil {
+			t.Skip()
+		}
+
+		for _, forkChoice := range []analysis.ForkChoice{analysis.LongestChain{}, analysis.TotalDifficulty{}} {
+			tree := analysis.NewBlockTreeWithForkChoice(forkChoice)
+			for _, block := range NewBlocksFromChain(chain) {
+				if err := tree.AddBlock(block); err != nil {
+					t.Fatalf("%s: %v", spec, err)
+				}
+			}
+			treeAnalysis, err := analysis.NewTreeAnalysis(tree, spec.ParentNumber, chain.Tip().NumberU64())
+			if err != nil {
+				t.Fatalf("%s (%s): %v", spec, forkChoice.Name(), err)
+			}
+			for _, reorg := range treeAnalysis.Reorgs {
+				checkReorg(t, spec, chain, reorg)
+			}
+		}
+		if isFlat {
+			checkFlatReorg(t, spec)
+		}
+	})
+}
+
+func TestChainFixtures(t *testing.T) {
+	testCases := []struct {
+		description string
+		hidden      []string // downloaded as parents or uncles by the monitor
+		isLive      bool
+	}{
+		{"A3 B2", nil, true},
+		{"A4 B2 C1", []string{"A2", "A3"}, true},              // parents
+		{"A4 B2 C1 A2~B1 A3~C1", []string{"B1", "C1"}, false}, // uncles
+		{"A4 B1 C1 A2~B1 A2~C1", []string{"A2", "B1", "C1"}, false},
+	}
+
+	for i, testCase := range testCases {
+		spec, err := chaingen.ParseSpec(uint64(2000+100*i), testCase.description)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain, err := spec.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, _ := expectedFlatReorg(spec)
+		expected.MustBeLive = testCase.isLive
+		fixture, err := NewFixtureFromChain(fmt.Sprintf("chain_%d", i), chain, expected, testCase.hidden...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		treeAnalysis, err := fixture.Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fixture.ExpectedResult.Check(treeAnalysis); err != nil {
+			t.Errorf("%s: %v", testCase.description, err)
+		}
+		for _, reorg := range treeAnalysis.Reorgs {
+			if reorg.SeenLive != testCase.isLive {
+				t.Errorf("%s: expected SeenLive=%v", testCase.description, testCase.isLive)
+			}
+		}
+	}
+}
+
+func TestNestedReorgs(t *testing.T) {
+	testCases := []struct {
+		description string
+		parents     map[string]string // id of each reorg, and the id of the reorg it is nested in
+		numChains   map[string]int
+	}{
+		// the side chain B forks again
+		{"A4 B3 C1@B1", map[string]string{"3001_3003_d3_b7_l": "", "3002_3002_d1_b2_l": "3001_3003_d3_b7_l"}, map[string]int{"3001_3003_d3_b7_l": 4, "3002_3002_d1_b2_l": 2}},
+		// the main chain forks inside the reorg
+		{"A4 B1 C2@A1", map[string]string{"3001_3001_d1_b2_l": "", "3002_3003_d2_b4_l": "3001_3001_d1_b2_l"}, map[string]int{"3001_3001_d1_b2_l": 2, "3002_3003_d2_b4_l": 2}},
+		// the main chain forks after the reorg
+		{"A4 B1 C1@A2", map[string]string{"3001_3001_d1_b2_l": "", "3003_3003_d1_b2_l": ""}, map[string]int{"3001_3001_d1_b2_l": 2, "3003_3003_d1_b2_l": 2}},
+		// both chains fork
+		{"A5 B3 C2@A2 D1@B1", map[string]string{"3001_3003_d3_b7_l": "", "3002_3002_d1_b2_l": "3001_3003_d3_b7_l", "3003_3004_d2_b4_l": "3001_3003_d3_b7_l"}, map[string]int{"3001_3003_d3_b7_l": 5}},
+	}
+
+	for _, testCase := range testCases {
+		chain, err := chaingen.Generate(3000, testCase.description)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := NewBlockTreeFromChain(chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		treeAnalysis, err := analysis.NewTreeAnalysis(tree, 3000, chain.Tip().NumberU64())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(treeAnalysis.Reorgs) != len(testCase.parents) {
+			t.Errorf("%s: expected %d reorgs, got %v", testCase.description, len(testCase.parents), treeAnalysis.Reorgs)
+			continue
+		}
+		for id, parentId := range testCase.parents {
+			reorg, found := treeAnalysis.Reorgs[id]
+			if !found {
+				t.Errorf("%s: reorg %s not found", testCase.description, id)
+				continue
+			}
+			if !reorg.IsFinished || (parentId == "") == reorg.IsNested() || (parentId != "" && reorg.Parent.Id() != parentId) {
+				t.Errorf("%s: expected finished reorg nested in %q, got %s", testCase.description, parentId, reorg)
+			}
+			if numChains, found := testCase.numChains[id]; found && len(reorg.Chains) != numChains {
+				t.Errorf("%s: expected %d chains in %s, got %d", testCase.description, numChains, id, len(reorg.Chains))
+			}
+
+			// The chains are linear, and only the main chain continues after a fork
+			for key, blocks := range reorg.Chains {
+				for i, block := range blocks {
+					if (i == 0 && block.Hash != key) || (i > 0 && block.ParentHash != blocks[i-1].Hash) {
+						t.Errorf("%s: chain %s of %s isn't linear", testCase.description, key, id)
+					}
+				}
+			}
+			for _, child := range reorg.Children {
+				if child.Parent != reorg || !strings.Contains(reorg.MermaidSyntax(), "nested reorg "+child.Id()) {
+					t.Errorf("%s: nested reorg %s of %s not linked", testCase.description, child.Id(), id)
+				}
+			}
+		}
+
+		numTopLevel := 0
+		for _, parentId := range testCase.parents {
+			if parentId == "" {
+				numTopLevel += 1
+			}
+		}
+		if len(treeAnalysis.TopLevelReorgs()) != numTopLevel {
+			t.Errorf("%s: expected %d top level reorgs, got %d", testCase.description, numTopLevel, len(treeAnalysis.TopLevelReorgs()))
+		}
+	}
+}
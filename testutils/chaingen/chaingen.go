@@ -0,0 +1,339 @@
+// Package chaingen builds block DAGs for reorg scenarios from a compact description, eg. "A3 B2 C1 A2~B1":
+//
+//	A3       branch A with 3 blocks (A1, A2, A3), forking off the common parent P
+//	D2@A1    branch D with 2 blocks (D1, D2), forking off block A1 of an earlier branch
+//	B2:d3:t1 branch B with 2 blocks of difficulty 3 (default: 1), each 1 second after its parent (default: 12)
+//	A2~B1    block A2 includes B1 as uncle
+//
+// Tokens are separated by spaces or commas. Branch names are letters, and P is the common parent. Blocks are valid
+// go-ethereum blocks (hashes, parent hashes and uncle hashes are consistent), and uncles follow the Ethereum rules:
+// at most 2 per block, at most 6 blocks older, and their parent is an ancestor of the including block.
+package chaingen
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ParentLabel is the label of the common parent
+const ParentLabel = "P"
+
+const (
+	maxUnclesPerBlock = 2
+	maxUncleDepth     = 6
+
+	defaultBlockTime = 12 // seconds
+)
+
+var (
+	branchRegex = regexp.MustCompile(`^([A-Za-z]+)(\d+)(?:@(P|[A-Za-z]+\d+))?((?::[dt]\d+)*)$`)
+	uncleRegex  = regexp.MustCompile(`^([A-Za-z]+\d+)~([A-Za-z]+\d+)$`)
+)
+
+// Branch is a chain of Length blocks named <Name>1 .. <Name><Length>
+type Branch struct {
+	Name       string
+	Length     int
+	ForkFrom   string // label of the parent of the first block (default: the common parent)
+	Difficulty int64  // difficulty of each block (default: 1)
+	BlockTime  uint64 // seconds between each block and its parent (default: 12)
+}
+
+// UncleRef means Block includes Uncle as uncle (both are labels)
+type UncleRef struct {
+	Block string
+	Uncle string
+}
+
+type Spec struct {
+	ParentNumber uint64 // height of the common parent
+	Branches     []Branch
+	Uncles       []UncleRef
+	Txs          map[string][]*types.Transaction // transactions by block label (not part of the description)
+}
+
+// ParseSpec parses a description (see package documentation)
+func ParseSpec(parentNumber uint64, description string) (Spec, error) {
+	spec := Spec{ParentNumber: parentNumber}
+	tokens := strings.FieldsFunc(description, func(r rune) bool { return r == ' ' || r == ',' || r == '\t' || r == '\n' })
+	for _, token := range tokens {
+		if m := uncleRegex.FindStringSubmatch(token); m != nil {
+			spec.Uncles = append(spec.Uncles, UncleRef{Block: m[1], Uncle: m[2]})
+			continue
+		}
+
+		m := branchRegex.FindStringSubmatch(token)
+		if m == nil {
+			return spec, fmt.Errorf("invalid token %q", token)
+		}
+		length, err := strconv.Atoi(m[2])
+		if err != nil {
+			return spec, fmt.Errorf("invalid length in %q: %w", token, err)
+		}
+		branch := Branch{Name: m[1], Length: length, ForkFrom: m[3]}
+		for _, option := range strings.Split(m[4], ":")[1:] {
+			value, err := strconv.ParseInt(option[1:], 10, 64)
+			if err != nil {
+				return spec, fmt.Errorf("invalid option in %q: %w", token, err)
+			}
+			if option[0] == 'd' {
+				branch.Difficulty = value
+			} else {
+				branch.BlockTime = uint64(value)
+			}
+		}
+		spec.Branches = append(spec.Branches, branch)
+	}
+	return spec, nil
+}
+
+// String returns the description of the spec
+func (s Spec) String() string {
+	tokens := []string{}
+	for _, branch := range s.Branches {
+		token := fmt.Sprintf("%s%d", branch.Name, branch.Length)
+		if branch.ForkFrom != "" && branch.ForkFrom != ParentLabel {
+			token += "@" + branch.ForkFrom
+		}
+		if branch.Difficulty != 0 {
+			token += fmt.Sprintf(":d%d", branch.Difficulty)
+		}
+		if branch.BlockTime != 0 {
+			token += fmt.Sprintf(":t%d", branch.BlockTime)
+		}
+		tokens = append(tokens, token)
+	}
+	for _, uncle := range s.Uncles {
+		tokens = append(tokens, uncle.Block+"~"+uncle.Uncle)
+	}
+	return strings.Join(tokens, " ")
+}
+
+// Chain is a generated block DAG
+type Chain struct {
+	Spec Spec
+
+	Parent    *types.Block
+	Blocks    []*types.Block // all blocks including the common parent, by height (then label)
+	MainChain []*types.Block // from the common parent to the tip of the longest branch (the first declared on ties)
+
+	BlockByLabel map[string]*types.Block
+	LabelByHash  map[common.Hash]string
+}
+
+// Generate builds the blocks of a description (see package documentation) on a common parent at parentNumber
+func Generate(parentNumber uint64, description string) (*Chain, error) {
+	spec, err := ParseSpec(parentNumber, description)
+	if err != nil {
+		return nil, err
+	}
+	return spec.Build()
+}
+
+// block is a block before it is built, which needs its parent and uncles to be built first
+type block struct {
+	label      string
+	number     uint64
+	parent     string
+	uncles     []string
+	difficulty int64
+	blockTime  uint64
+}
+
+// Build validates the spec, and builds its blocks
+func (s Spec) Build() (*Chain, error) {
+	if len(s.Branches) == 0 {
+		return nil, fmt.Errorf("no branches in %q", s)
+	}
+
+	blocks := map[string]*block{ParentLabel: {label: ParentLabel, number: s.ParentNumber, difficulty: 1}}
+	labels := []string{ParentLabel} // in order of declaration
+	for _, branch := range s.Branches {
+		if branch.Name == ParentLabel {
+			return nil, fmt.Errorf("branch name %s is reserved for the common parent", ParentLabel)
+		}
+		if branch.Length < 1 {
+			return nil, fmt.Errorf("branch %s needs at least one block", branch.Name)
+		}
+		if branch.Difficulty < 0 {
+			return nil, fmt.Errorf("branch %s has a negative difficulty", branch.Name)
+		}
+		difficulty, blockTime := branch.Difficulty, branch.BlockTime
+		if difficulty == 0 {
+			difficulty = 1
+		}
+		if blockTime == 0 {
+			blockTime = defaultBlockTime
+		}
+
+		parentLabel := branch.ForkFrom
+		if parentLabel == "" {
+			parentLabel = ParentLabel
+		}
+		parent, found := blocks[parentLabel]
+		if !found {
+			return nil, fmt.Errorf("branch %s forks off unknown block %s (declare its branch first)", branch.Name, parentLabel)
+		}
+
+		for i := 1; i <= branch.Length; i++ {
+			label := fmt.Sprintf("%s%d", branch.Name, i)
+			if _, exists := blocks[label]; exists {
+				return nil, fmt.Errorf("duplicate block %s (branch %s declared twice?)", label, branch.Name)
+			}
+			blocks[label] = &block{label: label, number: parent.number + 1, parent: parent.label, difficulty: difficulty, blockTime: blockTime}
+			labels = append(labels, label)
+			parent = blocks[label]
+		}
+	}
+
+	isAncestor := func(ancestor, label string) bool {
+		for b := blocks[label]; b != nil; b = blocks[b.parent] {
+			if b.label == ancestor {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, ref := range s.Uncles {
+		b, uncle := blocks[ref.Block], blocks[ref.Uncle]
+		switch {
+		case b == nil || uncle == nil:
+			return nil, fmt.Errorf("uncle %s~%s: unknown block", ref.Block, ref.Uncle)
+		case uncle.number >= b.number || b.number-uncle.number > maxUncleDepth:
+			return nil, fmt.Errorf("uncle %s~%s: uncle must be 1 to %d blocks older", ref.Block, ref.Uncle, maxUncleDepth)
+		case isAncestor(ref.Uncle, ref.Block):
+			return nil, fmt.Errorf("uncle %s~%s: uncle is an ancestor", ref.Block, ref.Uncle)
+		case !isAncestor(uncle.parent, ref.Block):
+			return nil, fmt.Errorf("uncle %s~%s: parent of the uncle is not an ancestor", ref.Block, ref.Uncle)
+		case len(b.uncles) == maxUnclesPerBlock:
+			return nil, fmt.Errorf("uncle %s~%s: more than %d uncles", ref.Block, ref.Uncle, maxUnclesPerBlock)
+		}
+		for _, existing := range b.uncles {
+			if existing == ref.Uncle {
+				return nil, fmt.Errorf("uncle %s~%s: duplicate uncle", ref.Block, ref.Uncle)
+			}
+		}
+		b.uncles = append(b.uncles, ref.Uncle)
+	}
+
+	for label := range s.Txs {
+		if _, found := blocks[label]; !found {
+			return nil, fmt.Errorf("transactions for unknown block %s", label)
+		}
+	}
+
+	chain := &Chain{
+		Spec:         s,
+		BlockByLabel: make(map[string]*types.Block),
+		LabelByHash:  make(map[common.Hash]string),
+	}
+
+	var build func(label string) *types.Block
+	build = func(label string) *types.Block {
+		if ethBlock, built := chain.BlockByLabel[label]; built {
+			return ethBlock
+		}
+
+		b := blocks[label]
+		header := &types.Header{
+			Number:     new(big.Int).SetUint64(b.number),
+			Coinbase:   common.BytesToAddress([]byte(label)),
+			Difficulty: big.NewInt(b.difficulty),
+			GasLimit:   30_000_000,
+			Time:       b.number * defaultBlockTime,
+			Extra:      []byte(label),
+		}
+		if b.parent != "" {
+			parent := build(b.parent)
+			header.ParentHash = parent.Hash()
+			header.Time = parent.Time() + b.blockTime
+		}
+		uncles := []*types.Header{}
+		for _, uncleLabel := range b.uncles {
+			uncles = append(uncles, build(uncleLabel).Header())
+		}
+
+		ethBlock := types.NewBlock(header, s.Txs[label], uncles, nil, new(listHasher))
+		chain.BlockByLabel[label] = ethBlock
+		chain.LabelByHash[ethBlock.Hash()] = label
+		return ethBlock
+	}
+
+	var tip string
+	for _, label := range labels {
+		build(label)
+		if tip == "" || blocks[label].number > blocks[tip].number {
+			tip = label
+		}
+	}
+
+	chain.Parent = chain.BlockByLabel[ParentLabel]
+	for _, label := range labels {
+		chain.Blocks = append(chain.Blocks, chain.BlockByLabel[label])
+	}
+	sort.SliceStable(chain.Blocks, func(i, j int) bool {
+		if chain.Blocks[i].NumberU64() != chain.Blocks[j].NumberU64() {
+			return chain.Blocks[i].NumberU64() < chain.Blocks[j].NumberU64()
+		}
+		return chain.Label(chain.Blocks[i].Hash()) < chain.Label(chain.Blocks[j].Hash())
+	})
+
+	for label := tip; label != ""; label = blocks[label].parent {
+		chain.MainChain = append([]*types.Block{chain.BlockByLabel[label]}, chain.MainChain...)
+	}
+	return chain, nil
+}
+
+// Block returns the block with the label, or nil
+func (c *Chain) Block(label string) *types.Block {
+	return c.BlockByLabel[label]
+}
+
+// Label returns the label of the block with the hash, or "" if it's not part of the chain
+func (c *Chain) Label(hash common.Hash) string {
+	return c.LabelByHash[hash]
+}
+
+// IsMainChain returns true if the block is on the main chain
+func (c *Chain) IsMainChain(hash common.Hash) bool {
+	for _, block := range c.MainChain {
+		if block.Hash() == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// Tip returns the last block of the main chain
+func (c *Chain) Tip() *types.Block {
+	return c.MainChain[len(c.MainChain)-1]
+}
+
+// listHasher derives the transaction root as the hash of all list entries instead of a trie root. Generated blocks are
+// never validated against a state, so the root only has to be deterministic and differ from the empty root.
+type listHasher struct {
+	data []byte
+}
+
+func (h *listHasher) Reset() {
+	h.data = h.data[:0]
+}
+
+func (h *listHasher) Update(key, value []byte) error {
+	h.data = append(h.data, key...)
+	h.data = append(h.data, value...)
+	return nil
+}
+
+func (h *listHasher) Hash() common.Hash {
+	return crypto.Keccak256Hash(h.data)
+}
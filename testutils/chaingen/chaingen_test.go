@@ -0,0 +1,139 @@
+package chaingen
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestGenerate(t *testing.T) {
+	chain, err := Generate(1000, "A3, B2 C1 D1@A1 A2~B1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := chain.Spec.String(); s != "A3 B2 C1 D1@A1 A2~B1" {
+		t.Errorf("unexpected spec: %s", s)
+	}
+
+	if len(chain.Blocks) != 8 || len(chain.BlockByLabel) != 8 {
+		t.Fatalf("expected 8 blocks, got %d", len(chain.Blocks))
+	}
+	for i, block := range chain.Blocks {
+		if i > 0 && block.NumberU64() < chain.Blocks[i-1].NumberU64() {
+			t.Errorf("blocks not ordered by height at %d", i)
+		}
+	}
+
+	parents := map[string]string{"A1": "P", "A2": "A1", "A3": "A2", "B1": "P", "B2": "B1", "C1": "P", "D1": "A1"}
+	for label, parentLabel := range parents {
+		block, parent := chain.Block(label), chain.Block(parentLabel)
+		if block.ParentHash() != parent.Hash() || block.NumberU64() != parent.NumberU64()+1 {
+			t.Errorf("%s is not a child of %s", label, parentLabel)
+		}
+		if chain.Label(block.Hash()) != label {
+			t.Errorf("label of %s: %s", label, chain.Label(block.Hash()))
+		}
+	}
+	if chain.Parent.NumberU64() != 1000 || chain.Label(chain.Parent.Hash()) != ParentLabel {
+		t.Errorf("unexpected common parent %d", chain.Parent.NumberU64())
+	}
+
+	a2 := chain.Block("A2")
+	if len(a2.Uncles()) != 1 || a2.Uncles()[0].Hash() != chain.Block("B1").Hash() || a2.UncleHash() != types.CalcUncleHash(a2.Uncles()) {
+		t.Errorf("expected B1 as uncle of A2")
+	}
+
+	mainChain := []string{}
+	for _, block := range chain.MainChain {
+		mainChain = append(mainChain, chain.Label(block.Hash()))
+	}
+	if len(mainChain) != 4 || mainChain[0] != "P" || mainChain[3] != "A3" || chain.Tip() != chain.Block("A3") {
+		t.Errorf("unexpected main chain %v", mainChain)
+	}
+	if !chain.IsMainChain(chain.Block("A1").Hash()) || chain.IsMainChain(chain.Block("D1").Hash()) {
+		t.Errorf("unexpected main chain blocks")
+	}
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	a, err := Generate(5, "A2 B1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Generate(5, "A2 B1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Tip().Hash() != b.Tip().Hash() {
+		t.Error("expected the same blocks for the same description")
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	invalid := map[string]string{
+		"empty":               "",
+		"invalid token":       "A3 B-2",
+		"zero length":         "A0 B1",
+		"reserved name":       "P2 A1",
+		"duplicate branch":    "A2 A1",
+		"unknown fork point":  "A2 B1@C1",
+		"unknown uncle":       "A2 B1 A2~C1",
+		"uncle not older":     "A2 B2 A2~B2",
+		"uncle too old":       "A8 B1 A8~B1",
+		"uncle is ancestor":   "A3 A3~A1",
+		"uncle parent":        "A3 B2 A3~B2",
+		"too many uncles":     "A3 B1 C1 D1 A2~B1 A2~C1 A2~D1",
+		"duplicate uncle ref": "A3 B1 A2~B1 A2~B1",
+		"unknown option":      "A2:x1 B1",
+		"option overflow":     "A2:d99999999999999999999 B1",
+	}
+	for name, description := range invalid {
+		if _, err := Generate(1, description); err == nil {
+			t.Errorf("%s: expected error for %q", name, description)
+		}
+	}
+}
+
+func TestBranchOptions(t *testing.T) {
+	chain, err := Generate(1000, "A3 B2@A1:t1:d5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := chain.Spec.String(); s != "A3 B2@A1:d5:t1" {
+		t.Errorf("unexpected spec: %s", s)
+	}
+
+	for label, expected := range map[string]struct{ difficulty, time uint64 }{
+		"P":  {1, 12000},
+		"A1": {1, 12012},
+		"A3": {1, 12036},
+		"B1": {5, 12013},
+		"B2": {5, 12014},
+	} {
+		block := chain.Block(label)
+		if block.Difficulty().Uint64() != expected.difficulty || block.Time() != expected.time {
+			t.Errorf("%s: expected difficulty %d and time %d, got %d and %d", label, expected.difficulty, expected.time, block.Difficulty(), block.Time())
+		}
+	}
+}
+
+func TestBuildWithTxs(t *testing.T) {
+	spec, err := ParseSpec(100, "A1 B2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := types.NewTx(&types.LegacyTx{Nonce: 1})
+	spec.Txs = map[string][]*types.Transaction{"B2": {tx}}
+	chain, err := spec.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txs := chain.Block("B2").Transactions(); len(txs) != 1 || txs[0].Hash() != tx.Hash() || len(chain.Block("B1").Transactions()) != 0 {
+		t.Errorf("expected the transaction in B2 only, got %d", len(txs))
+	}
+
+	spec.Txs = map[string][]*types.Transaction{"C1": {tx}}
+	if _, err := spec.Build(); err == nil {
+		t.Error("expected an error for transactions of an unknown block")
+	}
+}
@@ -0,0 +1,260 @@
+package testutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/monitor"
+)
+
+//go:generate go run gen_fixtures.go
+
+// FixtureNodeUri is the node URI of the blocks of a fixture
+const FixtureNodeUri = "fixture"
+
+// Fixture is a test case with all the blocks it needs, so it runs without a node. Announced blocks are added to the
+// monitor in order, like new heads of a subscription. All other blocks (parents, uncles) are only served when the
+// monitor downloads them.
+type Fixture struct {
+	Name           string
+	Description    string `json:",omitempty"`
+	Blocks         []FixtureBlock
+	ExpectedResult ReorgTestResult
+}
+
+type FixtureBlock struct {
+	Rlp       hexutil.Bytes // RLP encoded types.Block
+	Announced bool          `json:",omitempty"`
+	Canonical bool          `json:",omitempty"` // served by number
+}
+
+func NewFixtureBlock(block *types.Block, isAnnounced, isCanonical bool) (FixtureBlock, error) {
+	data, err := rlp.EncodeToBytes(block)
+	return FixtureBlock{Rlp: data, Announced: isAnnounced, Canonical: isCanonical}, err
+}
+
+func (b FixtureBlock) Block() (*types.Block, error) {
+	block := new(types.Block)
+	err := rlp.DecodeBytes(b.Rlp, block)
+	return block, err
+}
+
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fixture := new(Fixture)
+	if err := json.Unmarshal(data, fixture); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return fixture, nil
+}
+
+// LoadFixtures loads all fixtures (*.json) in a directory, ordered by file name
+func LoadFixtures(dir string) ([]*Fixture, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	fixtures := []*Fixture{}
+	for _, path := range paths {
+		fixture, err := LoadFixture(path)
+		if err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	return fixtures, nil
+}
+
+// Encode returns the fixture as saved in its file
+func (f *Fixture) Encode() ([]byte, error) {
+	data, err := json.MarshalIndent(f, "", "  ")
+	return append(data, '\n'), err
+}
+
+func (f *Fixture) Save(dir string) error {
+	data, err := f.Encode()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, f.Name+".json"), data, 0o644)
+}
+
+// Run adds the announced blocks to a new monitor, which downloads all other blocks from the fixture, and analyzes
+// the resulting tree
+func (f *Fixture) Run(ctx context.Context) (*analysis.TreeAnalysis, error) {
+	source, err := NewFixtureSource(f)
+	if err != nil {
+		return nil, err
+	}
+
+	mon := monitor.NewReorgMonitor(nil, make(chan *analysis.Reorg), false, len(f.Blocks)+100)
+	mon.AddBlockSource(source)
+	for _, block := range source.announced {
+		mon.AddBlock(ctx, analysis.NewBlock(block, analysis.OriginSubscription, FixtureNodeUri, 0))
+	}
+	return mon.AnalyzeTree(ctx, 0, 0)
+}
+
+// Check returns an error describing all differences to the expected result, if the analysis doesn't have exactly
+// one reorg matching it
+func (r ReorgTestResult) Check(treeAnalysis *analysis.TreeAnalysis) error {
+	if len(treeAnalysis.Reorgs) != 1 {
+		return fmt.Errorf("NumReorgs mismatch: got %d, want 1", len(treeAnalysis.Reorgs))
+	}
+
+	var reorg *analysis.Reorg
+	for _, r := range treeAnalysis.Reorgs {
+		reorg = r
+	}
+
+	errs := []string{}
+	check := func(f string, got, want interface{}) {
+		if err := Check(f, got, want); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	check("StartBlock", reorg.StartBlockHeight, r.StartBlock)
+	check("EndBlock", reorg.EndBlockHeight, r.EndBlock)
+	check("Depth", reorg.Depth, r.Depth)
+	check("NumBlocks", len(reorg.BlocksInvolved), r.NumBlocks)
+	check("NumReplacedBlocks", reorg.NumReplacedBlocks, r.NumReplacedBlocks)
+	if r.MustBeLive {
+		check("MustBeLive", reorg.SeenLive, true)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("reorg %s: %s", reorg.Id(), strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// FixtureSource is a monitor.BlockSource serving the blocks of a fixture
+type FixtureSource struct {
+	announced []*types.Block
+	byHash    map[common.Hash]*types.Block
+	byNumber  map[uint64]*types.Block
+}
+
+func NewFixtureSource(f *Fixture) (*FixtureSource, error) {
+	source := &FixtureSource{
+		byHash:   make(map[common.Hash]*types.Block),
+		byNumber: make(map[uint64]*types.Block),
+	}
+
+	for i, fixtureBlock := range f.Blocks {
+		block, err := fixtureBlock.Block()
+		if err != nil {
+			return nil, fmt.Errorf("fixture %s, block %d: %w", f.Name, i, err)
+		}
+
+		source.byHash[block.Hash()] = block
+		if fixtureBlock.Canonical {
+			source.byNumber[block.NumberU64()] = block
+		}
+		if fixtureBlock.Announced {
+			source.announced = append(source.announced, block)
+		}
+	}
+	return source, nil
+}
+
+func (s *FixtureSource) Name() string { return FixtureNodeUri }
+
+// Subscribe does nothing, because the announced blocks are added by Fixture.Run
+func (s *FixtureSource) Subscribe(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (s *FixtureSource) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	if block, found := s.byHash[hash]; found {
+		return block, nil
+	}
+	return nil, fmt.Errorf("block %s not in fixture", hash)
+}
+
+func (s *FixtureSource) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	if block, found := s.byNumber[number.Uint64()]; found {
+		return block, nil
+	}
+	return nil, fmt.Errorf("no canonical block %s in fixture", number)
+}
+
+func (s *FixtureSource) Health() monitor.BlockSourceHealth {
+	return monitor.BlockSourceHealth{IsConnected: true, IsSubscribed: true}
+}
+
+// RecordFixture runs a test case against the node of Client (an archive node), and records all blocks the monitor
+// used into a fixture
+func RecordFixture(ctx context.Context, name string, testCase TestCase) (*Fixture, error) {
+	mon := monitor.NewReorgMonitor([]string{EthNodeUri}, make(chan *analysis.Reorg), false, 100)
+	if mon.ConnectClients() == 0 {
+		return nil, fmt.Errorf("could not connect to %s", EthNodeUri)
+	}
+
+	announcedBlocks := BlocksForStrings(testCase.BlockInfo)
+	isAnnounced := make(map[common.Hash]bool)
+	for _, ethBlock := range announcedBlocks {
+		isAnnounced[ethBlock.Hash()] = true
+		mon.AddBlock(ctx, analysis.NewBlock(ethBlock, analysis.OriginSubscription, EthNodeUri, 0))
+	}
+	if _, err := mon.AnalyzeTree(ctx, 0, 0); err != nil { // might download blocks of missing heights
+		return nil, err
+	}
+
+	fixture := &Fixture{
+		Name:           name,
+		Description:    testCase.Name,
+		ExpectedResult: testCase.ExpectedResult,
+	}
+	addBlock := func(block *types.Block) error {
+		canonical, err := Client.BlockByNumber(ctx, block.Number())
+		if err != nil {
+			return err
+		}
+		fixtureBlock, err := NewFixtureBlock(block, isAnnounced[block.Hash()], canonical.Hash() == block.Hash())
+		if err != nil {
+			return err
+		}
+		fixture.Blocks = append(fixture.Blocks, fixtureBlock)
+		return nil
+	}
+
+	// Downloaded blocks by height, then the announced ones in the order of the test case
+	downloaded := []*types.Block{}
+	for hash, block := range mon.BlockByHash {
+		if !isAnnounced[hash] {
+			downloaded = append(downloaded, block.Block)
+		}
+	}
+	sort.Slice(downloaded, func(i, j int) bool {
+		if downloaded[i].NumberU64() != downloaded[j].NumberU64() {
+			return downloaded[i].NumberU64() < downloaded[j].NumberU64()
+		}
+		return downloaded[i].Hash().Hex() < downloaded[j].Hash().Hex()
+	})
+
+	for _, block := range append(downloaded, announcedBlocks...) {
+		if err := addBlock(block); err != nil {
+			return nil, err
+		}
+	}
+	return fixture, nil
+}
@@ -0,0 +1,86 @@
+package testutils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestFixtures(t *testing.T) {
+	fixtures, err := LoadFixtures("fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures found")
+	}
+
+	for _, fixture := range fixtures {
+		fixture := fixture
+		t.Run(fixture.Name, func(t *testing.T) {
+			treeAnalysis, err := fixture.Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := fixture.ExpectedResult.Check(treeAnalysis); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestMainnetFixtures checks the expected results of MainnetTestCases against their recorded fixtures
+func TestMainnetFixtures(t *testing.T) {
+	ids := []string{}
+	for id := range MainnetTestCases {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		testCase := MainnetTestCases[id]
+		name := MainnetFixtureName(id)
+		t.Run(name, func(t *testing.T) {
+			fixture, err := LoadFixture(filepath.Join("fixtures", name+".json"))
+			if errors.Is(err, fs.ErrNotExist) {
+				t.Skipf("fixture not recorded, run reorg-monitor-test -record testutils/fixtures against an archive node")
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			treeAnalysis, err := fixture.Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := testCase.ExpectedResult.Check(treeAnalysis); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestFixturesGenerated checks that the fixtures are up to date with their specs
+func TestFixturesGenerated(t *testing.T) {
+	for _, spec := range FixtureSpecs {
+		fixture, err := spec.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := fixture.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join("fixtures", spec.Name+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("fixture %s is out of date, run go generate ./testutils", spec.Name)
+		}
+	}
+}
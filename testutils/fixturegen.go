@@ -0,0 +1,76 @@
+package testutils
+
+import (
+	"fmt"
+
+	"github.com/flashbots/reorg-monitor/testutils/chaingen"
+)
+
+// FixtureSpec describes a fixture generated with chaingen
+type FixtureSpec struct {
+	Name           string
+	ParentNumber   uint64
+	Description    string   // chaingen description
+	Hidden         []string // blocks the monitor downloads as parents or uncles
+	ExpectedResult ReorgTestResult
+}
+
+// FixtureSpecs are the fixtures in testutils/fixtures, which are generated from them with `go generate ./testutils`
+var FixtureSpecs = []FixtureSpec{
+	{
+		Name:           "d1_b2_uncle",
+		ParentNumber:   1000,
+		Description:    "A4 B1 A2~B1",
+		Hidden:         []string{"B1", "A2", "A3"},
+		ExpectedResult: ReorgTestResult{StartBlock: 1001, EndBlock: 1001, Depth: 1, NumBlocks: 2, NumReplacedBlocks: 1},
+	},
+	{
+		Name:           "d1_b3_two_uncles",
+		ParentNumber:   1100,
+		Description:    "A2 B1 C1 A2~B1 A2~C1",
+		Hidden:         []string{"A1", "B1", "C1"},
+		ExpectedResult: ReorgTestResult{StartBlock: 1101, EndBlock: 1101, Depth: 1, NumBlocks: 3, NumReplacedBlocks: 2},
+	},
+	{
+		Name:           "d2_b4_downloaded_main_chain",
+		ParentNumber:   1200,
+		Description:    "A6 B2",
+		Hidden:         []string{"A1", "A2", "A3", "A4", "A5"},
+		ExpectedResult: ReorgTestResult{StartBlock: 1201, EndBlock: 1202, Depth: 2, NumBlocks: 4, NumReplacedBlocks: 2},
+	},
+	{
+		Name:           "d2_b4_live",
+		ParentNumber:   1300,
+		Description:    "A6 B2",
+		ExpectedResult: ReorgTestResult{MustBeLive: true, StartBlock: 1301, EndBlock: 1302, Depth: 2, NumBlocks: 4, NumReplacedBlocks: 2},
+	},
+	{
+		Name:           "d2_b5_three_branches",
+		ParentNumber:   1400,
+		Description:    "A5 B2 C1",
+		ExpectedResult: ReorgTestResult{MustBeLive: true, StartBlock: 1401, EndBlock: 1402, Depth: 2, NumBlocks: 5, NumReplacedBlocks: 3},
+	},
+	{
+		Name:           "d2_b5_uncle",
+		ParentNumber:   1500,
+		Description:    "A6 B2 C1 A2~C1",
+		Hidden:         []string{"C1", "A3"},
+		ExpectedResult: ReorgTestResult{StartBlock: 1501, EndBlock: 1502, Depth: 2, NumBlocks: 5, NumReplacedBlocks: 3},
+	},
+	{
+		Name:           "d3_b6_downloaded_parents",
+		ParentNumber:   1600,
+		Description:    "A6 B3",
+		Hidden:         []string{"A1", "A2", "A3", "A4", "A5", "B1"},
+		ExpectedResult: ReorgTestResult{StartBlock: 1601, EndBlock: 1603, Depth: 3, NumBlocks: 6, NumReplacedBlocks: 3},
+	},
+}
+
+// Generate builds the chain of the spec, and returns it as fixture
+func (s FixtureSpec) Generate() (*Fixture, error) {
+	chain, err := chaingen.Generate(s.ParentNumber, s.Description)
+	if err != nil {
+		return nil, fmt.Errorf("fixture %s: %w", s.Name, err)
+	}
+	return NewFixtureFromChain(s.Name, chain, s.ExpectedResult, s.Hidden...)
+}
@@ -0,0 +1,28 @@
+//go:build ignore
+
+// Generates the fixtures in testutils/fixtures from testutils.FixtureSpecs. Run with `go generate ./testutils`.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/flashbots/reorg-monitor/testutils"
+)
+
+func main() {
+	if err := os.MkdirAll("fixtures", 0o755); err != nil {
+		log.Fatal(err)
+	}
+	for _, spec := range testutils.FixtureSpecs {
+		fixture, err := spec.Generate()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := fixture.Save("fixtures"); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("generated %s: %d blocks\n", fixture.Name, len(fixture.Blocks))
+	}
+}
@@ -0,0 +1,385 @@
+// Package mocknode is an in-process Ethereum node for tests. It speaks the JSON-RPC methods the monitor uses
+// (eth_subscribe newHeads, eth_getBlockByHash, eth_getBlockByNumber, eth_getUncleByBlockHashAndIndex, eth_syncing)
+// over websocket and HTTP, and serves a block DAG the test controls: announcing heads, forks, syncing phases,
+// response delays, disconnects and downtime.
+package mocknode
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Node is safe for concurrent use. Blocks are served by hash once added, and by number if they are on the chain of
+// the latest announced head.
+type Node struct {
+	httpServer *httptest.Server
+
+	lock      sync.RWMutex
+	rpcServer *rpc.Server // replaced on Disconnect, which closes all connections of the previous one
+	isDown    bool
+	isSyncing bool
+	delay     time.Duration
+
+	blockByHash   map[common.Hash]*types.Block
+	blockByNumber map[uint64]*types.Block // chain of the head
+	head          *types.Block
+	subscriptions map[rpc.ID]chan *types.Header
+
+	numConnections int64 // websocket connections and HTTP requests accepted
+}
+
+func NewNode() *Node {
+	node := &Node{
+		blockByHash:   make(map[common.Hash]*types.Block),
+		blockByNumber: make(map[uint64]*types.Block),
+		subscriptions: make(map[rpc.ID]chan *types.Header),
+	}
+	node.rpcServer = node.newRPCServer()
+	node.httpServer = httptest.NewServer(http.HandlerFunc(node.serveHTTP))
+	return node
+}
+
+// WsURL is the websocket endpoint, which supports subscriptions
+func (node *Node) WsURL() string {
+	return "ws" + strings.TrimPrefix(node.httpServer.URL, "http")
+}
+
+// HttpURL is the HTTP endpoint (no subscriptions)
+func (node *Node) HttpURL() string {
+	return node.httpServer.URL
+}
+
+// Close stops the node and closes all connections
+func (node *Node) Close() {
+	node.lock.Lock()
+	node.rpcServer.Stop()
+	node.lock.Unlock()
+	node.httpServer.Close()
+}
+
+func (node *Node) newRPCServer() *rpc.Server {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &ethAPI{node: node}); err != nil {
+		panic(err)
+	}
+	return server
+}
+
+func (node *Node) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	node.lock.Lock()
+	if node.isDown {
+		node.lock.Unlock()
+		http.Error(w, "node is down", http.StatusServiceUnavailable)
+		return
+	}
+	node.numConnections += 1
+	server := node.rpcServer
+	node.lock.Unlock()
+
+	if r.Header.Get("Upgrade") == "websocket" {
+		server.WebsocketHandler([]string{"*"}).ServeHTTP(w, r)
+		return
+	}
+	server.ServeHTTP(w, r)
+}
+
+// AddBlocks makes blocks available by hash, without announcing them (eg. uncles, or parents of a later head)
+func (node *Node) AddBlocks(blocks ...*types.Block) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	for _, block := range blocks {
+		node.blockByHash[block.Hash()] = block
+	}
+}
+
+// Announce adds a block, makes it the head, and sends its header to all newHeads subscriptions. The canonical chain
+// (blocks by number) is updated by walking back through the known parents of the new head.
+func (node *Node) Announce(block *types.Block) {
+	node.lock.Lock()
+	node.blockByHash[block.Hash()] = block
+	node.head = block
+	for number := range node.blockByNumber {
+		if number > block.NumberU64() {
+			delete(node.blockByNumber, number)
+		}
+	}
+	for b := block; b != nil; b = node.blockByHash[b.ParentHash()] {
+		if node.blockByNumber[b.NumberU64()] == b {
+			break
+		}
+		node.blockByNumber[b.NumberU64()] = b
+	}
+
+	subscriptions := make([]chan *types.Header, 0, len(node.subscriptions))
+	for _, headers := range node.subscriptions {
+		subscriptions = append(subscriptions, headers)
+	}
+	node.lock.Unlock()
+
+	for _, headers := range subscriptions {
+		select {
+		case headers <- block.Header():
+		default: // like a node dropping notifications for a slow subscriber
+		}
+	}
+}
+
+// SetSyncing makes eth_syncing report a sync in progress
+func (node *Node) SetSyncing(isSyncing bool) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	node.isSyncing = isSyncing
+}
+
+// SetDelay delays all responses to block requests
+func (node *Node) SetDelay(delay time.Duration) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	node.delay = delay
+}
+
+// SetDown makes the node refuse all new connections (existing ones stay open, see Disconnect)
+func (node *Node) SetDown(isDown bool) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	node.isDown = isDown
+}
+
+// Disconnect closes all connections, which ends their subscriptions. New connections are accepted unless the node
+// is down.
+func (node *Node) Disconnect() {
+	node.lock.Lock()
+	server := node.rpcServer
+	node.rpcServer = node.newRPCServer()
+	node.lock.Unlock()
+	server.Stop()
+}
+
+// NumConnections returns the number of accepted websocket connections and HTTP requests
+func (node *Node) NumConnections() int64 {
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+	return node.numConnections
+}
+
+// NumSubscriptions returns the number of active newHeads subscriptions
+func (node *Node) NumSubscriptions() int {
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+	return len(node.subscriptions)
+}
+
+// Step is one step of a script. Each step runs its actions in the order of the fields, and then waits for Wait. The
+// response delay is set first, so that it already applies to the requests triggered by the announced blocks.
+type Step struct {
+	Delay      *time.Duration
+	Add        []*types.Block // served by hash, but not announced
+	Announce   []*types.Block
+	Syncing    *bool
+	Down       *bool
+	Disconnect bool // closes all connections, after the node went down if Down is set
+	Wait       time.Duration
+}
+
+// Play runs a script, and returns early with ctx.Err() if ctx is cancelled
+func (node *Node) Play(ctx context.Context, script []Step) error {
+	for _, step := range script {
+		if step.Delay != nil {
+			node.SetDelay(*step.Delay)
+		}
+		node.AddBlocks(step.Add...)
+		for _, block := range step.Announce {
+			node.Announce(block)
+		}
+		if step.Syncing != nil {
+			node.SetSyncing(*step.Syncing)
+		}
+		if step.Down != nil {
+			node.SetDown(*step.Down)
+		}
+		if step.Disconnect {
+			node.Disconnect()
+		}
+
+		select {
+		case <-time.After(step.Wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// ethAPI implements the eth namespace. Methods are named like the JSON-RPC methods without the "eth_" prefix.
+type ethAPI struct {
+	node *Node
+}
+
+func (api *ethAPI) wait(ctx context.Context) error {
+	api.node.lock.RLock()
+	delay := api.node.delay
+	api.node.lock.RUnlock()
+
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (api *ethAPI) Syncing() (interface{}, error) {
+	api.node.lock.RLock()
+	defer api.node.lock.RUnlock()
+	if !api.node.isSyncing {
+		return false, nil
+	}
+
+	var current uint64
+	if api.node.head != nil {
+		current = api.node.head.NumberU64()
+	}
+	return map[string]interface{}{
+		"startingBlock": hexutil.Uint64(0),
+		"currentBlock":  hexutil.Uint64(current),
+		"highestBlock":  hexutil.Uint64(current + 100),
+	}, nil
+}
+
+// NewHeads is the newHeads subscription (eth_subscribe)
+func (api *ethAPI) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+
+	sub := notifier.CreateSubscription()
+	headers := make(chan *types.Header, 100)
+	api.node.lock.Lock()
+	api.node.subscriptions[sub.ID] = headers
+	api.node.lock.Unlock()
+
+	go func() {
+		defer func() {
+			api.node.lock.Lock()
+			delete(api.node.subscriptions, sub.ID)
+			api.node.lock.Unlock()
+		}()
+
+		for {
+			select {
+			case header := <-headers:
+				if err := notifier.Notify(sub.ID, header); err != nil {
+					return
+				}
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func (api *ethAPI) GetBlockByHash(ctx context.Context, hash common.Hash, fullTx bool) (map[string]interface{}, error) {
+	if err := api.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	api.node.lock.RLock()
+	block := api.node.blockByHash[hash]
+	api.node.lock.RUnlock()
+	if block == nil {
+		return nil, nil
+	}
+	return marshalBlock(block, fullTx)
+}
+
+func (api *ethAPI) GetBlockByNumber(ctx context.Context, number rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
+	if err := api.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	api.node.lock.RLock()
+	var block *types.Block
+	if number < 0 { // latest, pending, safe, finalized
+		block = api.node.head
+	} else {
+		block = api.node.blockByNumber[uint64(number)]
+	}
+	api.node.lock.RUnlock()
+	if block == nil {
+		return nil, nil
+	}
+	return marshalBlock(block, fullTx)
+}
+
+func (api *ethAPI) GetUncleByBlockHashAndIndex(ctx context.Context, hash common.Hash, index hexutil.Uint) (map[string]interface{}, error) {
+	api.node.lock.RLock()
+	block := api.node.blockByHash[hash]
+	api.node.lock.RUnlock()
+	if block == nil || int(index) >= len(block.Uncles()) {
+		return nil, nil
+	}
+	return marshalHeader(block.Uncles()[index])
+}
+
+func marshalHeader(header *types.Header) (map[string]interface{}, error) {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// marshalBlock returns the JSON-RPC representation of a block, like geth's eth_getBlockBy* (without the sender of
+// transactions)
+func marshalBlock(block *types.Block, fullTx bool) (map[string]interface{}, error) {
+	fields, err := marshalHeader(block.Header())
+	if err != nil {
+		return nil, err
+	}
+	fields["size"] = hexutil.Uint64(block.Size())
+
+	txs := make([]interface{}, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		if !fullTx {
+			txs[i] = tx.Hash()
+			continue
+		}
+
+		data, err := json.Marshal(tx)
+		if err != nil {
+			return nil, err
+		}
+		txFields := make(map[string]interface{})
+		if err := json.Unmarshal(data, &txFields); err != nil {
+			return nil, err
+		}
+		txFields["blockHash"] = block.Hash()
+		txFields["blockNumber"] = (*hexutil.Big)(block.Number())
+		txFields["transactionIndex"] = hexutil.Uint64(i)
+		txs[i] = txFields
+	}
+	fields["transactions"] = txs
+
+	uncles := make([]common.Hash, len(block.Uncles()))
+	for i, uncle := range block.Uncles() {
+		uncles[i] = uncle.Hash()
+	}
+	fields["uncles"] = uncles
+	return fields, nil
+}
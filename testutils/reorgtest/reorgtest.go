@@ -0,0 +1,67 @@
+// Package reorgtest builds reorgs from chaingen descriptions for tests. It only depends on analysis and chaingen, so
+// that the tests of the database and notify packages, and the external tests of analysis, can use it without an import
+// cycle (testutils depends on the monitor).
+package reorgtest
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/reorg-monitor/analysis"
+	"github.com/flashbots/reorg-monitor/testutils/chaingen"
+)
+
+const (
+	ParentNumber = 100         // height of the common parent P
+	NodeUri      = "ws://node" // node the blocks are received from
+)
+
+// NewBlocks generates the blocks of a chaingen description, with the transactions by block label, and returns them by
+// label. They are received from the subscription to NodeUri, and can be changed before building the reorg.
+func NewBlocks(t testing.TB, description string, txs map[string][]*types.Transaction) map[string]*analysis.Block {
+	t.Helper()
+	spec, err := chaingen.ParseSpec(ParentNumber, description)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.Txs = txs
+	chain, err := spec.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := make(map[string]*analysis.Block)
+	for _, ethBlock := range chain.Blocks {
+		blocks[chain.Label(ethBlock.Hash())] = analysis.NewBlock(ethBlock, analysis.OriginSubscription, NodeUri, 0)
+	}
+	return blocks
+}
+
+// NewReorg adds the blocks to a new tree, and returns the reorg from the common parent P to the highest block
+func NewReorg(t testing.TB, blocks map[string]*analysis.Block) *analysis.Reorg {
+	t.Helper()
+	sorted := make([]*analysis.Block, 0, len(blocks))
+	for _, block := range blocks {
+		sorted = append(sorted, block)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Number != sorted[j].Number {
+			return sorted[i].Number < sorted[j].Number
+		}
+		return sorted[i].Hash.Hex() < sorted[j].Hash.Hex()
+	})
+
+	tree := analysis.NewBlockTree()
+	for _, block := range sorted {
+		if err := tree.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reorg, err := analysis.NewReorg(tree.NodeByHash[blocks[chaingen.ParentLabel].Hash], sorted[len(sorted)-1].Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reorg
+}
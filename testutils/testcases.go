@@ -15,6 +15,23 @@ type ReorgTestResult struct {
 	NumReplacedBlocks int
 }
 
+// MainnetTestCases are the test cases of mainnet reorgs with an expected result, by reorg id. They need an archive
+// node, or can be recorded into fixtures (named by MainnetFixtureName) with reorg-monitor-test -record.
+var MainnetTestCases = map[string]TestCase{
+	"12996760_12996760_d1_b2":           Test_12996760_12996760_d1_b2,
+	"12996750_12996750_d1_b3_twouncles": Test_12996750_12996750_d1_b3_twouncles,
+	"12991732_12991733_d2_b4":           Test_12991732_12991733_d2_b4,
+	"12969887_12969889_d3_b6":           Test_12969887_12969889_d3_b6,
+	"13017535_13017536_d2_b5":           Test_13017535_13017536_d2_b5,
+	"13018369_13018370_d2_b4":           Test_13018369_13018370_d2_b4,
+	"13033424_13033425_d2_b5":           Test_13033424_13033425_d2_b5,
+}
+
+// MainnetFixtureName is the name of the recorded fixture of a mainnet test case
+func MainnetFixtureName(id string) string {
+	return "mainnet_" + id
+}
+
 var TestD1and2 = TestCase{
 	// https://mermaid-js.github.io/mermaid-live-editor/view/#eyJjb2RlIjoic3RhdGVEaWFncmFtLXYyXG4gICAgMHhkMzE0NTMyNjgyZWM1Mjc3MzMxYjg1N2E2OGJmMjIwM2I1MjBhNGZkMWM4NWJlNzViMDMyOTY1OGYxOTY2YjAyIC0tPiAweDdkZTk2OWNkNGI4M2MwYTdmN2E0MjMxYmY2MDY0N2MyNDBiYzE2YTAxZmRmZGNhYjE1ZWU4Zjg3MjA1MDFjOTRcbiAgICAweGQzMTQ1MzI2ODJlYzUyNzczMzFiODU3YTY4YmYyMjAzYjUyMGE0ZmQxYzg1YmU3NWIwMzI5NjU4ZjE5NjZiMDIgLS0-IDB4MGFhMmMzYWI4MmQ4ODI3ZWUzYTIyNTIxZjQyNDE5NDFmYzdhZTAwZjVkZjk5NGNlNmY4OGZhZGQyNDRkZjQwYlxuICAgIDB4N2RlOTY5Y2Q0YjgzYzBhN2Y3YTQyMzFiZjYwNjQ3YzI0MGJjMTZhMDFmZGZkY2FiMTVlZThmODcyMDUwMWM5NCAtLT4gMHhhOTdjY2MwM2U4NmUzODVkYzRiOWJjMDNhZWU5OTc2YWQ5ODliYWZmMTExN2JhYmZhNDdhNWFlZjg3ZTg2ODAzXG4gICAgMHgwYWEyYzNhYjgyZDg4MjdlZTNhMjI1MjFmNDI0MTk0MWZjN2FlMDBmNWRmOTk0Y2U2Zjg4ZmFkZDI0NGRmNDBiIC0tPiAweGY5MTAxMzA0NDQ1YWViYzFkMmNlNmFlYzczNWM0YWU1OTY5Y2Q1YjQ1YmUxNzYzNzZiNjgwMmE2MTAwYjc1YzNcbiAgICAweGU2NWEwMTYzZWNkODI5YWVkODkwZWZkMDg4NWIwMGM3Yzg4NjEwZGVmYzFkYjZiNTU4NTRlYjc3M2RkOTg1N2UgLS0-IDB4ZDMxNDUzMjY4MmVjNTI3NzMzMWI4NTdhNjhiZjIyMDNiNTIwYTRmZDFjODViZTc1YjAzMjk2NThmMTk2NmIwMlxuICAgIDB4ZTY1YTAxNjNlY2Q4MjlhZWQ4OTBlZmQwODg1YjAwYzdjODg2MTBkZWZjMWRiNmI1NTg1NGViNzczZGQ5ODU3ZSAtLT4gMHhhODVmZjhlYzc2YWRjYWE4MGE0ZjhjNzkzMzUyYWYwNmY0M2ZjNWZlZTY3ZjE5NjFkOTkyODgyMjg0ODA3YzUxXG4gICAgMHhmOTEwMTMwNDQ0NWFlYmMxZDJjZTZhZWM3MzVjNGFlNTk2OWNkNWI0NWJlMTc2Mzc2YjY4MDJhNjEwMGI3NWMzIC0tPiAweGYzMGYwMjRkZjc5NzE0N2ZmNjFiMDZhNzRmMGM1NjllNzg0MGExYjRkOTc1ODMxNmUzOGJiYmQ5MDhiMzdlYjgiLCJtZXJtYWlkIjoie1xuICBcInRoZW1lXCI6IFwiZGVmYXVsdFwiXG59IiwidXBkYXRlRWRpdG9yIjp0cnVlLCJhdXRvU3luYyI6dHJ1ZSwidXBkYXRlRGlhZ3JhbSI6dHJ1ZX0
 	BlockInfo: []string{
@@ -60,7 +60,7 @@ func BlocksForStrings(blockStrings []string) (ret []*types.Block) {
 
 func ReorgCheckAndPrint() {
 	fmt.Println("\n---\n ")
-	analysis, err := Monitor.AnalyzeTree(100, 0)
+	analysis, err := Monitor.AnalyzeTree(context.Background(), 100, 0)
 	if err != nil {
 		fmt.Println(err)
 		return